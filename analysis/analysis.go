@@ -0,0 +1,184 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+	"toyvm/ast"
+	"toyvm/token"
+)
+
+// 静态检查发现的问题，跟编译错误不同，它不影响程序的编译和执行
+type Warning struct {
+	Message string
+	Line    int
+	Column  int
+	Token   token.Token
+}
+
+// 返回包括位置的警告信息，格式跟 parser.ParseError 相同
+func (w Warning) String() string {
+	return fmt.Sprintf("%d:%d: %s", w.Line, w.Column, w.Message)
+}
+
+// 由 let（或者 const）语句定义的绑定
+type binding struct {
+	statement *ast.LetStatement
+	used      bool
+
+	// 是否正在检查该绑定的值，此时对它的引用是递归函数对自身的调用，
+	// 不算作被使用
+	defining bool
+}
+
+// 名称的范围，跟编译器的符号表一一对应：全局、函数以及语句块（if 的分支等）
+type scope struct {
+	bindings map[string]*binding
+	outer    *scope
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{bindings: map[string]*binding{}, outer: outer}
+}
+
+// 查找名称对应的绑定，名称是函数的参数、内置函数或者未定义时返回 nil
+func (s *scope) resolve(name string) *binding {
+	for current := s; current != nil; current = current.outer {
+		if b, ok := current.bindings[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+type checker struct {
+	scope    *scope
+	bindings []*binding // 按照定义的顺序排列的所有绑定
+}
+
+// 检查程序里定义之后从未被引用的 let 绑定，按照在源码里出现的顺序返回警告。
+// 在闭包里引用外层的绑定也算作被使用，但是递归函数只在自身的主体里引用自己不算。
+// 注：
+// - 名称以 "_" 开头的绑定不检查，用于表示有意不使用的值；
+// - 对绑定重新赋值（比如 `x = 1`）也算作被使用；
+// - letrec 语句用于定义相互递归的函数，不检查。
+func UnusedBindings(program *ast.Program) []Warning {
+	c := &checker{scope: newScope(nil)}
+	c.check(program)
+
+	warnings := []Warning{}
+	for _, b := range c.bindings {
+		name := b.statement.Name
+		if b.used || strings.HasPrefix(name.Value, "_") {
+			continue
+		}
+
+		kind := "variable"
+		if b.statement.IsConst() {
+			kind = "constant"
+		}
+		warnings = append(warnings, Warning{
+			Message: fmt.Sprintf("unused %s %s", kind, name.Value),
+			Line:    name.Token.Line,
+			Column:  name.Token.Column,
+			Token:   name.Token,
+		})
+	}
+	return warnings
+}
+
+func (c *checker) check(node ast.Node) {
+	ast.Walk(node, c.visit)
+}
+
+// 处理定义和引用名称的节点，以及会产生新范围的节点（自行访问其子节点，所以返回 false），
+// 其余节点返回 true 由 ast.Walk 继续访问其子节点
+func (c *checker) visit(node ast.Node) bool {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		// 跟编译器一样先定义名称再检查值，所以值里面的同名标识符引用的是新的绑定
+		b := &binding{statement: node}
+		c.scope.bindings[node.Name.Value] = b
+		c.bindings = append(c.bindings, b)
+
+		b.defining = true
+		c.checkExpression(node.Value)
+		b.defining = false
+		return false
+
+	case *ast.LetRecStatement:
+		// 名称视为没有绑定（跟函数参数一样）以覆盖外层的同名绑定
+		for _, name := range node.Names {
+			c.scope.bindings[name.Value] = nil
+		}
+		for _, value := range node.Values {
+			c.checkExpression(value)
+		}
+		return false
+
+	case *ast.Identifier:
+		if b := c.scope.resolve(node.Value); b != nil && !b.defining {
+			b.used = true
+		}
+		return false
+
+	case *ast.BlockStatement:
+		c.enterScope()
+		for _, statement := range node.Statements {
+			c.check(statement)
+		}
+		c.leaveScope()
+		return false
+
+	case *ast.BlockExpression:
+		c.enterScope()
+		for _, statement := range node.Statements {
+			c.check(statement)
+		}
+		c.leaveScope()
+		return false
+
+	case *ast.TryExpression:
+		c.check(node.Body)
+
+		c.enterScope()
+		if node.Parameter != nil {
+			c.scope.bindings[node.Parameter.Value] = nil
+		}
+		c.check(node.Handler)
+		c.leaveScope()
+		return false
+
+	case *ast.FunctionLiteral:
+		// 参数的默认值在函数之外求值
+		for _, value := range node.Defaults {
+			c.checkExpression(value)
+		}
+
+		// 函数的参数跟主体里定义的变量属于同一个范围
+		c.enterScope()
+		for _, parameter := range node.Parameters {
+			c.scope.bindings[parameter.Value] = nil
+		}
+		for _, statement := range node.Body.Statements {
+			c.check(statement)
+		}
+		c.leaveScope()
+		return false
+	}
+
+	return true
+}
+
+func (c *checker) checkExpression(expression ast.Expression) {
+	if expression != nil {
+		c.check(expression)
+	}
+}
+
+func (c *checker) enterScope() {
+	c.scope = newScope(c.scope)
+}
+
+func (c *checker) leaveScope() {
+	c.scope = c.scope.outer
+}
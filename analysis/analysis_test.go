@@ -0,0 +1,61 @@
+package analysis
+
+import (
+	"testing"
+	"toyvm/lexer"
+	"toyvm/parser"
+)
+
+func TestUnusedBindings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let used = 1; let unused = 2; used + 1", []string{"1:19: unused variable unused"}},
+		{"const max = 10; 1", []string{"1:7: unused constant max"}},
+		{"let a = 1; let f = fn() { a }; f()", []string{}},
+		// 在闭包的闭包里引用外层的局部变量
+		{"let f = fn(x) { let y = x; fn() { fn() { y } } }; f(1)", []string{}},
+		// 递归函数只在自身的主体里引用自己
+		{"let f = fn(n) { if (n > 0) { f(n - 1) } }; 1", []string{"1:5: unused variable f"}},
+		{"let f = fn(n) { if (n > 0) { f(n - 1) } }; f(3)", []string{}},
+		// 语句块里的同名绑定覆盖外层的绑定
+		{"let a = 1; if (true) { let a = 2; a }", []string{"1:5: unused variable a"}},
+		{"let a = 1; if (true) { let b = a; 2 }", []string{"1:28: unused variable b"}},
+		// 参数以及 catch 的参数覆盖外层的同名绑定
+		{"let a = 1; let f = fn(a) { a }; f(2)", []string{"1:5: unused variable a"}},
+		{"let e = 1; try { 2 } catch (e) { e }", []string{"1:5: unused variable e"}},
+		// 参数的默认值在函数之外求值
+		{"let d = 1; let f = fn(x = d) { x }; f()", []string{}},
+		{"let a = 1; a = 2", []string{}},
+		{"let _ignored = 1; 2", []string{}},
+		{"letrec { even = fn(n) { odd(n) }; odd = fn(n) { even(n) } }; 1", []string{}},
+		{
+			"let a = 1;\nlet b = fn() { let c = a; };\n{ let d = b; }",
+			[]string{"2:20: unused variable c", "3:7: unused variable d"},
+		},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors for %q: %v", test.input, p.Errors().Strings())
+		}
+
+		warnings := UnusedBindings(program)
+		if len(warnings) != len(test.expected) {
+			t.Errorf("wrong number of warnings for %q, expected %v, actual %v",
+				test.input, test.expected, warnings)
+			continue
+		}
+
+		for i, expected := range test.expected {
+			if warnings[i].String() != expected {
+				t.Errorf("wrong warning for %q, expected %q, actual %q",
+					test.input, expected, warnings[i].String())
+			}
+		}
+	}
+}
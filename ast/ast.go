@@ -52,11 +52,17 @@ func (p *Program) String() string {
 }
 
 type LetStatement struct {
-	Token token.Token // let 语句的开始 token，必定是 LET token
+	Token token.Token // let 语句的开始 token，LET 或者 CONST token
 	Name  *Identifier
 	Value Expression
 }
 
+// 是否为 const 语句，即定义不可重新赋值的绑定
+// e.g. "const max = 10;"
+func (ls *LetStatement) IsConst() bool {
+	return ls.Token.Type == token.CONST
+}
+
 func (ls *LetStatement) statementNode() {
 	// 实现接口 Statement 的方法 statementNode()
 	// 用于表明 LetStatement 是一个 Statement
@@ -79,6 +85,31 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+// 可相互递归的绑定语句，在编译各个值之前先定义所有的名称
+// e.g. "letrec { isEven = fn(n) {...}; isOdd = fn(n) {...} }"
+type LetRecStatement struct {
+	Token  token.Token // the 'letrec' token
+	Names  []*Identifier
+	Values []Expression
+}
+
+func (ls *LetRecStatement) statementNode()       {}
+func (ls *LetRecStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetRecStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(ls.TokenLiteral() + " { ")
+	for i, name := range ls.Names {
+		out.WriteString(name.String())
+		out.WriteString(" = ")
+		if ls.Values[i] != nil {
+			out.WriteString(ls.Values[i].String())
+		}
+		out.WriteString("; ")
+	}
+	out.WriteString("}")
+	return out.String()
+}
+
 type Identifier struct {
 	Token token.Token // the IDENT token
 	Value string
@@ -175,6 +206,31 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+// 赋值表达式
+// e.g.
+// x = 1
+// x += 1
+// arr[0] = 1
+// 赋值表达式的值是被赋予的值，所以可以连续赋值，比如 `a = b = 1`
+type AssignExpression struct {
+	Token    token.Token // 赋值运算符 token，即 = += -= *= /=
+	Target   Expression  // 被赋值的对象，标识符或者索引表达式
+	Operator string      // 运算符的符号
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+	return out.String()
+}
+
 // BooleanLiteral
 type Boolean struct {
 	Token token.Token
@@ -211,6 +267,96 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// try 表达式
+// e.g. "try { a / b } catch (e) { 0 }"
+// 执行 Body 时发生运行时错误，则把错误绑定到 Parameter 并执行 Handler，
+// 表达式的值是 Body 或者 Handler 的值
+type TryExpression struct {
+	Token     token.Token // The 'try' token
+	Body      *BlockStatement
+	Parameter *Identifier
+	Handler   *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("try ")
+	out.WriteString(te.Body.String())
+	out.WriteString(" catch (")
+	out.WriteString(te.Parameter.String())
+	out.WriteString(") ")
+	out.WriteString(te.Handler.String())
+	return out.String()
+}
+
+// match 表达式的一个分支
+type MatchArm struct {
+	Pattern Expression // 跟被匹配的值比较（==）的表达式
+	Body    Expression // 匹配成功时的值
+}
+
+func (ma *MatchArm) String() string {
+	return ma.Pattern.String() + " => " + ma.Body.String()
+}
+
+// match 表达式
+// e.g. "match x { 1 => a, 2 => b, _ => c }"
+// 按顺序把 Subject 跟各个分支的 Pattern 比较，表达式的值是第一个相等的分支的 Body，
+// 没有分支相等时是默认分支（`_`）的值，没有默认分支时则是 null
+type MatchExpression struct {
+	Token   token.Token // The 'match' token
+	Subject Expression
+	Arms    []*MatchArm
+	Default Expression // 默认分支的值，可能为 nil
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, arm := range me.Arms {
+		arms = append(arms, arm.String())
+	}
+	if me.Default != nil {
+		arms = append(arms, "_ => "+me.Default.String())
+	}
+
+	out.WriteString("match ")
+	out.WriteString(me.Subject.String())
+	out.WriteString(" {")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// 条件表达式（三元运算）
+// e.g. "a > b ? a : b"
+type ConditionalExpression struct {
+	Token       token.Token // the '?' token
+	Condition   Expression
+	Consequence Expression
+	Alternative Expression
+}
+
+func (ce *ConditionalExpression) expressionNode()      {}
+func (ce *ConditionalExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *ConditionalExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(ce.Condition.String())
+	out.WriteString(" ? ")
+	out.WriteString(ce.Consequence.String())
+	out.WriteString(" : ")
+	out.WriteString(ce.Alternative.String())
+	out.WriteString(")")
+	return out.String()
+}
+
 type BlockStatement struct {
 	Token      token.Token // the { token
 	Statements []Statement
@@ -234,11 +380,36 @@ func (bs *BlockStatement) String() string {
 	return out.String()
 }
 
+// 语句块表达式，其值为最后一个表达式语句的值
+// e.g. "{ let a = 1; a + 2 }"
+// 注：
+// 语句块表达式跟映射表字面量都以 "{" 开始，映射表字面量的成员是 "key: value"，
+// 而空的花括号 "{}" 视为空映射表。
+type BlockExpression struct {
+	Token      token.Token // the { token
+	Statements []Statement
+}
+
+func (be *BlockExpression) expressionNode()      {}
+func (be *BlockExpression) TokenLiteral() string { return be.Token.Literal }
+func (be *BlockExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("{")
+	for _, s := range be.Statements {
+		out.WriteString(s.String())
+	}
+	out.WriteString("}")
+
+	return out.String()
+}
+
 // 函数字面量（匿名函数）
 // e.g. "fn(x, y) { x + y; }""
 type FunctionLiteral struct {
 	Token      token.Token     // The 'fn' token
 	Parameters []*Identifier   // 参数列表
+	Defaults   []Expression    // 最后 len(Defaults) 个参数的默认值
 	Body       *BlockStatement // 函数体
 	Name       string          // ++
 }
@@ -248,8 +419,13 @@ func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+	firstDefault := len(fl.Parameters) - len(fl.Defaults)
+	for i, p := range fl.Parameters {
+		if i >= firstDefault {
+			params = append(params, p.String()+" = "+fl.Defaults[i-firstDefault].String())
+		} else {
+			params = append(params, p.String())
+		}
 	}
 	out.WriteString(fl.TokenLiteral())
 	if fl.Name != "" {
@@ -333,6 +509,7 @@ func (ie *IndexExpression) String() string {
 type HashLiteral struct {
 	Token token.Token // the '{' token
 	Pairs map[Expression]Expression
+	Keys  []Expression // Pairs 的 key，按照在源码里出现的顺序排列
 }
 
 func (hl *HashLiteral) expressionNode()      {}
@@ -340,8 +517,8 @@ func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
@@ -37,3 +37,97 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. actual %q", program.String())
 	}
 }
+
+func TestWalk(t *testing.T) {
+	integer := func(value int64) *IntegerLiteral { return &IntegerLiteral{Value: value} }
+	a := &Identifier{Value: "a"}
+
+	// let a = [1, 2 + 3];
+	// if (a[0] > 1) { fn(x = 4) { x * 5 } } else { {"k": 6} };
+	// puts(a);
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Name: a,
+				Value: &ArrayLiteral{Elements: []Expression{
+					integer(1),
+					&InfixExpression{Operator: "+", Left: integer(2), Right: integer(3)},
+				}},
+			},
+			&ExpressionStatement{Expression: &IfExpression{
+				Condition: &InfixExpression{
+					Operator: ">",
+					Left:     &IndexExpression{Left: a, Index: integer(0)},
+					Right:    integer(1),
+				},
+				Consequence: &BlockStatement{Statements: []Statement{
+					&ExpressionStatement{Expression: &FunctionLiteral{
+						Parameters: []*Identifier{{Value: "x"}},
+						Defaults:   []Expression{integer(4)},
+						Body: &BlockStatement{Statements: []Statement{
+							&ExpressionStatement{Expression: &InfixExpression{
+								Operator: "*", Left: &Identifier{Value: "x"}, Right: integer(5),
+							}},
+						}},
+					}},
+				}},
+				Alternative: &BlockStatement{Statements: []Statement{
+					&ExpressionStatement{Expression: &HashLiteral{
+						Pairs: map[Expression]Expression{&StringLiteral{Value: "k"}: integer(6)},
+					}},
+				}},
+			}},
+			&ExpressionStatement{Expression: &CallExpression{
+				Function:  &Identifier{Value: "puts"},
+				Arguments: []Expression{a},
+			}},
+		},
+	}
+	// HashLiteral 按照 Keys 的顺序访问
+	hash := program.Statements[1].(*ExpressionStatement).Expression.(*IfExpression).
+		Alternative.Statements[0].(*ExpressionStatement).Expression.(*HashLiteral)
+	for key := range hash.Pairs {
+		hash.Keys = append(hash.Keys, key)
+	}
+
+	values := []int64{}
+	identifiers := 0
+	Walk(program, func(node Node) bool {
+		switch node := node.(type) {
+		case *IntegerLiteral:
+			values = append(values, node.Value)
+		case *Identifier:
+			identifiers++
+		}
+		return true
+	})
+
+	expected := []int64{1, 2, 3, 0, 1, 4, 5, 6}
+	if len(values) != len(expected) {
+		t.Fatalf("wrong number of IntegerLiteral, expected %d, actual %d", len(expected), len(values))
+	}
+	for i, value := range expected {
+		if values[i] != value {
+			t.Errorf("wrong visiting order, expected %v, actual %v", expected, values)
+			break
+		}
+	}
+
+	if identifiers != 6 {
+		t.Errorf("wrong number of Identifier, expected 6, actual %d", identifiers)
+	}
+
+	// 返回 false 时不访问函数字面量的子节点
+	count := 0
+	Walk(program, func(node Node) bool {
+		if _, ok := node.(*IntegerLiteral); ok {
+			count++
+		}
+		_, isFunction := node.(*FunctionLiteral)
+		return !isFunction
+	})
+
+	if count != 6 {
+		t.Errorf("wrong number of IntegerLiteral when pruning, expected 6, actual %d", count)
+	}
+}
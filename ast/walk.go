@@ -0,0 +1,131 @@
+package ast
+
+// 深度优先遍历语法树，先访问节点本身，再按源码的顺序访问其子节点。
+// fn 返回 false 时不再访问该节点的子节点（但会继续访问其兄弟节点），
+// 用于优化、静态检查等需要遍历整个语法树的场合。
+// 注：
+// 值为 nil 的子节点（比如没有 else 分支的 if 表达式的 Alternative）不会被访问
+func Walk(node Node, fn func(Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		walkStatements(node.Statements, fn)
+
+	case *LetStatement:
+		Walk(node.Name, fn)
+		walkExpression(node.Value, fn)
+
+	case *LetRecStatement:
+		for i, name := range node.Names {
+			Walk(name, fn)
+			if i < len(node.Values) {
+				walkExpression(node.Values[i], fn)
+			}
+		}
+
+	case *ReturnStatement:
+		walkExpression(node.ReturnValue, fn)
+
+	case *ExpressionStatement:
+		walkExpression(node.Expression, fn)
+
+	case *BlockStatement:
+		walkStatements(node.Statements, fn)
+
+	case *BlockExpression:
+		walkStatements(node.Statements, fn)
+
+	case *PrefixExpression:
+		walkExpression(node.Right, fn)
+
+	case *InfixExpression:
+		walkExpression(node.Left, fn)
+		walkExpression(node.Right, fn)
+
+	case *AssignExpression:
+		walkExpression(node.Target, fn)
+		walkExpression(node.Value, fn)
+
+	case *IfExpression:
+		walkExpression(node.Condition, fn)
+		walkBlock(node.Consequence, fn)
+		walkBlock(node.Alternative, fn)
+
+	case *TryExpression:
+		walkBlock(node.Body, fn)
+		if node.Parameter != nil {
+			Walk(node.Parameter, fn)
+		}
+		walkBlock(node.Handler, fn)
+
+	case *MatchExpression:
+		walkExpression(node.Subject, fn)
+		for _, arm := range node.Arms {
+			walkExpression(arm.Pattern, fn)
+			walkExpression(arm.Body, fn)
+		}
+		walkExpression(node.Default, fn)
+
+	case *ConditionalExpression:
+		walkExpression(node.Condition, fn)
+		walkExpression(node.Consequence, fn)
+		walkExpression(node.Alternative, fn)
+
+	case *FunctionLiteral:
+		for _, parameter := range node.Parameters {
+			Walk(parameter, fn)
+		}
+		walkExpressions(node.Defaults, fn)
+		walkBlock(node.Body, fn)
+
+	case *CallExpression:
+		walkExpression(node.Function, fn)
+		walkExpressions(node.Arguments, fn)
+
+	case *ArrayLiteral:
+		walkExpressions(node.Elements, fn)
+
+	case *IndexExpression:
+		walkExpression(node.Left, fn)
+		walkExpression(node.Index, fn)
+
+	case *HashLiteral:
+		for _, key := range node.Keys {
+			walkExpression(key, fn)
+			walkExpression(node.Pairs[key], fn)
+		}
+
+		// Identifier, IntegerLiteral, Boolean, StringLiteral 没有子节点
+	}
+}
+
+func walkStatements(statements []Statement, fn func(Node) bool) {
+	for _, statement := range statements {
+		if statement != nil {
+			Walk(statement, fn)
+		}
+	}
+}
+
+func walkExpressions(expressions []Expression, fn func(Node) bool) {
+	for _, expression := range expressions {
+		walkExpression(expression, fn)
+	}
+}
+
+func walkExpression(expression Expression, fn func(Node) bool) {
+	if expression != nil {
+		Walk(expression, fn)
+	}
+}
+
+// 注：
+// 不能直接把 nil 的 *BlockStatement 传给 Walk，否则接口值 Node 不为 nil
+func walkBlock(block *BlockStatement, fn func(Node) bool) {
+	if block != nil {
+		Walk(block, fn)
+	}
+}
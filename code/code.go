@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"strings"
 )
 
 type Instructions []byte
@@ -62,6 +63,24 @@ const (
 	OpGetFree // 读取闭包中捕获的局部变量的值
 
 	OpCurrentClosure
+
+	// 新增的指令追加在列表末尾，以保持已有指令的编号不变
+	// （保存的会话文件里包含编译后的指令）
+	OpPlus     // + （一元）
+	OpDup      // 复制栈顶的值
+	OpSwap     // 交换栈顶的两个值
+	OpSetIndex // 修改 Array 的元素，或者插入/更新 Hash 的键值对
+	OpMod      // 取余
+	OpLessThan // <
+	OpHalt     // 结束整个程序
+
+	OpSetHandler // 设置异常处理器（try 语句块开始）
+	OpPopHandler // 移除异常处理器（try 语句块正常结束）
+
+	OpIncrementGlobal // 全局变量的值 +1，即 `x = x + 1`
+	OpDecrementGlobal // 全局变量的值 -1，即 `x = x - 1`
+	OpIncrementLocal  // 局部变量的值 +1
+	OpDecrementLocal  // 局部变量的值 -1
 )
 
 // 操作码（指令）详细信息列表
@@ -76,6 +95,16 @@ var definitions = map[Opcode]*Definition{
 	// 参数：无
 	OpPop: {"OpPop", []int{}},
 
+	// OpDup
+	// 作用：复制栈顶的值并压入运算栈，比如赋值表达式在保存值之后，栈顶仍然需要保留该值作为表达式的值
+	// 参数：无
+	OpDup: {"OpDup", []int{}},
+
+	// OpSwap
+	// 作用：交换栈顶的两个值
+	// 参数：无
+	OpSwap: {"OpSwap", []int{}},
+
 	// OpAdd
 	// 作用：两个数相加
 	// 参数：无
@@ -84,6 +113,12 @@ var definitions = map[Opcode]*Definition{
 	OpMul: {"OpMul", []int{}},
 	OpDiv: {"OpDiv", []int{}},
 
+	// OpMod
+	// 作用：从运算栈弹出两个整数，求余数，并把结果压入运算栈
+	// 余数的符号跟被除数相同（跟 Go 一样），开启 VM 的 floor division 模式之后则跟除数相同
+	// 参数：无
+	OpMod: {"OpMod", []int{}},
+
 	// OpTrue/OpFalse
 	// 作用：向 stack 压入 True 或者 False
 	// 参数：无
@@ -91,16 +126,19 @@ var definitions = map[Opcode]*Definition{
 	OpFalse: {"OpFalse", []int{}},
 	OpNull:  {"OpNull", []int{}},
 
-	// OpEqual/OpNotEqual/OpGreaterThan
+	// OpEqual/OpNotEqual/OpGreaterThan/OpLessThan
 	// 比较运算
 	OpEqual:       {"OpEqual", []int{}},
 	OpNotEqual:    {"OpNotEqual", []int{}},
 	OpGreaterThan: {"OpGreaterThan", []int{}},
+	OpLessThan:    {"OpLessThan", []int{}},
 
-	// OpMinus/OpBang
+	// OpMinus/OpBang/OpPlus
 	// 一元操作
+	// OpPlus 不改变数值，只检查操作数是否为数字
 	OpMinus: {"OpMinus", []int{}},
 	OpBang:  {"OpBang", []int{}},
+	OpPlus:  {"OpPlus", []int{}},
 
 	// 非 true 时跳转
 	// 用于跳到 else 语句块开始位置，或者
@@ -135,6 +173,11 @@ var definitions = map[Opcode]*Definition{
 	// 栈顶是 "索引值"，栈倒数第二个数是 "Array 或 Map 对象"
 	OpIndex: {"OpIndex", []int{}},
 
+	// 修改 Array 的元素，或者插入/更新 Hash 的键值对（直接修改原对象）
+	// 栈顶是 "新的值"，倒数第二个是 "索引值"，倒数第三个是 "Array 或 Map 对象"，
+	// 弹出这三个值之后，把新的值压入栈，作为赋值表达式的值
+	OpSetIndex: {"OpSetIndex", []int{}},
+
 	// 调用位于栈顶的 object.CompiledFunction
 	// 参数：1. UInt8 调用函数时实参的数量
 	OpCall: {"OpCall", []int{1}},
@@ -145,6 +188,11 @@ var definitions = map[Opcode]*Definition{
 	// 返回 vm.Null
 	OpReturn: {"OpReturn", []int{}},
 
+	// OpHalt
+	// 作用：弹出栈顶的值作为程序的结果，并结束整个程序（不管当前位于哪一层调用帧）
+	// 参数：无
+	OpHalt: {"OpHalt", []int{}},
+
 	// 读写局部变量
 	// 参数：1. UInt8 目标在运算栈中的位置
 	OpGetLocal: {"OpGetLocal", []int{1}},
@@ -162,13 +210,43 @@ var definitions = map[Opcode]*Definition{
 	OpGetFree: {"OpGetFree", []int{1}},
 
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	// 设置异常处理器，即 try 语句块开始
+	// 执行过程中发生运行时错误时，VM 恢复运算栈和调用帧，把错误对象压入栈，然后跳转到 catch 语句块
+	// 参数：1. UInt16 catch 语句块的位置
+	OpSetHandler: {"OpSetHandler", []int{2}},
+
+	// 移除最近设置的异常处理器，即 try 语句块正常结束
+	OpPopHandler: {"OpPopHandler", []int{}},
+
+	// 把变量的值 +1 或者 -1 并保存，然后把新的值压入栈（作为赋值表达式的值），
+	// 相当于 `OpGetGlobal; OpConstant 1; OpAdd; OpDup; OpSetGlobal`，但不需要压入常量
+	// 参数：1. UInt16 目标在 global 列表里的位置
+	OpIncrementGlobal: {"OpIncrementGlobal", []int{2}},
+	OpDecrementGlobal: {"OpDecrementGlobal", []int{2}},
+
+	// 同上，作用于局部变量
+	// 参数：1. UInt8 目标在运算栈中的位置
+	OpIncrementLocal: {"OpIncrementLocal", []int{1}},
+	OpDecrementLocal: {"OpDecrementLocal", []int{1}},
+}
+
+// 以操作码为索引的定义表，由 definitions 生成。
+// 因为操作码是连续的（iota）且只占一个 byte，用数组代替 map 查找可以加快反汇编等需要
+// 逐条指令查找定义的过程。
+var definitionTable [256]*Definition
+
+func init() {
+	for op, def := range definitions {
+		definitionTable[op] = def
+	}
 }
 
 // 编译
 // 将 "操作码及其参数" 转换为字节数组
 func Make(op Opcode, operands ...int) []byte {
-	def, ok := definitions[op]
-	if !ok {
+	def := definitionTable[op]
+	if def == nil {
 		return []byte{}
 	}
 
@@ -204,6 +282,17 @@ func Make(op Opcode, operands ...int) []byte {
 // "0003 OpConstant 2"
 // "0006 OpConstant 65535"
 func (ins Instructions) String() string {
+	return ins.format(-1)
+}
+
+// 反汇编并在起始位置为 offset 的指令行前面加上 ">" 标记，
+// 其余的指令行前面加上空格以保持对齐，用于调试时查看当前执行的位置
+func (ins Instructions) StringWithMarker(offset int) string {
+	return ins.format(offset)
+}
+
+// 当 marker 为 -1 时不输出标记列
+func (ins Instructions) format(marker int) string {
 	var out bytes.Buffer
 	i := 0
 	for i < len(ins) {
@@ -211,6 +300,48 @@ func (ins Instructions) String() string {
 
 		if err != nil {
 			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i += 1 // 跳过无法识别的操作码，否则会一直停留在同一个位置
+			continue
+		}
+
+		if marker >= 0 {
+			if i == marker {
+				out.WriteString("> ")
+			} else {
+				out.WriteString("  ")
+			}
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		i += 1 + read
+	}
+	return out.String()
+}
+
+// 反汇编并在指令前面插入对应的源码行（类似 `objdump -S`），
+// 每当指令所属的行号发生变化时，输出一行 "; 行号: 源码"
+// lines 是指令位置跟行号的对应表，source 是完整的源码文本
+func (ins Instructions) StringWithSource(lines LineTable, source string) string {
+	sourceLines := strings.Split(source, "\n")
+
+	var out bytes.Buffer
+	lastLine := 0
+	i := 0
+	for i < len(ins) {
+		if line := lines.Line(i); line != lastLine && line > 0 {
+			text := ""
+			if line <= len(sourceLines) {
+				text = strings.TrimSpace(sourceLines[line-1])
+			}
+			fmt.Fprintf(&out, "; %d: %s\n", line, text)
+			lastLine = line
+		}
+
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i += 1
 			continue
 		}
 
@@ -243,6 +374,28 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
 }
 
+// 指令位置跟源码行号的对应关系中的一项，
+// 表示从 Offset 开始（直到下一项的 Offset 为止）的指令是由第 Line 行（从 1 开始）的源码生成的
+type LineEntry struct {
+	Offset int
+	Line   int
+}
+
+// 指令位置跟源码行号的对应表，各项按照 Offset 递增排列
+type LineTable []LineEntry
+
+// 返回位于 offset 的指令所对应的源码行号，没有记录时返回 0
+func (lt LineTable) Line(offset int) int {
+	line := 0
+	for _, entry := range lt {
+		if entry.Offset > offset {
+			break
+		}
+		line = entry.Line
+	}
+	return line
+}
+
 // 反编译
 // 将字节码当中————指令部分当中的————参数部分（一个 byte 数组） "还原" 为
 // 操作码的参数（Operands）列表（一个 int 数组）
@@ -275,8 +428,8 @@ func ReadUint8(ins Instructions) uint8 {
 // 当前仅用于测试
 // 在 VM 的执行过程中，为了效率而直接硬编码操作码的详细信息
 func Lookup(op byte) (*Definition, error) {
-	def, ok := definitions[Opcode(op)]
-	if !ok {
+	def := definitionTable[op]
+	if def == nil {
 		return nil, fmt.Errorf("opcode %d undefined", op)
 	}
 
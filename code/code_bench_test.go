@@ -0,0 +1,49 @@
+package code
+
+import "testing"
+
+// 构造一段较长的指令序列，包含不同长度的指令
+func largeInstructions() Instructions {
+	instructions := Instructions{}
+	for i := 0; i < 10000; i++ {
+		instructions = append(instructions, Make(OpConstant, i)...)
+		instructions = append(instructions, Make(OpGetLocal, i%256)...)
+		instructions = append(instructions, Make(OpClosure, i, i%256)...)
+		instructions = append(instructions, Make(OpAdd)...)
+	}
+	return instructions
+}
+
+// 逐条指令查找定义，比较数组索引（Lookup）和 map 查找的开销
+func BenchmarkLookup(b *testing.B) {
+	instructions := largeInstructions()
+
+	b.Run("table", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for i := 0; i < len(instructions); {
+				def, _ := Lookup(instructions[i])
+				_, read := ReadOperands(def, instructions[i+1:])
+				i += 1 + read
+			}
+		}
+	})
+
+	b.Run("map", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for i := 0; i < len(instructions); {
+				def := definitions[Opcode(instructions[i])]
+				_, read := ReadOperands(def, instructions[i+1:])
+				i += 1 + read
+			}
+		}
+	})
+}
+
+func BenchmarkInstructionsString(b *testing.B) {
+	instructions := largeInstructions()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_ = instructions.String()
+	}
+}
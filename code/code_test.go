@@ -19,6 +19,16 @@ func TestMake(t *testing.T) {
 			[]int{},
 			[]byte{byte(OpAdd)},
 		},
+		{
+			OpDup,
+			[]int{},
+			[]byte{byte(OpDup)},
+		},
+		{
+			OpSwap,
+			[]int{},
+			[]byte{byte(OpSwap)},
+		},
 		{
 			OpGetLocal,
 			[]int{255},
@@ -139,6 +149,28 @@ func TestInstructionsString4(t *testing.T) {
 	}
 }
 
+func TestInstructionsStringWithMarker(t *testing.T) {
+	instructions := []Instructions{
+		Make(OpConstant, 1),
+		Make(OpConstant, 2),
+		Make(OpAdd),
+	}
+
+	expected :=
+		`  0000 OpConstant 1
+> 0003 OpConstant 2
+  0006 OpAdd
+`
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+	if concatted.StringWithMarker(3) != expected {
+		t.Errorf("instructions wrongly formatted, expected %q, actual %q",
+			expected, concatted.StringWithMarker(3))
+	}
+}
+
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
 		op        Opcode
@@ -167,3 +199,30 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestLookup(t *testing.T) {
+	// 每个已定义的操作码都能通过 Lookup 找到相同的定义
+	for op, expected := range definitions {
+		def, err := Lookup(byte(op))
+		if err != nil {
+			t.Fatalf("definition of %s not found: %s", expected.Name, err)
+		}
+		if def != expected {
+			t.Errorf("wrong definition for opcode %d, expected %s, actual %s",
+				op, expected.Name, def.Name)
+		}
+	}
+
+	_, err := Lookup(255)
+	if err == nil || err.Error() != "opcode 255 undefined" {
+		t.Errorf("expected error for undefined opcode, actual %v", err)
+	}
+
+	// 无法识别的操作码不影响后续指令的反汇编
+	instructions := append(Instructions{255}, Make(OpAdd)...)
+	expected := "ERROR: opcode 255 undefined\n0001 OpAdd\n"
+	if instructions.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nexpected=%q\nactual=%q",
+			expected, instructions.String())
+	}
+}
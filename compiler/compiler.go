@@ -1,11 +1,15 @@
 package compiler
 
 import (
+	"bytes"
 	"fmt"
-	"sort"
+	"math"
 	"toyvm/ast"
 	"toyvm/code"
+	"toyvm/lexer"
 	"toyvm/object"
+	"toyvm/parser"
+	"toyvm/token"
 )
 
 // 用于跟踪最后两个指令（名称及位置）
@@ -18,6 +22,7 @@ type CompilationScope struct {
 	instructions        code.Instructions  // 字节码的指令部分，[]byte
 	lastInstruction     EmittedInstruction // 最近一次指令
 	previousInstruction EmittedInstruction // 倒数第二次指令
+	lines               code.LineTable     // 指令位置跟源码行号的对应表
 }
 
 type Compiler struct {
@@ -30,6 +35,54 @@ type Compiler struct {
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	// 当前正在编译的语句所在的源码行号，生成的指令都记录为属于这一行
+	line int
+
+	// 是否保留程序最后一个表达式语句的值（即省略最后的 OpPop）
+	keepLastValue bool
+
+	// 是否把内层范围的 let 语句覆盖外层同名绑定视为编译错误
+	strictShadowing bool
+
+	// 即将编译的表达式语句的值是否不会被使用，由 compileStatements 设置
+	valueUnused bool
+
+	// 链式比较里被前后两个比较表达式共用的操作数，
+	// 第一次求值后保存到临时变量，第二次直接读取临时变量
+	sharedOperands map[ast.Expression]*sharedOperand
+}
+
+type sharedOperand struct {
+	symbol    Symbol // 保存操作数的值的临时变量
+	evaluated bool   // 是否已经求值
+}
+
+// 编译错误，包含出错的语法树节点的 token 及其位置，以便工具（比如编辑器）指出出错的位置
+type CompileError struct {
+	Message string
+	Line    int
+	Column  int
+	Token   token.Token
+}
+
+// 只返回错误信息（不包括位置），以保持以前的输出格式
+func (e *CompileError) Error() string {
+	return e.Message
+}
+
+// 返回包括位置的错误信息，格式跟 parser.ParseError 相同
+func (e *CompileError) String() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+func newCompileError(tk token.Token, format string, a ...interface{}) *CompileError {
+	return &CompileError{
+		Message: fmt.Sprintf(format, a...),
+		Line:    tk.Line,
+		Column:  tk.Column,
+		Token:   tk,
+	}
 }
 
 func New() *Compiler {
@@ -69,6 +122,21 @@ func NewWithState(
 	return compiler
 }
 
+// 设置是否把程序最后一个表达式语句的值保留在运算栈里
+// 默认每个表达式语句都以 OpPop 结束，程序运行结束后只能通过 VM 的 LastPoppedStackElem 获取结果；
+// 开启之后省略最后一个顶层语句的 OpPop，结果会保留在栈顶，可以通过 VM 的 Result 获取。
+// 主要用于把 VM 嵌入到其他程序里。
+func (c *Compiler) SetKeepLastValue(enabled bool) {
+	c.keepLastValue = enabled
+}
+
+// 设置是否禁止内层范围（函数或者语句块）的 let 语句覆盖外层的同名绑定，
+// 开启之后覆盖外层绑定的 let 语句会产生编译错误，用于发现不小心重名的变量。
+// 默认允许覆盖，即在内层定义一个新的变量。
+func (c *Compiler) SetStrictShadowing(enabled bool) {
+	c.strictShadowing = enabled
+}
+
 func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
@@ -77,36 +145,141 @@ func (c *Compiler) currentInstructions() code.Instructions {
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	Lines        code.LineTable // 指令位置跟源码行号的对应表
+}
+
+// 反汇编主程序的指令
+func (b *Bytecode) String() string {
+	return b.Instructions.String()
+}
+
+// 反汇编主程序以及各个用户自定义函数的指令，并在指令前面插入对应的源码行
+func (b *Bytecode) StringWithSource(source string) string {
+	var out bytes.Buffer
+	out.WriteString(b.Instructions.StringWithSource(b.Lines, source))
+
+	for i, constant := range b.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&out, "\nCompiledFunction (constant %d):\n", i)
+		out.WriteString(fn.Instructions.StringWithSource(fn.Lines, source))
+	}
+	return out.String()
+}
+
+// 比较两个字节码的指令和常量是否相同，常量按值比较，函数则递归比较其指令，
+// 用于缓存编译结果以及测试
+// 注：
+// 不比较行号表，所以只是排版（比如空行）不同的源码，其字节码也是相同的
+func (b *Bytecode) Equal(other *Bytecode) bool {
+	if b == nil || other == nil {
+		return b == other
+	}
+
+	if !bytes.Equal(b.Instructions, other.Instructions) ||
+		len(b.Constants) != len(other.Constants) {
+		return false
+	}
+
+	for i, constant := range b.Constants {
+		if !equalConstant(constant, other.Constants[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// 判断两个常量是否相同，函数（CompiledFunction）比较其指令以及参数、局部变量的数量
+func equalConstant(a, b object.Object) bool {
+	fn, ok := a.(*object.CompiledFunction)
+	if !ok {
+		return sameConstant(a, b)
+	}
+
+	other, ok := b.(*object.CompiledFunction)
+	return ok &&
+		fn.Name == other.Name &&
+		fn.NumLocals == other.NumLocals &&
+		fn.NumParameters == other.NumParameters &&
+		fn.NumDefaults == other.NumDefaults &&
+		bytes.Equal(fn.Instructions, other.Instructions)
+}
+
+// 解析并编译源码
+// 解析出错时返回解析器的错误列表（此时不再编译），编译出错时返回编译错误，
+// 两者都没有错误时返回字节码。
+func CompileSource(src string) (*Bytecode, []string, error) {
+	l := lexer.New(src)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		return nil, p.Errors().Strings(), nil
+	}
+
+	comp := New()
+	err := comp.Compile(program)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return comp.Bytecode(), nil, nil
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(), // c.instructions,
 		Constants:    c.constants,
+		Lines:        c.scopes[c.scopeIndex].lines,
 	}
 }
 
+// 编译单个表达式（而不是完整的程序），表达式的值不会被 OpPop 弹出，
+// 执行之后保留在运算栈顶，可以通过 VM 的 Result 获取。
+// 主要用于把 VM 嵌入到其他程序里求值表达式，比如计算器、规则引擎等。
+func (c *Compiler) CompileExpression(expression ast.Expression) error {
+	return c.Compile(expression)
+}
+
 // 编译程序
 // 结果是字节码，字节码包括指令部分和数据部分
 func (c *Compiler) Compile(n ast.Node) error {
+	if line := statementLine(n); line > 0 {
+		// 编译完语句之后恢复外层语句的行号，比如函数主体里的语句编译完之后，
+		// 函数字面量后面的指令仍然属于外层的语句
+		outerLine := c.line
+		c.line = line
+		defer func() { c.line = outerLine }()
+	}
+
 	switch node := n.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
+		err := c.compileStatements(node.Statements)
+		if err != nil {
+			return err
+		}
+
+		if c.keepLastValue && c.lastInstructionIsPop() {
+			c.removeLastPop()
 		}
 
 	case *ast.BlockStatement:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
+		err := c.compileStatements(node.Statements)
+		if err != nil {
+			return err
 		}
 
 	case *ast.ExpressionStatement:
+		valueUnused := c.valueUnused
+		c.valueUnused = false
+
+		// 值不会被使用的 if 表达式不需要生成值（也就不需要在末尾弹出）
+		if ifExpression, ok := node.Expression.(*ast.IfExpression); ok && valueUnused {
+			return c.compileIfStatement(ifExpression)
+		}
+
 		err := c.Compile(node.Expression)
 		if err != nil {
 			return err
@@ -114,6 +287,12 @@ func (c *Compiler) Compile(n ast.Node) error {
 		c.emit(code.OpPop) // 弹出语句的最后一个结果（用于清除栈）
 
 	case *ast.IfExpression:
+		// 条件是布尔字面量时，在编译时就能确定执行哪一个分支，
+		// 所以只生成该分支的指令，不需要生成条件和跳转指令
+		if condition, ok := node.Condition.(*ast.Boolean); ok {
+			return c.compileConstantIf(condition.Value, node)
+		}
+
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
@@ -122,17 +301,13 @@ func (c *Compiler) Compile(n ast.Node) error {
 		// 使用一个临时的数值 `0` 作为 OpJumpNotTruthy 指令的参数
 		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 0)
 
-		err = c.Compile(node.Consequence)
+		// Consequence 可能是一个语句块，假如最后的栈顶的值被（语句末尾的 OpPop 指令）移除，
+		// 则移除 OpPop 指令；语句块没有值（比如最后一条语句是 let 语句）时补上 OpNull
+		err = c.compileBlockValue(node.Consequence)
 		if err != nil {
 			return err
 		}
 
-		// Consequence 可能是一个语句块，假如最后的栈顶的值被（语句末尾的 OpPop 指令）移除，
-		// 则移除 OpPop 指令
-		if c.lastInstructionIsPop() {
-			c.removeLastPop()
-		}
-
 		// 为 consequence 段补上一个 OpJump 指令
 		// 使用一个临时的数值 `0` 作为 OpJump 指令的参数
 		jumpPos := c.emit(code.OpJump, 0)
@@ -150,14 +325,10 @@ func (c *Compiler) Compile(n ast.Node) error {
 
 		} else { // 存在 alternative
 			// 生成 alternative 指令
-			err = c.Compile(node.Alternative)
+			err = c.compileBlockValue(node.Alternative)
 			if err != nil {
 				return err
 			}
-
-			if c.lastInstructionIsPop() {
-				c.removeLastPop()
-			}
 		}
 
 		// afterAlternativePos := len(c.instructions)
@@ -167,6 +338,53 @@ func (c *Compiler) Compile(n ast.Node) error {
 		c.changeOperand(jumpNotTruthyPos, alternativePos)
 		c.changeOperand(jumpPos, afterAlternativePos)
 
+	case *ast.TryExpression:
+		return c.compileTryExpression(node)
+
+	case *ast.MatchExpression:
+		return c.compileMatchExpression(node)
+
+	// 条件表达式，跟 if 表达式类似，只是两个分支都是表达式
+	case *ast.ConditionalExpression:
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 0)
+
+		err = c.Compile(node.Consequence)
+		if err != nil {
+			return err
+		}
+
+		jumpPos := c.emit(code.OpJump, 0)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+		err = c.Compile(node.Alternative)
+		if err != nil {
+			return err
+		}
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	// 语句块表达式
+	case *ast.BlockExpression:
+		c.enterBlockScope()
+		err := c.compileStatements(node.Statements)
+		c.leaveBlockScope()
+		if err != nil {
+			return err
+		}
+
+		// 保留最后一个表达式语句的值作为语句块表达式的值，
+		// 如果最后一个语句不是表达式语句（比如 let 语句），则其值为 Null
+		if c.lastInstructionIsPop() {
+			c.removeLastPop()
+		} else {
+			c.emit(code.OpNull)
+		}
+
 	// 用户自定义函数
 	case *ast.FunctionLiteral:
 		c.enterScope()
@@ -200,6 +418,7 @@ func (c *Compiler) Compile(n ast.Node) error {
 
 		freeSymbols := c.symbolTable.FreeSymbols  // 函数主体内所捕获的外部局部变量列表
 		numLocals := c.symbolTable.numDefinitions // 计算函数主体内的局部变量的数量
+		lines := c.scopes[c.scopeIndex].lines
 		instructions := c.leaveScope()
 
 		// 把被捕获的局部变量压入运算栈里，以被 OpClosure 所使用
@@ -207,10 +426,22 @@ func (c *Compiler) Compile(n ast.Node) error {
 			c.loadSymbol(sym)
 		}
 
+		// 把参数的默认值压入运算栈里，OpClosure 会把它们保存到闭包里，
+		// 即默认值是在定义函数（创建闭包）时求值的，而不是在每次调用时求值
+		for _, value := range node.Defaults {
+			err := c.Compile(value)
+			if err != nil {
+				return err
+			}
+		}
+
 		compiledFn := &object.CompiledFunction{
+			Name:          node.Name,
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			NumDefaults:   len(node.Defaults),
+			Lines:         lines,
 		}
 
 		// 注：
@@ -231,6 +462,10 @@ func (c *Compiler) Compile(n ast.Node) error {
 		c.emit(code.OpReturnValue)
 
 	case *ast.CallExpression:
+		if c.isBuiltinCall(node, "exit") {
+			return c.compileExit(node)
+		}
+
 		err := c.Compile(node.Function)
 		if err != nil {
 			return err
@@ -251,7 +486,23 @@ func (c *Compiler) Compile(n ast.Node) error {
 
 	// 标识符定义和赋值语句
 	case *ast.LetStatement:
-		symbol := c.symbolTable.Define(node.Name.Value)
+		// 在同一个范围里重新定义常量相当于对常量重新赋值
+		// （被捕获的外层常量是 FreeScope，在函数里可以定义同名的局部变量）
+		existing, ok := c.symbolTable.lookupLocal(node.Name.Value)
+		if ok && existing.Immutable && existing.Scope != FreeScope {
+			return newCompileError(node.Name.Token, "cannot redeclare constant %s", node.Name.Value)
+		}
+
+		if c.strictShadowing && c.symbolTable.shadows(node.Name.Value) {
+			return newCompileError(node.Name.Token, "%s shadows a binding in an outer scope", node.Name.Value)
+		}
+
+		var symbol Symbol
+		if node.IsConst() {
+			symbol = c.symbolTable.DefineConst(node.Name.Value)
+		} else {
+			symbol = c.symbolTable.Define(node.Name.Value)
+		}
 
 		err := c.Compile(node.Value)
 		if err != nil {
@@ -264,27 +515,48 @@ func (c *Compiler) Compile(n ast.Node) error {
 			c.emit(code.OpSetLocal, symbol.Index) // ++
 		}
 
+	// 可相互递归的绑定语句
+	// 先定义所有的名称，然后再逐个编译各个值，这样各个函数的主体就可以引用
+	// 在它之后才定义的名称。
+	case *ast.LetRecStatement:
+		// 注：
+		// 局部的函数是在创建闭包时按值捕获外部局部变量的，此时后面的函数
+		// 还没有被赋值，所以目前只支持在全局范围使用 letrec。
+		if c.symbolTable.owner().Outer != nil {
+			return newCompileError(node.Token, "letrec is only supported at the top level")
+		}
+
+		symbols := []Symbol{}
+		for _, name := range node.Names {
+			symbols = append(symbols, c.symbolTable.Define(name.Value))
+		}
+
+		for i, value := range node.Values {
+			err := c.Compile(value)
+			if err != nil {
+				return err
+			}
+
+			c.emit(code.OpSetGlobal, symbols[i].Index)
+		}
+
 	// 二元操作
 	case *ast.InfixExpression:
-		left, right, operator := node.Left, node.Right, node.Operator
-
-		if operator == "<" {
-			left = node.Right
-			right = node.Left
-			operator = ">"
+		if node.Operator == "&&" {
+			return c.compileLogicalAnd(node)
 		}
 
-		err := c.Compile(left)
+		err := c.compileOperand(node.Left)
 		if err != nil {
 			return err
 		}
 
-		err = c.Compile(right)
+		err = c.compileOperand(node.Right)
 		if err != nil {
 			return err
 		}
 
-		switch operator {
+		switch node.Operator {
 		case "+":
 			c.emit(code.OpAdd)
 		case "-":
@@ -293,6 +565,8 @@ func (c *Compiler) Compile(n ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
 
 		case "==":
 			c.emit(code.OpEqual)
@@ -300,13 +574,24 @@ func (c *Compiler) Compile(n ast.Node) error {
 			c.emit(code.OpNotEqual)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case "<":
+			c.emit(code.OpLessThan)
 
 		default:
-			return fmt.Errorf("unknown operator %s", operator)
+			return newCompileError(node.Token, "unknown operator %s", node.Operator)
 		}
 
 	// 一元操作
 	case *ast.PrefixExpression:
+		// 负号紧接着整数字面量（比如 `-5`）时，直接生成负数常量，
+		// 而不是生成 `OpConstant 5` 和 `OpMinus` 两条指令
+		// 注：
+		// 整数字面量的值总是非负数（并且不超过 math.MaxInt64），所以取负数不会溢出
+		if integer, ok := node.Right.(*ast.IntegerLiteral); ok && node.Operator == "-" {
+			c.emit(code.OpConstant, c.addConstant(object.NewInteger(-integer.Value)))
+			return nil
+		}
+
 		value := node.Right
 		err := c.Compile(value)
 		if err != nil {
@@ -319,8 +604,16 @@ func (c *Compiler) Compile(n ast.Node) error {
 			c.emit(code.OpMinus)
 		case "!":
 			c.emit(code.OpBang)
+		case "+":
+			c.emit(code.OpPlus)
 		default:
-			return fmt.Errorf("unknown operator %s", operator)
+			return newCompileError(node.Token, "unknown operator %s", operator)
+		}
+
+	case *ast.AssignExpression:
+		err := c.compileAssignExpression(node)
+		if err != nil {
+			return err
 		}
 
 	// 复合数据类型
@@ -335,17 +628,8 @@ func (c *Compiler) Compile(n ast.Node) error {
 		c.emit(code.OpArray, len(node.Elements))
 
 	case *ast.HashLiteral:
-		keys := []ast.Expression{} // 先获取 Hash（Map）Literal 的 keys
-		for key := range node.Pairs {
-			keys = append(keys, key)
-		}
-
-		// 对 keys 排序（可选的，主要为了方便测试，否则 key 的顺序是随机的）
-		sort.Slice(keys, func(i int, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, key := range keys {
+		// 按照 key 在源码里出现的顺序生成指令，VM 创建的 Hash 会保持这个（插入）顺序
+		for _, key := range node.Keys {
 			err := c.Compile(key)
 			if err != nil {
 				return err
@@ -357,7 +641,7 @@ func (c *Compiler) Compile(n ast.Node) error {
 			}
 		}
 
-		c.emit(code.OpHash, len(node.Pairs)*2)
+		c.emit(code.OpHash, len(node.Keys)*2)
 
 	// 求 Array 和 Hash 的索引
 	case *ast.IndexExpression:
@@ -379,7 +663,7 @@ func (c *Compiler) Compile(n ast.Node) error {
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return newCompileError(node.Token, "undefined variable %s", node.Value)
 		}
 
 		// if symbol.Scope == GlobalScope { // ++
@@ -392,7 +676,7 @@ func (c *Compiler) Compile(n ast.Node) error {
 
 	// 字面量
 	case *ast.IntegerLiteral:
-		integer := &object.Integer{Value: node.Value}
+		integer := object.NewInteger(node.Value)
 		c.emit(code.OpConstant, c.addConstant(integer))
 
 	case *ast.Boolean:
@@ -411,12 +695,42 @@ func (c *Compiler) Compile(n ast.Node) error {
 }
 
 // 将常量/字面量添加到常量列表，返回该常量的位置值
+// 如果常量列表里已经存在相同的常量（比如整数值相同的 Integer），则直接返回已存在常量的位置值，
+// 函数（CompiledFunction）则总是添加新的常量。
 func (c *Compiler) addConstant(obj object.Object) int {
+	for i, constant := range c.constants {
+		if sameConstant(constant, obj) {
+			return i
+		}
+	}
+
 	idx := len(c.constants)
 	c.constants = append(c.constants, obj)
 	return idx
 }
 
+// 判断两个常量是否为类型和值都相同的字面量
+// 注：
+// 不使用 HashKey 比较，因为字符串的 HashKey 是哈希值，不同的字符串也可能相同
+func sameConstant(a, b object.Object) bool {
+	switch a := a.(type) {
+	case *object.Integer:
+		other, ok := b.(*object.Integer)
+		return ok && a.Value == other.Value
+	case *object.String:
+		other, ok := b.(*object.String)
+		return ok && a.Value == other.Value
+	case *object.Boolean:
+		other, ok := b.(*object.Boolean)
+		return ok && a.Value == other.Value
+	case *object.Float:
+		other, ok := b.(*object.Float)
+		return ok && math.Float64bits(a.Value) == math.Float64bits(other.Value)
+	default:
+		return false
+	}
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
@@ -432,6 +746,270 @@ func (c *Compiler) loadSymbol(s Symbol) {
 	}
 }
 
+// 函数调用表达式是否调用了指定的内置函数（而且内置函数的名称没有被同名变量覆盖）
+func (c *Compiler) isBuiltinCall(node *ast.CallExpression, name string) bool {
+	identifier, ok := node.Function.(*ast.Identifier)
+	if !ok || identifier.Value != name {
+		return false
+	}
+
+	symbol, ok := c.symbolTable.Resolve(name)
+	return ok && symbol.Scope == BuiltinScope
+}
+
+// 编译 `exit()` 或者 `exit(value)`，直接生成 OpHalt 指令而不是调用内置函数，
+// value 作为程序的结果，省略时为 null
+func (c *Compiler) compileExit(node *ast.CallExpression) error {
+	switch len(node.Arguments) {
+	case 0:
+		c.emit(code.OpNull)
+	case 1:
+		err := c.Compile(node.Arguments[0])
+		if err != nil {
+			return err
+		}
+	default:
+		return newCompileError(node.Function.(*ast.Identifier).Token,
+			"wrong number of arguments to exit, expected 0 or 1, actual %d", len(node.Arguments))
+	}
+
+	c.emit(code.OpHalt)
+	return nil
+}
+
+// 编译逻辑与表达式 `a && b`
+// 左边的值为假时不再对右边求值，表达式的值为 false；否则表达式的值为右边的值。
+//
+// 对于由链式比较转换而来的 `a < b && b < c`，如果中间的操作数 b 不是
+// 标识符或者字面量（求值可能有副作用，比如函数调用），则把它的值保存到临时变量，
+// 以保证只被求值一次。
+func (c *Compiler) compileLogicalAnd(node *ast.InfixExpression) error {
+	if operand, ok := chainedOperand(node); ok && !isSimpleOperand(operand) {
+		if c.sharedOperands == nil {
+			c.sharedOperands = map[ast.Expression]*sharedOperand{}
+		}
+		c.sharedOperands[operand] = &sharedOperand{symbol: c.symbolTable.defineTemporary()}
+		defer delete(c.sharedOperands, operand)
+	}
+
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	// 使用一个临时的数值 `0` 作为 OpJumpNotTruthy 指令的参数
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 0)
+
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 0)
+
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	c.emit(code.OpFalse)
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// 如果 `&&` 表达式是由链式比较转换而来，返回前后两个比较表达式共用的操作数
+func chainedOperand(node *ast.InfixExpression) (ast.Expression, bool) {
+	right, ok := node.Right.(*ast.InfixExpression)
+	if !ok || (right.Operator != "<" && right.Operator != ">") {
+		return nil, false
+	}
+
+	previous, ok := node.Left.(*ast.InfixExpression)
+	if ok && previous.Operator == "&&" {
+		previous, ok = previous.Right.(*ast.InfixExpression)
+	}
+	if !ok || (previous.Operator != "<" && previous.Operator != ">") {
+		return nil, false
+	}
+
+	if previous.Right != right.Left {
+		return nil, false
+	}
+	return right.Left, true
+}
+
+// 求值没有副作用且开销很小的操作数，重复求值也无妨
+func isSimpleOperand(node ast.Expression) bool {
+	switch node.(type) {
+	case *ast.Identifier, *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+		return true
+	default:
+		return false
+	}
+}
+
+// 编译二元操作的操作数，链式比较里共用的操作数只求值一次
+func (c *Compiler) compileOperand(node ast.Expression) error {
+	shared, ok := c.sharedOperands[node]
+	if !ok {
+		return c.Compile(node)
+	}
+
+	if shared.evaluated {
+		c.loadSymbol(shared.symbol)
+		return nil
+	}
+
+	err := c.Compile(node)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpDup)
+	c.storeSymbol(shared.symbol)
+	shared.evaluated = true
+	return nil
+}
+
+// 把栈顶的值保存到全局变量或者局部变量
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// 编译赋值表达式
+// 对于复合赋值（比如 `x += 1`），先读取变量原来的值，再跟右边的值进行运算；
+// 保存之前使用 OpDup 复制一份结果，作为赋值表达式本身的值。
+// 注：
+// 闭包按值捕获外部局部变量，所以不支持对被捕获的变量（FreeScope）赋值。
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	if indexExpression, ok := node.Target.(*ast.IndexExpression); ok {
+		return c.compileIndexAssignExpression(indexExpression, node)
+	}
+
+	identifier := node.Target.(*ast.Identifier)
+	symbol, ok := c.symbolTable.Resolve(identifier.Value)
+	if !ok {
+		return newCompileError(identifier.Token, "undefined variable %s", identifier.Value)
+	}
+	if symbol.Immutable {
+		return newCompileError(identifier.Token, "cannot assign to constant %s", identifier.Value)
+	}
+	if symbol.Scope != GlobalScope && symbol.Scope != LocalScope {
+		return newCompileError(identifier.Token, "cannot assign to %s variable %s", symbol.Scope, identifier.Value)
+	}
+
+	// `x = x + 1`、`x += 1` 以及对应的 -1 使用专门的指令
+	if delta, ok := incrementDelta(node, identifier.Value); ok {
+		c.emitIncrement(symbol, delta)
+		return nil
+	}
+
+	if node.Operator != "=" {
+		c.loadSymbol(symbol)
+	}
+
+	err := c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+
+	switch node.Operator {
+	case "=":
+	case "+=":
+		c.emit(code.OpAdd)
+	case "-=":
+		c.emit(code.OpSub)
+	case "*=":
+		c.emit(code.OpMul)
+	case "/=":
+		c.emit(code.OpDiv)
+	default:
+		return newCompileError(node.Token, "unknown operator %s", node.Operator)
+	}
+
+	c.emit(code.OpDup)
+	c.storeSymbol(symbol)
+	return nil
+}
+
+// 判断赋值表达式是否把变量的值 +1 或者 -1，即 `x = x + 1`、`x = x - 1`、`x += 1` 或者 `x -= 1`，
+// 返回变化的值（1 或者 -1）
+func incrementDelta(node *ast.AssignExpression, name string) (int, bool) {
+	isOne := func(e ast.Expression) bool {
+		integer, ok := e.(*ast.IntegerLiteral)
+		return ok && integer.Value == 1
+	}
+
+	switch node.Operator {
+	case "+=":
+		return 1, isOne(node.Value)
+	case "-=":
+		return -1, isOne(node.Value)
+	case "=":
+		infix, ok := node.Value.(*ast.InfixExpression)
+		if !ok || !isOne(infix.Right) {
+			return 0, false
+		}
+		left, ok := infix.Left.(*ast.Identifier)
+		if !ok || left.Value != name {
+			return 0, false
+		}
+
+		switch infix.Operator {
+		case "+":
+			return 1, true
+		case "-":
+			return -1, true
+		}
+	}
+	return 0, false
+}
+
+// 生成把变量的值 +1 或者 -1 的指令，变量只能是全局变量或者局部变量
+func (c *Compiler) emitIncrement(s Symbol, delta int) {
+	switch {
+	case s.Scope == GlobalScope && delta > 0:
+		c.emit(code.OpIncrementGlobal, s.Index)
+	case s.Scope == GlobalScope:
+		c.emit(code.OpDecrementGlobal, s.Index)
+	case delta > 0:
+		c.emit(code.OpIncrementLocal, s.Index)
+	default:
+		c.emit(code.OpDecrementLocal, s.Index)
+	}
+}
+
+// 编译对索引表达式的赋值，比如 `arr[0] = 1`
+// 依次压入被索引的对象、索引值以及新的值，然后由 OpSetIndex 修改对象并留下新的值。
+// 注：
+// 复合赋值需要读取原来的元素，这样被索引的对象和索引值的表达式就得求值两次，
+// 如果表达式有副作用（比如函数调用）则结果不正确，所以暂不支持。
+func (c *Compiler) compileIndexAssignExpression(target *ast.IndexExpression, node *ast.AssignExpression) error {
+	if node.Operator != "=" {
+		return newCompileError(node.Token,
+			"compound assignment to index expression not supported: %s", node.Operator)
+	}
+
+	err := c.Compile(target.Left)
+	if err != nil {
+		return err
+	}
+
+	err = c.Compile(target.Index)
+	if err != nil {
+		return err
+	}
+
+	err = c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpSetIndex)
+	return nil
+}
+
 // 生成指令字节，返回该指令在字节中的位置值
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
@@ -449,6 +1027,13 @@ func (c *Compiler) addInstruction(ins []byte) int {
 	updatedInstructions := append(c.currentInstructions(), ins...)
 	c.scopes[c.scopeIndex].instructions = updatedInstructions
 
+	// 只在行号发生变化时才添加新的一项
+	lines := c.scopes[c.scopeIndex].lines
+	if c.line > 0 && (len(lines) == 0 || lines[len(lines)-1].Line != c.line) {
+		c.scopes[c.scopeIndex].lines = append(lines,
+			code.LineEntry{Offset: posNewInstruction, Line: c.line})
+	}
+
 	return posNewInstruction
 }
 
@@ -488,6 +1073,13 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = c.currentInstructions()[:last.Position]
 	c.scopes[c.scopeIndex].lastInstruction = previous
+
+	// 移除指向被删除的指令的行号记录
+	lines := c.scopes[c.scopeIndex].lines
+	for len(lines) > 0 && lines[len(lines)-1].Offset >= last.Position {
+		lines = lines[:len(lines)-1]
+	}
+	c.scopes[c.scopeIndex].lines = lines
 }
 
 // 替换长度相同的指令（[]byte）
@@ -541,3 +1133,227 @@ func (c *Compiler) leaveScope() code.Instructions {
 
 	return instructions
 }
+
+// 编译语句列表
+// 除了最后一个语句，其余语句的值都会被丢弃（最后一个语句的值可能会作为
+// 语句块、函数或者程序的值，所以视为会被使用）
+func (c *Compiler) compileStatements(statements []ast.Statement) error {
+	for i, s := range statements {
+		c.valueUnused = i < len(statements)-1
+		err := c.Compile(s)
+		c.valueUnused = false
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 编译 if 表达式的分支，分支里定义的变量只在分支里可见
+func (c *Compiler) compileBlock(block *ast.BlockStatement) error {
+	c.enterBlockScope()
+	defer c.leaveBlockScope()
+	return c.Compile(block)
+}
+
+// 进入语句块的符号范围
+// 跟 enterScope 不同，语句块不是一个新的函数，所以不需要新的指令列表
+func (c *Compiler) enterBlockScope() {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveBlockScope() {
+	c.symbolTable = c.symbolTable.Outer
+}
+
+// 编译值不会被使用的 if 表达式（即作为语句的 if）
+// 跟 if 表达式不同，各个分支的值在分支内就被弹出，缺少 alternative 时也不需要补上 OpNull，
+// 所以整个语句结束之后运算栈不会留下任何值，语句末尾也就不需要 OpPop
+func (c *Compiler) compileIfStatement(node *ast.IfExpression) error {
+	if condition, ok := node.Condition.(*ast.Boolean); ok {
+		if condition.Value {
+			return c.compileBlock(node.Consequence)
+		}
+		if node.Alternative != nil {
+			return c.compileBlock(node.Alternative)
+		}
+		return nil
+	}
+
+	err := c.Compile(node.Condition)
+	if err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 0)
+
+	err = c.compileBlock(node.Consequence)
+	if err != nil {
+		return err
+	}
+
+	if node.Alternative == nil {
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	jumpPos := c.emit(code.OpJump, 0)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	err = c.compileBlock(node.Alternative)
+	if err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// 编译条件为布尔字面量的 if 表达式，只生成被执行的分支的指令，
+// 不存在被执行的分支（条件为 false 且没有 else）时生成 OpNull
+func (c *Compiler) compileConstantIf(condition bool, node *ast.IfExpression) error {
+	branch := node.Consequence
+	if !condition {
+		branch = node.Alternative
+	}
+
+	if branch == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+	return c.compileBlockValue(branch)
+}
+
+// 编译 try 表达式，生成的指令如下：
+//
+//	OpSetHandler <catch>
+//	<body>
+//	OpPopHandler
+//	OpJump <end>
+//	catch: OpSetGlobal/OpSetLocal <e>  // VM 把错误对象压入栈之后跳转到这里
+//	<handler>
+//	end:
+func (c *Compiler) compileTryExpression(node *ast.TryExpression) error {
+	setHandlerPos := c.emit(code.OpSetHandler, 0)
+
+	err := c.compileBlockValue(node.Body)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpPopHandler)
+	jumpPos := c.emit(code.OpJump, 0)
+
+	catchPos := len(c.currentInstructions())
+	c.changeOperand(setHandlerPos, catchPos)
+
+	// 错误对象的标识符只在 catch 语句块里有效
+	c.enterBlockScope()
+	symbol := c.symbolTable.Define(node.Parameter.Value)
+	c.storeSymbol(symbol)
+	err = c.compileBlockValue(node.Handler)
+	c.leaveBlockScope()
+	if err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// 编译 match 表达式，转换为一串相等比较和跳转，生成的指令如下：
+//
+//	<subject>
+//	OpDup                       // 每个分支：复制被匹配的值
+//	<pattern>
+//	OpEqual
+//	OpJumpNotTruthy <next arm>
+//	OpPop                       // 匹配成功，弹出被匹配的值
+//	<body>
+//	OpJump <end>
+//	...
+//	OpPop                       // 默认分支
+//	<default 或者 OpNull>
+//	end:
+func (c *Compiler) compileMatchExpression(node *ast.MatchExpression) error {
+	err := c.Compile(node.Subject)
+	if err != nil {
+		return err
+	}
+
+	jumpPositions := []int{}
+	for _, arm := range node.Arms {
+		c.emit(code.OpDup)
+
+		err := c.Compile(arm.Pattern)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpEqual)
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 0)
+
+		c.emit(code.OpPop)
+		err = c.Compile(arm.Body)
+		if err != nil {
+			return err
+		}
+		jumpPositions = append(jumpPositions, c.emit(code.OpJump, 0))
+
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	}
+
+	c.emit(code.OpPop)
+	if node.Default != nil {
+		err := c.Compile(node.Default)
+		if err != nil {
+			return err
+		}
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	endPos := len(c.currentInstructions())
+	for _, pos := range jumpPositions {
+		c.changeOperand(pos, endPos)
+	}
+	return nil
+}
+
+// 编译语句块，并把语句块的值（即最后一条表达式语句的值）留在运算栈，
+// 语句块没有值时（比如空的语句块，或者最后一条语句是 let 语句）补上 OpNull，
+// 以免之后的 OpPop（或者其他使用这个值的指令）从运算栈弹出不属于这个语句块的值
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+	err := c.compileBlock(block)
+	if err != nil {
+		return err
+	}
+
+	// 语句块没有生成任何指令时，不能检查最后一个指令，因为它属于前面的语句
+	emitted := len(c.currentInstructions()) > start
+	switch {
+	case emitted && c.lastInstructionIsPop():
+		c.removeLastPop()
+	case emitted && c.lastInstructionIs(code.OpReturnValue):
+		// 最后一条语句是 return 语句，不会执行到语句块的末尾
+	default:
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// 返回语句所在的源码行号，如果节点不是语句（或者没有位置信息）则返回 0
+func statementLine(n ast.Node) int {
+	switch node := n.(type) {
+	case *ast.LetStatement:
+		return node.Token.Line
+	case *ast.LetRecStatement:
+		return node.Token.Line
+	case *ast.ReturnStatement:
+		return node.Token.Line
+	case *ast.ExpressionStatement:
+		return node.Token.Line
+	}
+	return 0
+}
@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 	"toyvm/ast"
 	"toyvm/code"
@@ -199,14 +200,56 @@ func TestIntegerArithmetic(t *testing.T) {
 			},
 		},
 		{
-			input:             "-1",
-			expectedConstants: []interface{}{1},
+			input:             "7 % 3",
+			expectedConstants: []interface{}{7, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 负的整数字面量直接生成负数常量
+			input:             "-5",
+			expectedConstants: []interface{}{-5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "[-1, -2, -1]",
+			expectedConstants: []interface{}{-1, -2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 3),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 其他表达式仍然使用 OpMinus
+			input:             "-(1 + 2)",
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
 				code.Make(code.OpMinus),
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "+1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPlus),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -241,12 +284,13 @@ func TestBooleanExpressions(t *testing.T) {
 			},
 		},
 		{
+			// 操作数按照源码的顺序求值，不再交换
 			input:             "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
 				code.Make(code.OpPop),
 			},
 		},
@@ -307,11 +351,13 @@ func TestConditionals(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input: `
-			if (true) { 10 }; 3333;
+			if (1 > 2) { 10 }
 			`,
-			expectedConstants: []interface{}{10, 3333},
+			expectedConstants: []interface{}{1, 2, 10},
 			expectedInstructions: []code.Instructions{
-				/* 0000 */ code.Make(code.OpTrue), // 1 bytes
+				/* 0000 */ code.Make(code.OpConstant, 0), // 3 bytes
+				/* 0003 */ code.Make(code.OpConstant, 1), // 3 bytes
+				/* 0006 */ code.Make(code.OpGreaterThan), // 1 bytes
 
 				// 在支持 Null 之前的 if 语句
 				//
@@ -322,13 +368,11 @@ func TestConditionals(t *testing.T) {
 				// /* 0011 */ code.Make(code.OpPop), // 1 bytes
 
 				// 支持 Null 之后的 if 语句
-				/* 0001 */ code.Make(code.OpJumpNotTruthy, 10), // 3 bytes
-				/* 0004 */ code.Make(code.OpConstant, 0), // 3 bytes
-				/* 0007 */ code.Make(code.OpJump, 11), // 3 bytes
-				/* 0010 */ code.Make(code.OpNull), // 1 bytes
-				/* 0011 */ code.Make(code.OpPop), // 1 bytes ;; 清理 if 语句的值
-				/* 0012 */ code.Make(code.OpConstant, 1), // 3 bytes
-				/* 0015 */ code.Make(code.OpPop), // 1 bytes
+				/* 0007 */ code.Make(code.OpJumpNotTruthy, 16), // 3 bytes
+				/* 0010 */ code.Make(code.OpConstant, 2), // 3 bytes
+				/* 0013 */ code.Make(code.OpJump, 17), // 3 bytes
+				/* 0016 */ code.Make(code.OpNull), // 1 bytes
+				/* 0017 */ code.Make(code.OpPop), // 1 bytes ;; 清理 if 语句的值
 			},
 		},
 	}
@@ -340,18 +384,18 @@ func TestConditionals2(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input: `
-			if (true) { 10 } else { 20 }; 3333;
+			if (1 > 2) { 10 } else { 20 }
 			`,
-			expectedConstants: []interface{}{10, 20, 3333},
+			expectedConstants: []interface{}{1, 2, 10, 20},
 			expectedInstructions: []code.Instructions{
-				/* 0000 */ code.Make(code.OpTrue), // 1 bytes
-				/* 0001 */ code.Make(code.OpJumpNotTruthy, 10), // 3 bytes
-				/* 0004 */ code.Make(code.OpConstant, 0), // 3 bytes, "10" 语句
-				/* 0007 */ code.Make(code.OpJump, 13), // 3 bytes
-				/* 0010 */ code.Make(code.OpConstant, 1), // 3 bytes, "20" 语句
-				/* 0013 */ code.Make(code.OpPop), // 1 bytes, "if..." 语句的结束
-				/* 0014 */ code.Make(code.OpConstant, 2), // 3 bytes
-				/* 0017 */ code.Make(code.OpPop), // 1 bytes, "3333;" 语句的结束
+				/* 0000 */ code.Make(code.OpConstant, 0), // 3 bytes
+				/* 0003 */ code.Make(code.OpConstant, 1), // 3 bytes
+				/* 0006 */ code.Make(code.OpGreaterThan), // 1 bytes
+				/* 0007 */ code.Make(code.OpJumpNotTruthy, 16), // 3 bytes
+				/* 0010 */ code.Make(code.OpConstant, 2), // 3 bytes, "10" 语句
+				/* 0013 */ code.Make(code.OpJump, 19), // 3 bytes
+				/* 0016 */ code.Make(code.OpConstant, 3), // 3 bytes, "20" 语句
+				/* 0019 */ code.Make(code.OpPop), // 1 bytes, "if..." 语句的结束
 			},
 		},
 	}
@@ -364,21 +408,286 @@ func TestConditionals3(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input: `
-			if (true) { 10 }; 3333;
+			let x = 1; if (x > 2) { 10 }
 			`,
+			expectedConstants: []interface{}{1, 2, 10},
+			expectedInstructions: []code.Instructions{
+				/* 0000 */ code.Make(code.OpConstant, 0), // 3 bytes
+				/* 0003 */ code.Make(code.OpSetGlobal, 0), // 3 bytes
+				/* 0006 */ code.Make(code.OpGetGlobal, 0), // 3 bytes
+				/* 0009 */ code.Make(code.OpConstant, 1), // 3 bytes
+				/* 0012 */ code.Make(code.OpGreaterThan), // 1 bytes
+				/* 0013 */ code.Make(code.OpJumpNotTruthy, 22), // 3 bytes
+				/* 0016 */ code.Make(code.OpConstant, 2), // 3 bytes, ;; "10"
+				/* 0019 */ code.Make(code.OpJump, 23), // 3 bytes
+				/* 0022 */ code.Make(code.OpNull), // 1 bytes ;; 因为缺少 alternative 语句块而补上的指令
+				/* 0023 */ code.Make(code.OpPop), // 1 bytes
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// 值不会被使用的 if（即不是最后一个语句的 if 语句）不生成 OpNull，
+// 各个分支的值在分支内弹出
+func TestIfStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (1 > 2) { 10 }; 3333;",
+			expectedConstants: []interface{}{1, 2, 10, 3333},
+			expectedInstructions: []code.Instructions{
+				/* 0000 */ code.Make(code.OpConstant, 0),
+				/* 0003 */ code.Make(code.OpConstant, 1),
+				/* 0006 */ code.Make(code.OpGreaterThan),
+				/* 0007 */ code.Make(code.OpJumpNotTruthy, 14),
+				/* 0010 */ code.Make(code.OpConstant, 2),
+				/* 0013 */ code.Make(code.OpPop),
+				/* 0014 */ code.Make(code.OpConstant, 3),
+				/* 0017 */ code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (1 > 2) { 10 } else { 20 }; 3333;",
+			expectedConstants: []interface{}{1, 2, 10, 20, 3333},
+			expectedInstructions: []code.Instructions{
+				/* 0000 */ code.Make(code.OpConstant, 0),
+				/* 0003 */ code.Make(code.OpConstant, 1),
+				/* 0006 */ code.Make(code.OpGreaterThan),
+				/* 0007 */ code.Make(code.OpJumpNotTruthy, 17),
+				/* 0010 */ code.Make(code.OpConstant, 2),
+				/* 0013 */ code.Make(code.OpPop),
+				/* 0014 */ code.Make(code.OpJump, 21),
+				/* 0017 */ code.Make(code.OpConstant, 3),
+				/* 0020 */ code.Make(code.OpPop),
+				/* 0021 */ code.Make(code.OpConstant, 4),
+				/* 0024 */ code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { 10 }; 3333;",
+			expectedConstants: []interface{}{3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 函数主体里的 if 语句
+			input: "fn(x) { if (x) { 1 }; 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					/* 0000 */ code.Make(code.OpGetLocal, 0),
+					/* 0002 */ code.Make(code.OpJumpNotTruthy, 9),
+					/* 0005 */ code.Make(code.OpConstant, 0),
+					/* 0008 */ code.Make(code.OpPop),
+					/* 0009 */ code.Make(code.OpConstant, 1),
+					/* 0012 */ code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 最后一个语句的值会被使用（作为函数的返回值），仍然需要 OpNull
+			input: "fn(x) { if (x) { 1 } }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					/* 0000 */ code.Make(code.OpGetLocal, 0),
+					/* 0002 */ code.Make(code.OpJumpNotTruthy, 11),
+					/* 0005 */ code.Make(code.OpConstant, 0),
+					/* 0008 */ code.Make(code.OpJump, 12),
+					/* 0011 */ code.Make(code.OpNull),
+					/* 0012 */ code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "try { 1 } catch (e) { e }; 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				/* 0000 */ code.Make(code.OpSetHandler, 10),
+				/* 0003 */ code.Make(code.OpConstant, 0),
+				/* 0006 */ code.Make(code.OpPopHandler),
+				/* 0007 */ code.Make(code.OpJump, 16),
+				/* 0010 */ code.Make(code.OpSetGlobal, 0),
+				/* 0013 */ code.Make(code.OpGetGlobal, 0),
+				/* 0016 */ code.Make(code.OpPop),
+				/* 0017 */ code.Make(code.OpConstant, 1),
+				/* 0020 */ code.Make(code.OpPop),
+			},
+		},
+		{
+			// 空的语句块的值为 Null
+			input: "fn() { try { } catch (e) { } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					/* 0000 */ code.Make(code.OpSetHandler, 8),
+					/* 0003 */ code.Make(code.OpNull),
+					/* 0004 */ code.Make(code.OpPopHandler),
+					/* 0005 */ code.Make(code.OpJump, 11),
+					/* 0008 */ code.Make(code.OpSetLocal, 0),
+					/* 0010 */ code.Make(code.OpNull),
+					/* 0011 */ code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "match 1 { 2 => 3, _ => 4 }",
+			expectedConstants: []interface{}{1, 2, 3, 4},
+			expectedInstructions: []code.Instructions{
+				/* 0000 */ code.Make(code.OpConstant, 0),
+				/* 0003 */ code.Make(code.OpDup),
+				/* 0004 */ code.Make(code.OpConstant, 1),
+				/* 0007 */ code.Make(code.OpEqual),
+				/* 0008 */ code.Make(code.OpJumpNotTruthy, 18),
+				/* 0011 */ code.Make(code.OpPop),
+				/* 0012 */ code.Make(code.OpConstant, 2),
+				/* 0015 */ code.Make(code.OpJump, 22),
+				/* 0018 */ code.Make(code.OpPop),
+				/* 0019 */ code.Make(code.OpConstant, 3),
+				/* 0022 */ code.Make(code.OpPop),
+			},
+		},
+		{
+			// 没有默认分支时值为 Null
+			input:             "match 1 { }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// let 语句之后不生成 OpPop，没有值的分支补上 OpNull
+func TestStatementsWithoutValue(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let a = 1; let b = a;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			input:             "let c = 1; let y = if (c > 0) { let a = 2 };",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				/* 0000 */ code.Make(code.OpConstant, 0),
+				/* 0003 */ code.Make(code.OpSetGlobal, 0),
+				/* 0006 */ code.Make(code.OpGetGlobal, 0),
+				/* 0009 */ code.Make(code.OpConstant, 1),
+				/* 0012 */ code.Make(code.OpGreaterThan),
+				/* 0013 */ code.Make(code.OpJumpNotTruthy, 26),
+				/* 0016 */ code.Make(code.OpConstant, 2),
+				/* 0019 */ code.Make(code.OpSetGlobal, 2),
+				/* 0022 */ code.Make(code.OpNull),
+				/* 0023 */ code.Make(code.OpJump, 27),
+				/* 0026 */ code.Make(code.OpNull),
+				/* 0027 */ code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			input:             "if (true) { let a = 1 }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// 条件是布尔字面量的 if 表达式只生成被执行的分支的指令，没有跳转指令
+func TestConstantConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 }; 3333;",
 			expectedConstants: []interface{}{10, 3333},
 			expectedInstructions: []code.Instructions{
-				/* 0000 */ code.Make(code.OpTrue), // 1 bytes
-				/* 0001 */ code.Make(code.OpJumpNotTruthy, 10), // 3 bytes
-				/* 0004 */ code.Make(code.OpConstant, 0), // 3 bytes, ;; "10"
-				/* 0007 */ code.Make(code.OpJump, 11), // 3 bytes
-				/* 0010 */ code.Make(code.OpNull), // 1 bytes ;; 因为缺少 alternative 语句块而补上的指令
-				/* 0011 */ code.Make(code.OpPop), // 1 bytes
-				/* 0012 */ code.Make(code.OpConstant, 1), // 3 bytes, ;; "3333"
-				/* 0015 */ code.Make(code.OpPop), // 1 bytes
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (true) { 10 } else { 20 }",
+			expectedConstants: []interface{}{10},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { 10 } else { 20 }",
+			expectedConstants: []interface{}{20},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { 10 }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 空的分支的值为 Null，并且不能移除前面语句的 OpPop
+			input:             "1; if (true) { }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
 			},
 		},
 	}
+
 	runCompilerTests(t, tests)
 }
 
@@ -521,6 +830,26 @@ func TestHashLiterals(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             `{"b": 1, true: 2, 10: 3, false: 4, 2: 5, "a": 6}`,
+			expectedConstants: []interface{}{"b", 1, 2, 10, 3, 4, 5, "a", 6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpTrue),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpFalse),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 6),
+				code.Make(code.OpConstant, 7),
+				code.Make(code.OpConstant, 8),
+				code.Make(code.OpHash, 12),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "{1: 2 + 3, 4: 5 * 6}",
 			expectedConstants: []interface{}{1, 2, 3, 4, 5, 6},
@@ -546,14 +875,14 @@ func TestIndexExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input:             "[1, 2, 3][1 + 1]",
-			expectedConstants: []interface{}{1, 2, 3, 1, 1},
+			expectedConstants: []interface{}{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpAdd),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -561,13 +890,13 @@ func TestIndexExpressions(t *testing.T) {
 		},
 		{
 			input:             "{1: 2}[2 - 1]",
-			expectedConstants: []interface{}{1, 2, 2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpHash, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSub),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -1148,13 +1477,12 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 			},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpCall, 1),
 				code.Make(code.OpPop),
 			},
@@ -1177,18 +1505,17 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 				[]code.Instructions{
 					code.Make(code.OpClosure, 1, 0),
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 2),
+					code.Make(code.OpConstant, 0),
 					code.Make(code.OpCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
@@ -1198,3 +1525,926 @@ func TestRecursiveFunctions(t *testing.T) {
 	}
 	runCompilerTests(t, tests)
 }
+
+func TestBlockExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "{ 1; 2 }",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = { let a = 1; a };",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input:             "{ let a = 1; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLetRecStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			letrec { a = fn() { b }; b = 1 }
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestLetRecOnlyAtTopLevel(t *testing.T) {
+	program := parse(`fn() { letrec { a = 1 } }`)
+	compiler := New()
+	err := compiler.Compile(program)
+	if err == nil {
+		t.Fatalf("expected compiler error but resulted in none.")
+	}
+
+	expected := "letrec is only supported at the top level"
+	if err.Error() != expected {
+		t.Fatalf("wrong compiler error: expected %q, actual %q", expected, err)
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let x = 1;
+			x = 2;
+			`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDup),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let x = 1;
+			x += 2;
+			`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpDup),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			fn() { let x = 1; x *= 2 }
+			`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpMul),
+					code.Make(code.OpDup),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestIncrementExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x = x + 1; x += 1; x = x - 1; x -= 1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpIncrementGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpIncrementGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpDecrementGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpDecrementGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(n) { n = n + 1; n -= 1 }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpIncrementLocal, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpDecrementLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 其他形式（比如 `x = 1 + x`、`x = y + 1` 以及 `x = x + 2`）仍然使用 OpAdd
+			input:             "let x = 1; let y = 2; x = y + 1; x = x + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpDup),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpDup),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestIndexAssignExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let arr = [1];
+			arr[0] = 99;
+			`,
+			expectedConstants: []interface{}{1, 0, 99},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestAssignExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`y = 1`, "undefined variable y"},
+		{`len = 1`, "cannot assign to BUILTIN variable len"},
+		{`fn() { let a = 1; fn() { a = 2 } }`, "cannot assign to FREE variable a"},
+		{`let a = [1]; a[0] += 1`, "compound assignment to index expression not supported: +="},
+	}
+
+	for _, test := range tests {
+		program := parse(test.input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error but resulted in none.")
+		}
+
+		if err.Error() != test.expected {
+			t.Errorf("wrong compiler error: expected %q, actual %q", test.expected, err)
+		}
+	}
+}
+
+func TestConstantDeduplication(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" + "1" + "a"; 1`,
+			expectedConstants: []interface{}{"a", "1", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 函数不会被合并
+			input: "fn() { 1 }; fn() { 1 }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestCompileSource(t *testing.T) {
+	bytecode, parserErrors, err := CompileSource("1 + 2")
+	if len(parserErrors) != 0 || err != nil {
+		t.Fatalf("unexpected errors: %v, %v", parserErrors, err)
+	}
+
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpAdd),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	err = testConstants(t, []interface{}{1, 2}, bytecode.Constants)
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+
+	bytecode, parserErrors, err = CompileSource("let = 1")
+	if bytecode != nil || len(parserErrors) == 0 || err != nil {
+		t.Errorf("expected parser errors only, actual %v, %v, %v", bytecode, parserErrors, err)
+	}
+
+	bytecode, parserErrors, err = CompileSource("foo")
+	if bytecode != nil || len(parserErrors) != 0 || err == nil {
+		t.Errorf("expected compile error only, actual %v, %v, %v", bytecode, parserErrors, err)
+	}
+	if err != nil && err.Error() != "undefined variable foo" {
+		t.Errorf("wrong compiler error: %s", err)
+	}
+}
+
+func TestBytecodeEqual(t *testing.T) {
+	compile := func(src string) *Bytecode {
+		bytecode, parserErrors, err := CompileSource(src)
+		if len(parserErrors) != 0 || err != nil {
+			t.Fatalf("unexpected errors for %q: %v, %v", src, parserErrors, err)
+		}
+		return bytecode
+	}
+
+	source := `let add = fn(a, b = 2) { a + b }; add(1, "s")`
+	if !compile(source).Equal(compile(source)) {
+		t.Errorf("bytecode of the same source is not equal")
+	}
+
+	// 不比较行号表
+	if !compile("1 + 2").Equal(compile("\n\n1 + 2")) {
+		t.Errorf("bytecode differing only in lines is not equal")
+	}
+
+	tests := []struct {
+		a string
+		b string
+	}{
+		{"1 + 2", "1 - 2"},
+		{"1 + 2", "1 + 3"},
+		{`"a"`, `"b"`},
+		{"1", `"1"`},
+		{"fn(a) { a }", "fn(a) { a; a }"},
+		{"fn(a) { a }", "fn(a, b) { a }"},
+		{"fn(a) { a }", "fn(a = 1) { a }"},
+		{"let f = fn() { 1 }", "let g = fn() { 1 }"},
+	}
+
+	for _, test := range tests {
+		if compile(test.a).Equal(compile(test.b)) {
+			t.Errorf("bytecode of %q and %q is equal", test.a, test.b)
+		}
+	}
+
+	var empty *Bytecode
+	if empty.Equal(compile("1")) || !empty.Equal(nil) {
+		t.Errorf("wrong result for nil bytecode")
+	}
+}
+
+func TestFunctionDefaultParameters(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(a, b = 10) { a + b }`,
+			expectedConstants: []interface{}{
+				10,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestLogicalAndExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				/* 0000 */ code.Make(code.OpTrue),
+				/* 0001 */ code.Make(code.OpJumpNotTruthy, 8),
+				/* 0004 */ code.Make(code.OpFalse),
+				/* 0005 */ code.Make(code.OpJump, 9),
+				/* 0008 */ code.Make(code.OpFalse),
+				/* 0009 */ code.Make(code.OpPop),
+			},
+		},
+		{
+			// 链式比较，中间的操作数为字面量，直接求值两次
+			input:             "1 < 2 < 3",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				/* 0000 */ code.Make(code.OpConstant, 0),
+				/* 0003 */ code.Make(code.OpConstant, 1),
+				/* 0006 */ code.Make(code.OpLessThan),
+				/* 0007 */ code.Make(code.OpJumpNotTruthy, 20),
+				/* 0010 */ code.Make(code.OpConstant, 1),
+				/* 0013 */ code.Make(code.OpConstant, 2),
+				/* 0016 */ code.Make(code.OpLessThan),
+				/* 0017 */ code.Make(code.OpJump, 21),
+				/* 0020 */ code.Make(code.OpFalse),
+				/* 0021 */ code.Make(code.OpPop),
+			},
+		},
+		{
+			// 链式比较，中间的操作数为函数调用，第一次求值后保存到临时变量
+			input: "let f = fn() { 2 }; 1 < f() < 3",
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				3,
+			},
+			expectedInstructions: []code.Instructions{
+				/* 0000 */ code.Make(code.OpClosure, 1, 0),
+				/* 0004 */ code.Make(code.OpSetGlobal, 0),
+				/* 0007 */ code.Make(code.OpConstant, 2),
+				/* 0010 */ code.Make(code.OpGetGlobal, 0),
+				/* 0013 */ code.Make(code.OpCall, 0),
+				/* 0015 */ code.Make(code.OpDup),
+				/* 0016 */ code.Make(code.OpSetGlobal, 1),
+				/* 0019 */ code.Make(code.OpLessThan),
+				/* 0020 */ code.Make(code.OpJumpNotTruthy, 33),
+				/* 0023 */ code.Make(code.OpGetGlobal, 1),
+				/* 0026 */ code.Make(code.OpConstant, 3),
+				/* 0029 */ code.Make(code.OpLessThan),
+				/* 0030 */ code.Make(code.OpJump, 34),
+				/* 0033 */ code.Make(code.OpFalse),
+				/* 0034 */ code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestConditionalExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true ? 10 : 20; 3333;",
+			expectedConstants: []interface{}{10, 20, 3333},
+			expectedInstructions: []code.Instructions{
+				/* 0000 */ code.Make(code.OpTrue),
+				/* 0001 */ code.Make(code.OpJumpNotTruthy, 10),
+				/* 0004 */ code.Make(code.OpConstant, 0),
+				/* 0007 */ code.Make(code.OpJump, 13),
+				/* 0010 */ code.Make(code.OpConstant, 1),
+				/* 0013 */ code.Make(code.OpPop),
+				/* 0014 */ code.Make(code.OpConstant, 2),
+				/* 0017 */ code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestExitCall(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "exit(); 1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpHalt),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "exit(2)",
+			expectedConstants: []interface{}{2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpHalt),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+
+	program := parse("exit(1, 2)")
+	compiler := New()
+	err := compiler.Compile(program)
+	expected := "wrong number of arguments to exit, expected 0 or 1, actual 2"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong compiler error, expected %q, actual %v", expected, err)
+	}
+}
+
+func TestLineTable(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x) {
+	let y = x + a;
+	y * 2
+};
+f(3)`
+
+	program := parse(input)
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	expected := code.LineTable{
+		{Offset: 0, Line: 1},  // OpConstant 0
+		{Offset: 6, Line: 2},  // OpClosure 2 0
+		{Offset: 13, Line: 6}, // OpGetGlobal 1
+	}
+	if !reflect.DeepEqual(bytecode.Lines, expected) {
+		t.Errorf("wrong line table, expected %v, actual %v", expected, bytecode.Lines)
+	}
+
+	fn, ok := bytecode.Constants[2].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 2 is not CompiledFunction, actual %T", bytecode.Constants[2])
+	}
+	expectedFn := code.LineTable{
+		{Offset: 0, Line: 3}, // OpGetLocal 0
+		{Offset: 8, Line: 4}, // OpGetLocal 1
+	}
+	if !reflect.DeepEqual(fn.Lines, expectedFn) {
+		t.Errorf("wrong function line table, expected %v, actual %v", expectedFn, fn.Lines)
+	}
+
+	lines := []struct {
+		offset int
+		line   int
+	}{
+		{0, 1}, {3, 1}, {6, 2}, {10, 2}, {13, 6}, {21, 6},
+	}
+	for _, l := range lines {
+		if actual := bytecode.Lines.Line(l.offset); actual != l.line {
+			t.Errorf("wrong line for offset %d, expected %d, actual %d", l.offset, l.line, actual)
+		}
+	}
+}
+
+// 函数声明语句（`fn f() {}`）的指令属于 fn 所在的行
+func TestFunctionDeclarationLineTable(t *testing.T) {
+	input := "1;\nfn f() { 1 / 0 }\nf()"
+
+	program := parse(input)
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := code.LineTable{
+		{Offset: 0, Line: 1},  // OpConstant 0
+		{Offset: 4, Line: 2},  // OpClosure 2 0
+		{Offset: 11, Line: 3}, // OpGetGlobal 0
+	}
+	lines := compiler.Bytecode().Lines
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("wrong line table, expected %v, actual %v", expected, lines)
+	}
+}
+
+func TestKeepLastValue(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1; 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+			},
+		},
+		{
+			// 最后一个语句不是表达式语句时没有可以省略的 OpPop
+			input:             "1; let a = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			// 只省略顶层语句的 OpPop，函数主体不受影响
+			input: "fn() { 1; 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		program := parse(test.input)
+		compiler := New()
+		compiler.SetKeepLastValue(true)
+
+		err := compiler.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+		err = testInstructions(test.expectedInstructions, bytecode.Instructions)
+		if err != nil {
+			t.Fatalf("testInstructions failed for %q: %s", test.input, err)
+		}
+
+		err = testConstants(t, test.expectedConstants, bytecode.Constants)
+		if err != nil {
+			t.Fatalf("testConstants failed for %q: %s", test.input, err)
+		}
+	}
+}
+
+func TestCompileErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		message  string
+		line     int
+		column   int
+		position string // String() 的结果
+	}{
+		{"let a = 1;\nlet b = a + c;", "undefined variable c", 2, 13, "2:13: undefined variable c"},
+		{"let f = fn() {\n\n    x = 1\n};", "undefined variable x", 3, 5, "3:5: undefined variable x"},
+		{"let g = fn() {\n  letrec { h = 1 }\n};", "letrec is only supported at the top level", 2, 3,
+			"2:3: letrec is only supported at the top level"},
+		{"1;\n  exit(1, 2)", "wrong number of arguments to exit, expected 0 or 1, actual 2", 2, 3,
+			"2:3: wrong number of arguments to exit, expected 0 or 1, actual 2"},
+		{"let a = [1];\na[0] += 1", "compound assignment to index expression not supported: +=", 2, 6,
+			"2:6: compound assignment to index expression not supported: +="},
+	}
+
+	for _, test := range tests {
+		program := parse(test.input)
+		compiler := New()
+		err := compiler.Compile(program)
+
+		compileError, ok := err.(*CompileError)
+		if !ok {
+			t.Fatalf("error is not *CompileError for %q, actual %T (%v)", test.input, err, err)
+		}
+
+		if compileError.Error() != test.message {
+			t.Errorf("wrong message, expected %q, actual %q", test.message, compileError.Error())
+		}
+		if compileError.Line != test.line || compileError.Column != test.column {
+			t.Errorf("wrong position for %q, expected %d:%d, actual %d:%d", test.input,
+				test.line, test.column, compileError.Line, compileError.Column)
+		}
+		if compileError.String() != test.position {
+			t.Errorf("wrong String(), expected %q, actual %q", test.position, compileError.String())
+		}
+	}
+}
+
+func TestStrictShadowing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // 开启 SetStrictShadowing 之后的编译错误，空字符串表示没有错误
+	}{
+		{"let x = 1; let f = fn() { let x = 2; x }; f()", "1:31: x shadows a binding in an outer scope"},
+		{"let x = 1; if (true) { let x = 2; x }", "1:28: x shadows a binding in an outer scope"},
+		{"let f = fn(x) { fn() { let x = 3; x } }", "1:28: x shadows a binding in an outer scope"},
+		// 外层的变量已经被当前函数捕获
+		{"let f = fn(x) { fn() { let y = x; let x = 3; x } }", "1:39: x shadows a binding in an outer scope"},
+		{"let f = fn() { let f = 1; f }", "1:20: f shadows a binding in an outer scope"},
+		{"const x = 1; fn() { const x = 2 }", "1:27: x shadows a binding in an outer scope"},
+		// 在同一个范围里重新定义不算覆盖
+		{"let x = 1; let x = 2; x", ""},
+		{"let f = fn(x) { let x = 2; x }", ""},
+		{"if (true) { let a = 1; a }; if (true) { let a = 2; a }", ""},
+		// 内置函数不算外层的绑定
+		{"let len = fn(a) { 0 }; len([1])", ""},
+	}
+
+	for _, test := range tests {
+		// 默认允许覆盖
+		err := New().Compile(parse(test.input))
+		if err != nil {
+			t.Errorf("unexpected compiler error for %q: %s", test.input, err)
+		}
+
+		compiler := New()
+		compiler.SetStrictShadowing(true)
+		err = compiler.Compile(parse(test.input))
+
+		if test.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected compiler error for %q: %s", test.input, err)
+			}
+			continue
+		}
+
+		compileError, ok := err.(*CompileError)
+		if !ok {
+			t.Errorf("error is not *CompileError for %q, actual %T (%v)", test.input, err, err)
+			continue
+		}
+		if compileError.String() != test.expected {
+			t.Errorf("wrong error for %q, expected %q, actual %q", test.input, test.expected, compileError.String())
+		}
+	}
+}
+
+func TestCompileExpression(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "2 * 3",
+			expectedConstants: []interface{}{2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+			},
+		},
+		{
+			input:             "if (true) { 1 } else { 2 }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		program := parse(test.input)
+		expression := program.Statements[0].(*ast.ExpressionStatement).Expression
+
+		compiler := New()
+		err := compiler.CompileExpression(expression)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+		err = testInstructions(test.expectedInstructions, bytecode.Instructions)
+		if err != nil {
+			t.Fatalf("testInstructions failed for %q: %s", test.input, err)
+		}
+
+		err = testConstants(t, test.expectedConstants, bytecode.Constants)
+		if err != nil {
+			t.Fatalf("testConstants failed for %q: %s", test.input, err)
+		}
+	}
+}
+
+func TestConstStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "const x = 1; x;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 函数里可以定义跟外层常量同名的局部变量
+			input: "const x = 1; fn() { let x = 2; x = 3 }",
+			expectedConstants: []interface{}{1, 2, 3, []code.Instructions{
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpDup),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"const x = 1; x = 2;", "cannot assign to constant x"},
+		{"const x = 1; x += 2;", "cannot assign to constant x"},
+		{"fn() { const y = 1; y = 2 }", "cannot assign to constant y"},
+		{"const x = 1; fn() { x = 2 }", "cannot assign to constant x"},
+		{"const x = 1; let x = 2;", "cannot redeclare constant x"},
+		{"const x = 1; const x = 2;", "cannot redeclare constant x"},
+	}
+
+	for _, test := range errorTests {
+		program := parse(test.input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("wrong compiler error for %q, expected %q, actual %v",
+				test.input, test.expected, err)
+		}
+	}
+}
+
+func TestBlockScopes(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// 语句块里的变量仍然分配在全局变量列表里
+			input:             "let x = 1; if (x > 0) { let t = 2; t }",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				/* 0000 */ code.Make(code.OpConstant, 0),
+				/* 0003 */ code.Make(code.OpSetGlobal, 0),
+				/* 0006 */ code.Make(code.OpGetGlobal, 0),
+				/* 0009 */ code.Make(code.OpConstant, 1),
+				/* 0012 */ code.Make(code.OpGreaterThan),
+				/* 0013 */ code.Make(code.OpJumpNotTruthy, 28),
+				/* 0016 */ code.Make(code.OpConstant, 2),
+				/* 0019 */ code.Make(code.OpSetGlobal, 1),
+				/* 0022 */ code.Make(code.OpGetGlobal, 1),
+				/* 0025 */ code.Make(code.OpJump, 29),
+				/* 0028 */ code.Make(code.OpNull),
+				/* 0029 */ code.Make(code.OpPop),
+			},
+		},
+		{
+			// 函数里语句块的变量分配在函数的局部变量里，
+			// 同名的变量（a）是不同的变量
+			input: "fn() { let a = 1; if (a) { let a = 2; let t = a }; a }",
+			expectedConstants: []interface{}{1, 2, []code.Instructions{
+				/* 0000 */ code.Make(code.OpConstant, 0),
+				/* 0003 */ code.Make(code.OpSetLocal, 0),
+				/* 0005 */ code.Make(code.OpGetLocal, 0),
+				/* 0007 */ code.Make(code.OpJumpNotTruthy, 19),
+				/* 0010 */ code.Make(code.OpConstant, 1),
+				/* 0013 */ code.Make(code.OpSetLocal, 1),
+				/* 0015 */ code.Make(code.OpGetLocal, 1),
+				/* 0017 */ code.Make(code.OpSetLocal, 2),
+				/* 0019 */ code.Make(code.OpGetLocal, 0),
+				/* 0021 */ code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+
+	// 语句块里定义的变量在语句块之外不可见
+	errorTests := []string{
+		"if (true) { let t = 1; }; t;",
+		"if (1 > 2) { 1 } else { let t = 1; }; t;",
+		"fn() { if (true) { let t = 1; }; t }",
+		"let v = { let t = 1; t }; t",
+		"if (true) { if (true) { let t = 1 }; t }",
+	}
+
+	for _, input := range errorTests {
+		program := parse(input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil || err.Error() != "undefined variable t" {
+			t.Errorf("wrong compiler error for %q, expected %q, actual %v",
+				input, "undefined variable t", err)
+		}
+	}
+
+	// 语句块里的变量占用所在函数的局部变量的位置
+	program := parse("fn() { if (true) { let t = 1 }; let u = 2; u }")
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	fn := compiler.Bytecode().Constants[2].(*object.CompiledFunction)
+	if fn.NumLocals != 2 {
+		t.Errorf("wrong NumLocals, expected 2, actual %d", fn.NumLocals)
+	}
+	if compiler.symbolTable.NumDefinitions() != 0 {
+		t.Errorf("block symbols leaked to the global scope, actual %d definitions",
+			compiler.symbolTable.NumDefinitions())
+	}
+}
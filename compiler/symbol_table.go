@@ -1,12 +1,15 @@
 package compiler
 
+import "sort"
+
 type SymbolScope string
 
 // 符号/标识符
 type Symbol struct {
-	Name  string      // 符号的名称
-	Scope SymbolScope // 符号的范围
-	Index int         // 符号的索引
+	Name      string      // 符号的名称
+	Scope     SymbolScope // 符号的范围
+	Index     int         // 符号的索引
+	Immutable bool        // 是否不可重新赋值，即由 const 语句定义
 }
 
 type SymbolTable struct {
@@ -16,6 +19,9 @@ type SymbolTable struct {
 	// 上层符号表，nil 表示最外层，也就是 Global 层
 	Outer *SymbolTable
 
+	// 是否为语句块（比如 if 的分支）的符号表，见 NewBlockSymbolTable
+	block bool
+
 	// 闭包函数所捕获的局部变量（即，在当前函数之外定义，且被当前函数所使用的局部变量）
 	// 只有在编译用户自定义函数的过程中，逐个变量编译之后，才会产生完整的 FreeSymbols 列表。
 	// 注意，
@@ -45,21 +51,65 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	return s
 }
 
+// 语句块的符号表
+// 在语句块里定义的名称只在该语句块（及其内层）里可见，但是变量本身跟所在函数
+// （或者全局）的其他变量一样分配在函数的调用帧（或者全局变量列表）里，
+// 所以语句块的符号表不单独计算符号的数量，而是使用所在函数的符号表的编号。
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer)
+	s.block = true
+	return s
+}
+
+// 返回分配变量位置的符号表，即跳过语句块之后所在的函数（或者全局）的符号表
+func (s *SymbolTable) owner() *SymbolTable {
+	table := s
+	for table.block {
+		table = table.Outer
+	}
+	return table
+}
+
 func (s *SymbolTable) Define(name string) Symbol {
+	owner := s.owner()
 	symbol := Symbol{
 		Name:  name,
-		Index: s.numDefinitions, // 使用当前记录数量作为符号的索引值
+		Index: owner.numDefinitions, // 使用当前记录数量作为符号的索引值
 		// Scope: GlobalScope,
 	}
 
-	if s.Outer == nil {
+	if owner.Outer == nil {
 		symbol.Scope = GlobalScope
 	} else {
 		symbol.Scope = LocalScope
 	}
 
 	s.store[name] = symbol
-	s.numDefinitions++
+	owner.numDefinitions++
+	return symbol
+}
+
+// 定义一个不可重新赋值的符号（常量绑定），用于 const 语句
+func (s *SymbolTable) DefineConst(name string) Symbol {
+	symbol := s.Define(name)
+	symbol.Immutable = true
+	s.store[name] = symbol
+	return symbol
+}
+
+// 定义一个没有名称的临时变量，用于保存编译器生成的中间值，
+// 它占用一个变量的位置，但无法通过名称访问，也不包括在 DefinedSymbols 里
+func (s *SymbolTable) defineTemporary() Symbol {
+	owner := s.owner()
+	symbol := Symbol{Index: owner.numDefinitions}
+
+	if owner.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	owner.numDefinitions++
 	return symbol
 }
 
@@ -76,6 +126,7 @@ func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	// 再以 FreeScope 的形式把来自上层的 Symbol 添加到当前 store
 	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
 	symbol.Scope = FreeScope
+	symbol.Immutable = original.Immutable
 	s.store[original.Name] = symbol
 	return symbol
 }
@@ -83,6 +134,11 @@ func (s *SymbolTable) defineFree(original Symbol) Symbol {
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
 
+	// 语句块跟外层属于同一个函数，所以外层的符号原样返回，而不是作为被捕获的变量
+	if !ok && s.block {
+		return s.Outer.Resolve(name)
+	}
+
 	if !ok && s.Outer != nil {
 		obj, ok = s.Outer.Resolve(name)
 
@@ -106,8 +162,75 @@ func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	return obj, ok
 }
 
+// 只在当前符号表（不包括外层）查找名称 name 对应的符号
+func (s *SymbolTable) lookupLocal(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	return symbol, ok
+}
+
+// 判断在当前符号表定义名称 name 是否会覆盖外层范围里的同名符号（内置函数除外），
+// 在同一个范围里重新定义（比如函数主体里定义跟参数同名的变量）不算覆盖。
+// 注：
+// 不能使用 Resolve，因为它会把外层函数的局部变量添加为当前函数捕获的变量
+func (s *SymbolTable) shadows(name string) bool {
+	if symbol, ok := s.store[name]; ok {
+		// 被捕获的变量以及函数自身的名称也是在外层定义的
+		return symbol.Scope == FreeScope || symbol.Scope == FunctionScope
+	}
+
+	for table := s.Outer; table != nil; table = table.Outer {
+		if symbol, ok := table.store[name]; ok {
+			return symbol.Scope != BuiltinScope
+		}
+	}
+	return false
+}
+
 func (s *SymbolTable) DefineFunctionName(name string) Symbol {
 	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
 	s.store[name] = symbol
 	return symbol
 }
+
+// 返回当前符号表（不包括上层）里由 Define 定义的符号，按照定义的顺序（即索引）排列
+// 注：
+// 内置函数、函数名称以及被捕获的变量不属于当前符号表定义的符号，所以不包括在内；
+// 被同名符号覆盖了的旧符号也不再包括在内。
+func (s *SymbolTable) DefinedSymbols() []Symbol {
+	symbols := []Symbol{}
+	for _, symbol := range s.store {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Index < symbols[j].Index
+	})
+	return symbols
+}
+
+// 返回当前符号表（不包括上层）里由 Define 定义的符号的名称，按照定义的顺序排列
+func (s *SymbolTable) DefinedNames() []string {
+	symbols := s.DefinedSymbols()
+	names := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		names[i] = symbol.Name
+	}
+	return names
+}
+
+// 符号的数量（包括被同名符号覆盖了的旧符号），也就是下一个符号的索引
+func (s *SymbolTable) NumDefinitions() int {
+	return s.numDefinitions
+}
+
+// 恢复之前由 DefinedSymbols 和 NumDefinitions 获取的符号，
+// 用于在新的符号表里重建一个（比如保存到磁盘的）会话的全局符号，
+// 符号的索引保持不变，所以已编译的指令仍然可以访问到正确的变量。
+func (s *SymbolTable) Restore(symbols []Symbol, numDefinitions int) {
+	for _, symbol := range symbols {
+		s.store[symbol.Name] = symbol
+	}
+	s.numDefinitions = numDefinitions
+}
@@ -373,3 +373,68 @@ func TestShadowingFunctionName(t *testing.T) {
 			expected.Name, expected, result)
 	}
 }
+
+func TestDefinedNames(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	global.Define("b")
+	global.Define("a")
+	global.DefineFunctionName("f")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("c")
+
+	tests := []struct {
+		table    *SymbolTable
+		expected []string
+	}{
+		{global, []string{"b", "a"}},
+		{local, []string{"c"}},
+	}
+
+	for _, test := range tests {
+		names := test.table.DefinedNames()
+		if len(names) != len(test.expected) {
+			t.Errorf("wrong number of names. expected %v, actual %v",
+				test.expected, names)
+			continue
+		}
+		for i, name := range test.expected {
+			if names[i] != name {
+				t.Errorf("wrong name at %d. expected %q, actual %q", i, name, names[i])
+			}
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	original := NewSymbolTable()
+	original.Define("a")
+	original.Define("b")
+	original.Define("a") // 覆盖旧的 a
+
+	restored := NewSymbolTable()
+	restored.Restore(original.DefinedSymbols(), original.NumDefinitions())
+
+	expected := []Symbol{
+		Symbol{Name: "b", Scope: GlobalScope, Index: 1},
+		Symbol{Name: "a", Scope: GlobalScope, Index: 2},
+	}
+
+	for _, sym := range expected {
+		result, ok := restored.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, actual %+v",
+				sym.Name, sym, result)
+		}
+	}
+
+	c := restored.Define("c")
+	if c.Index != 3 {
+		t.Errorf("expected new symbol index 3, actual %d", c.Index)
+	}
+}
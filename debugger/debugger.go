@@ -0,0 +1,183 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"toyvm/compiler"
+	"toyvm/object"
+	"toyvm/vm"
+)
+
+const PROMPT = "(debug) "
+
+const HELP = `Commands:
+  step, s        execute one instruction
+  continue, c    run until a breakpoint is reached or the program halts
+  break N, b N   set a breakpoint at offset N of the main program
+  stack          print the stack, from bottom to top
+  locals         print the local variables of the current frame
+  disasm         print the instructions of the current frame
+  quit, q        quit the debugger`
+
+// 调试器的状态
+type debugger struct {
+	out     io.Writer
+	machine *vm.VM
+	halted  bool
+}
+
+// 启动调试器，从 in 读取命令，逐条执行字节码
+func Start(in io.Reader, out io.Writer, bytecode *compiler.Bytecode) {
+	object.SetOutput(out)
+	d := &debugger{
+		out:     out,
+		machine: vm.New(bytecode),
+	}
+
+	d.printCurrentInstruction()
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, PROMPT)
+		scanned := scanner.Scan()
+		if !scanned {
+			return
+		}
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		quit := d.execute(fields[0], fields[1:])
+		if quit {
+			return
+		}
+	}
+}
+
+// 执行调试命令，返回 true 表示退出调试器
+func (d *debugger) execute(command string, args []string) bool {
+	switch command {
+	case "step", "s":
+		d.step()
+	case "continue", "c":
+		d.continueToBreakpoint()
+	case "break", "b":
+		d.setBreakpoint(args)
+	case "stack":
+		printObjects(d.out, d.machine.StackSnapshot())
+	case "locals":
+		printObjects(d.out, d.machine.CurrentLocals())
+	case "disasm":
+		io.WriteString(d.out, d.machine.CurrentFrameDisassembly())
+	case "help", "h":
+		fmt.Fprintln(d.out, HELP)
+	case "quit", "q":
+		return true
+	default:
+		fmt.Fprintf(d.out, "unknown command: %s\n", command)
+	}
+
+	return false
+}
+
+func (d *debugger) step() {
+	if d.halted {
+		fmt.Fprintln(d.out, "program halted")
+		return
+	}
+
+	d.stepOnce()
+	if !d.halted {
+		d.printCurrentInstruction()
+	}
+}
+
+// 执行一条指令，程序执行完毕或者出错时输出结果并标记为已停止
+func (d *debugger) stepOnce() {
+	halted, err := d.machine.Step()
+	if err != nil {
+		fmt.Fprintf(d.out, "Executing bytecode failed: %s\n", err)
+		d.halted = true
+		return
+	}
+
+	if halted {
+		d.printHalted()
+	}
+}
+
+// 标记为已停止，并输出程序的结果
+func (d *debugger) printHalted() {
+	d.halted = true
+	fmt.Fprintln(d.out, "program halted")
+
+	lastPopped := d.machine.LastPoppedStackElem()
+	if lastPopped != nil {
+		fmt.Fprintf(d.out, "result: %s\n", lastPopped.Inspect())
+	}
+}
+
+func (d *debugger) continueToBreakpoint() {
+	if d.halted {
+		fmt.Fprintln(d.out, "program halted")
+		return
+	}
+
+	hit, err := d.machine.RunToBreakpoint()
+	if err != nil {
+		fmt.Fprintf(d.out, "Executing bytecode failed: %s\n", err)
+		d.halted = true
+		return
+	}
+
+	if !hit {
+		d.printHalted()
+		return
+	}
+
+	_, offset := d.machine.CurrentPosition()
+	fmt.Fprintf(d.out, "breakpoint at %04d\n", offset)
+	d.printCurrentInstruction()
+}
+
+func (d *debugger) setBreakpoint(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "usage: break N")
+		return
+	}
+
+	offset, err := strconv.Atoi(args[0])
+	if err != nil || offset < 0 {
+		fmt.Fprintf(d.out, "invalid offset: %s\n", args[0])
+		return
+	}
+
+	d.machine.SetBreakpoint(vm.MainFunction, offset)
+	fmt.Fprintf(d.out, "breakpoint set at %04d\n", offset)
+}
+
+// 输出下一条将要执行的指令，即反汇编文本当中以 ">" 标记的那一行
+func (d *debugger) printCurrentInstruction() {
+	disassembly := d.machine.CurrentFrameDisassembly()
+	for _, line := range strings.Split(disassembly, "\n") {
+		if strings.HasPrefix(line, ">") {
+			fmt.Fprintln(d.out, line)
+			return
+		}
+	}
+}
+
+func printObjects(out io.Writer, objs []object.Object) {
+	for i, obj := range objs {
+		if obj == nil {
+			fmt.Fprintf(out, "%d: nil\n", i)
+			continue
+		}
+		fmt.Fprintf(out, "%d: %s\n", i, obj.Inspect())
+	}
+}
@@ -0,0 +1,131 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"toyvm/compiler"
+	"toyvm/lexer"
+	"toyvm/parser"
+)
+
+// 编译后的指令：
+//
+// 0000 OpClosure 1 0
+// 0004 OpSetGlobal 0
+// 0007 OpGetGlobal 0
+// 0010 OpConstant 2
+// 0013 OpCall 1
+// 0015 OpConstant 3
+// 0018 OpAdd
+// 0019 OpPop
+//
+// 函数 f 的指令：
+//
+// 0000 OpGetLocal 0
+// 0002 OpConstant 0
+// 0005 OpMul
+// 0006 OpSetLocal 1
+// 0008 OpGetLocal 1
+// 0010 OpReturnValue
+const program = "let f = fn(a) { let b = a * 2; b }; f(3) + 1"
+
+func runDebugger(t *testing.T, input string, commands ...string) string {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	comp := compiler.New()
+	err := comp.Compile(p.ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	in := strings.NewReader(strings.Join(commands, "\n") + "\n")
+	Start(in, &out, comp.Bytecode())
+	return out.String()
+}
+
+func TestStep(t *testing.T) {
+	output := runDebugger(t, program, "step", "s", "stack", "s", "disasm")
+
+	expected := "> 0000 OpClosure 1 0\n" +
+		PROMPT + "> 0004 OpSetGlobal 0\n" +
+		PROMPT + "> 0007 OpGetGlobal 0\n" +
+		PROMPT +
+		PROMPT + "> 0010 OpConstant 2\n" +
+		PROMPT + "  0000 OpClosure 1 0\n" +
+		"  0004 OpSetGlobal 0\n" +
+		"  0007 OpGetGlobal 0\n" +
+		"> 0010 OpConstant 2\n" +
+		"  0013 OpCall 1\n" +
+		"  0015 OpConstant 3\n" +
+		"  0018 OpAdd\n" +
+		"  0019 OpPop\n" +
+		PROMPT
+
+	if output != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, output)
+	}
+}
+
+func TestStepIntoFunction(t *testing.T) {
+	output := runDebugger(t, program,
+		"break 13", "continue", "s", "s", "s", "s", "locals", "s", "s", "s")
+
+	expected := "> 0000 OpClosure 1 0\n" +
+		PROMPT + "breakpoint set at 0013\n" +
+		PROMPT + "breakpoint at 0013\n" +
+		"> 0013 OpCall 1\n" +
+		PROMPT + "> 0000 OpGetLocal 0\n" + // 进入函数 f
+		PROMPT + "> 0002 OpConstant 0\n" +
+		PROMPT + "> 0005 OpMul\n" +
+		PROMPT + "> 0006 OpSetLocal 1\n" +
+		PROMPT + "0: 3\n" + // 局部变量 b 尚未赋值
+		"1: nil\n" +
+		PROMPT + "> 0008 OpGetLocal 1\n" +
+		PROMPT + "> 0010 OpReturnValue\n" +
+		PROMPT + "> 0015 OpConstant 3\n" + // 返回 main
+		PROMPT
+
+	if output != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, output)
+	}
+}
+
+func TestContinue(t *testing.T) {
+	output := runDebugger(t, program,
+		"b 18", "c", "stack", "c", "step", "continue")
+
+	expected := "> 0000 OpClosure 1 0\n" +
+		PROMPT + "breakpoint set at 0018\n" +
+		PROMPT + "breakpoint at 0018\n" +
+		"> 0018 OpAdd\n" +
+		PROMPT + "0: 6\n" +
+		"1: 1\n" +
+		PROMPT + "program halted\n" +
+		"result: 7\n" +
+		PROMPT + "program halted\n" +
+		PROMPT + "program halted\n" +
+		PROMPT
+
+	if output != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, output)
+	}
+}
+
+func TestErrorsAndCommands(t *testing.T) {
+	output := runDebugger(t, `1 + "a"`,
+		"break", "break x", "foo", "c", "s", "quit", "s")
+
+	expected := "> 0000 OpConstant 0\n" +
+		PROMPT + "usage: break N\n" +
+		PROMPT + "invalid offset: x\n" +
+		PROMPT + "unknown command: foo\n" +
+		PROMPT + "Executing bytecode failed: unsupported types for binary operation: INTEGER STRING\n" +
+		PROMPT + "program halted\n" +
+		PROMPT
+
+	if output != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, output)
+	}
+}
@@ -2,54 +2,183 @@ package executor
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"time"
+	"toyvm/analysis"
+	"toyvm/code"
 	"toyvm/compiler"
+	"toyvm/debugger"
 	"toyvm/lexer"
+	"toyvm/object"
 	"toyvm/parser"
 	"toyvm/vm"
 )
 
+// 输出的目标，测试时可以替换为其他 Writer
+var output io.Writer = os.Stdout
+
+// 执行脚本时各个阶段所花费的时间
+type Timings struct {
+	Parse   time.Duration // 词法分析及语法分析
+	Compile time.Duration // 编译
+	Run     time.Duration // VM 执行
+}
+
+// 执行脚本的选项
+type execOptions struct {
+	timings   *Timings // 不为 nil 时记录各个阶段所花费的时间
+	profiling bool     // 是否统计每种指令的执行次数
+}
+
 func Exec(filePath string) {
+	execute(filePath, execOptions{})
+}
+
+// 编译及执行脚本，并在执行结束之后输出各个阶段所花费的时间
+func ExecWithTimings(filePath string) *Timings {
+	timings := &Timings{}
+	if _, ok := execute(filePath, execOptions{timings: timings}); ok {
+		fmt.Fprintf(output, "parse: %s\n", timings.Parse)
+		fmt.Fprintf(output, "compile: %s\n", timings.Compile)
+		fmt.Fprintf(output, "run: %s\n", timings.Run)
+	}
+	return timings
+}
+
+// 编译及执行脚本，并在执行结束之后输出每种指令的执行次数（按次数从多到少排列）
+func ExecWithProfiling(filePath string) map[code.Opcode]int {
+	machine, ok := execute(filePath, execOptions{profiling: true})
+	if !ok {
+		return nil
+	}
+
+	counts := machine.OpcodeCounts()
+	ops := make([]code.Opcode, 0, len(counts))
+	for op := range counts {
+		ops = append(ops, op)
+	}
+
+	sort.Slice(ops, func(i, j int) bool {
+		if counts[ops[i]] != counts[ops[j]] {
+			return counts[ops[i]] > counts[ops[j]]
+		}
+		return ops[i] < ops[j]
+	})
+
+	for _, op := range ops {
+		def, err := code.Lookup(byte(op))
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(output, "%-16s %d\n", def.Name, counts[op])
+	}
+	return counts
+}
+
+// 编译及执行脚本，执行成功时返回 VM 以及 true
+func execute(filePath string, options execOptions) (*vm.VM, bool) {
+	object.SetOutput(output)
+	timings := options.timings
+
 	content, err := os.ReadFile(filePath)
 	if err != nil {
-		fmt.Printf("Read file error: %s\n", err)
-		return
+		fmt.Fprintf(output, "Read file error: %s\n", err)
+		return nil, false
 	}
 
 	text := string(content)
 
+	start := time.Now()
 	l := lexer.New(text)
 	p := parser.New(l)
 	program := p.ParseProgram()
 
 	if len(p.Errors()) != 0 {
-		printParserErrors(p.Errors())
-		return
+		printParserErrors(p.Errors().Strings())
+		return nil, false
 	}
 
+	if timings != nil {
+		timings.Parse = time.Since(start)
+	}
+
+	start = time.Now()
 	comp := compiler.New()
 	err = comp.Compile(program)
 	if err != nil {
-		fmt.Printf("Compilation failed: %s\n", err)
-		return
+		fmt.Fprintf(output, "Compilation failed: %s\n", err)
+		return nil, false
+	}
+
+	if timings != nil {
+		timings.Compile = time.Since(start)
 	}
 
 	machine := vm.New(comp.Bytecode())
+	machine.SetProfiling(options.profiling)
+	machine.SetStrictErrors(true) // 执行脚本时，内置函数的错误应该中止执行而不是被忽略
+
+	start = time.Now()
 	err = machine.Run()
 	if err != nil {
-		fmt.Printf("Executing bytecode failed: %s\n", err)
-		return
+		fmt.Fprintf(output, "Executing bytecode failed: %s\n", err)
+		// 错误发生在用户自定义函数里时，同时输出调用栈
+		if runtimeErr, ok := err.(*vm.RuntimeError); ok && len(runtimeErr.Frames) > 1 {
+			fmt.Fprint(output, runtimeErr.StackTrace())
+		}
+		return nil, false
+	}
+
+	if timings != nil {
+		timings.Run = time.Since(start)
 	}
 
 	// stackTop := machine.StackTop()
+	// 空的程序（或者只有注释的程序）没有任何值被弹出，此时不输出结果
 	lastPopped := machine.LastPoppedStackElem()
-	fmt.Println(lastPopped.Inspect())
+	if lastPopped != nil {
+		fmt.Fprintln(output, lastPopped.Inspect())
+	}
+	return machine, true
 }
 
 func Assembly(filePath string) {
+	assembly(filePath, false)
+}
+
+// 编译脚本并输出汇编文本，在指令前面插入对应的源码行（类似 `objdump -S`），
+// 并且同时输出各个用户自定义函数的指令
+func AssemblyWithSource(filePath string) {
+	assembly(filePath, true)
+}
+
+func assembly(filePath string, withSource bool) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		fmt.Fprintf(output, "Read file error: %s\n", err)
+		return
+	}
+
+	bytecode, ok := compileSource(string(content))
+	if !ok {
+		return
+	}
+
+	if withSource {
+		fmt.Fprintln(output, bytecode.StringWithSource(string(content)))
+	} else {
+		fmt.Fprintln(output, bytecode.String())
+	}
+}
+
+// 解析脚本并输出语法树，每条顶层语句输出为一行，
+// 语句的文本由各个 ast.Node 的 String() 方法生成（表达式会被添加括号以显示运算的优先级）
+func PrintAST(filePath string) {
 	content, err := os.ReadFile(filePath)
 	if err != nil {
-		fmt.Printf("Read file error: %s\n", err)
+		fmt.Fprintf(output, "Read file error: %s\n", err)
 		return
 	}
 
@@ -60,23 +189,90 @@ func Assembly(filePath string) {
 	program := p.ParseProgram()
 
 	if len(p.Errors()) != 0 {
-		printParserErrors(p.Errors())
+		printParserErrors(p.Errors().Strings())
 		return
 	}
 
+	for _, statement := range program.Statements {
+		fmt.Fprintln(output, statement.String())
+	}
+}
+
+// 解析并编译脚本但不执行，用于检查脚本的语法错误和编译错误（比如未定义的变量），
+// 编译成功之后再输出未被使用的绑定的警告（警告不影响检查结果），
+// 没有错误和警告时不输出任何内容，返回脚本是否通过检查。
+// 错误和警告都包括位置（"行:列: 信息"），以便编辑器指出出错的位置
+func Check(filePath string) bool {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		fmt.Fprintf(output, "Read file error: %s\n", err)
+		return false
+	}
+
+	l := lexer.New(string(content))
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		messages := []string{}
+		for _, e := range p.Errors() {
+			messages = append(messages, e.String())
+		}
+		printParserErrors(messages)
+		return false
+	}
+
 	comp := compiler.New()
 	err = comp.Compile(program)
 	if err != nil {
-		fmt.Printf("Compilation failed: %s\n", err)
+		if compileErr, ok := err.(*compiler.CompileError); ok {
+			fmt.Fprintf(output, "Compilation failed: %s\n", compileErr.String())
+		} else {
+			fmt.Fprintf(output, "Compilation failed: %s\n", err)
+		}
+		return false
+	}
+
+	for _, warning := range analysis.UnusedBindings(program) {
+		fmt.Fprintf(output, "warning: %s\n", warning)
+	}
+	return true
+}
+
+// 编译脚本并进入调试模式，从标准输入读取调试命令
+func Debug(filePath string) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		fmt.Fprintf(output, "Read file error: %s\n", err)
 		return
 	}
 
-	fmt.Println(comp.Bytecode().Instructions.String())
+	bytecode, ok := compileSource(string(content))
+	if !ok {
+		return
+	}
+
+	fmt.Fprintln(output, "Toy VM debugger, type \"help\" for commands")
+	debugger.Start(os.Stdin, output, bytecode)
+}
+
+// 解析并编译源码，出错时输出错误信息
+func compileSource(src string) (*compiler.Bytecode, bool) {
+	bytecode, parserErrors, err := compiler.CompileSource(src)
+	if len(parserErrors) != 0 {
+		printParserErrors(parserErrors)
+		return nil, false
+	}
+	if err != nil {
+		fmt.Fprintf(output, "Compilation failed: %s\n", err)
+		return nil, false
+	}
+	return bytecode, true
 }
 
 func printParserErrors(errors []string) {
-	fmt.Println("Parser errors:")
+	fmt.Fprintln(output, "Parser errors:")
 	for _, msg := range errors {
-		fmt.Println("\t" + msg)
+		fmt.Fprintln(output, "\t"+msg)
 	}
 }
@@ -0,0 +1,244 @@
+package executor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"toyvm/code"
+)
+
+// 把源码写到临时文件，并把输出重定向到 buffer
+func prepareScript(t *testing.T, source string) (string, *bytes.Buffer) {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "script.toy")
+	err := os.WriteFile(filePath, []byte(source), 0644)
+	if err != nil {
+		t.Fatalf("write script error: %s", err)
+	}
+
+	var out bytes.Buffer
+	previous := output
+	output = &out
+	t.Cleanup(func() { output = previous })
+	return filePath, &out
+}
+
+func TestExec(t *testing.T) {
+	filePath, out := prepareScript(t, "1 + 2")
+
+	Exec(filePath)
+
+	if out.String() != "3\n" {
+		t.Errorf("wrong output, expected %q, actual %q", "3\n", out.String())
+	}
+}
+
+func TestExecBuiltinError(t *testing.T) {
+	filePath, out := prepareScript(t, "len(1); 2")
+
+	Exec(filePath)
+
+	expected := "Executing bytecode failed: argument type to `len` not supported, actual INTEGER\n"
+	if out.String() != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, out.String())
+	}
+}
+
+func TestExecStackTrace(t *testing.T) {
+	filePath, out := prepareScript(t, `let f = fn() {
+	len(1)
+};
+f();`)
+
+	Exec(filePath)
+
+	expected := "Executing bytecode failed: argument type to `len` not supported, actual INTEGER\n" +
+		"\tat f (line 2)\n" +
+		"\tat <main> (line 4)\n"
+	if out.String() != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, out.String())
+	}
+}
+
+func TestExecWithTimings(t *testing.T) {
+	filePath, out := prepareScript(t, "let f = fn(x) { x * 2 }; f(21)")
+
+	timings := ExecWithTimings(filePath)
+
+	durations := []struct {
+		name  string
+		value int64
+	}{
+		{"parse", int64(timings.Parse)},
+		{"compile", int64(timings.Compile)},
+		{"run", int64(timings.Run)},
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	if lines[0] != "42" {
+		t.Errorf("wrong result, expected %q, actual %q", "42", lines[0])
+	}
+
+	for _, d := range durations {
+		if d.value < 0 {
+			t.Errorf("%s duration is negative: %d", d.name, d.value)
+		}
+		if !strings.Contains(out.String(), d.name+": ") {
+			t.Errorf("%s duration not reported, output %q", d.name, out.String())
+		}
+	}
+}
+
+func TestExecWithTimingsFailed(t *testing.T) {
+	filePath, out := prepareScript(t, "undefinedVariable")
+
+	ExecWithTimings(filePath)
+
+	expected := "Compilation failed: undefined variable undefinedVariable\n"
+	if out.String() != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, out.String())
+	}
+}
+
+func TestExecWithProfiling(t *testing.T) {
+	filePath, out := prepareScript(t, "1 + 2 * 3")
+
+	counts := ExecWithProfiling(filePath)
+
+	if counts[code.OpConstant] != 3 || counts[code.OpMul] != 1 {
+		t.Errorf("wrong opcode counts: %v", counts)
+	}
+
+	expected := "7\n" +
+		"OpConstant       3\n" +
+		"OpPop            1\n" +
+		"OpAdd            1\n" +
+		"OpMul            1\n"
+	if out.String() != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, out.String())
+	}
+}
+
+func TestPrintAST(t *testing.T) {
+	filePath, out := prepareScript(t, `
+	let add = fn(a, b) { a + b * 2 };
+	add(1, -2);
+	return [1, 2][0];
+	`)
+
+	PrintAST(filePath)
+
+	expected := "let add = fn<add>(a, b) (a + (b * 2));\n" +
+		"add(1, (-2))\n" +
+		"return ([1, 2][0]);\n"
+	if out.String() != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, out.String())
+	}
+}
+
+func TestPrintASTParserErrors(t *testing.T) {
+	filePath, out := prepareScript(t, "let = 1")
+
+	PrintAST(filePath)
+
+	if !strings.HasPrefix(out.String(), "Parser errors:\n\t") {
+		t.Errorf("parser errors not reported, actual %q", out.String())
+	}
+}
+
+func TestAssemblyWithSource(t *testing.T) {
+	source := `let a = 1;
+let f = fn(x) {
+	let y = x + a;
+	y * 2
+};
+f(3)`
+	filePath, out := prepareScript(t, source)
+
+	AssemblyWithSource(filePath)
+
+	expected := `; 1: let a = 1;
+0000 OpConstant 0
+0003 OpSetGlobal 0
+; 2: let f = fn(x) {
+0006 OpClosure 2 0
+0010 OpSetGlobal 1
+; 6: f(3)
+0013 OpGetGlobal 1
+0016 OpConstant 3
+0019 OpCall 1
+0021 OpPop
+
+CompiledFunction (constant 2):
+; 3: let y = x + a;
+0000 OpGetLocal 0
+0002 OpGetGlobal 0
+0005 OpAdd
+0006 OpSetLocal 1
+; 4: y * 2
+0008 OpGetLocal 1
+0010 OpConstant 1
+0013 OpMul
+0014 OpReturnValue
+
+`
+	if out.String() != expected {
+		t.Errorf("wrong assembly, expected\n%s\nactual\n%s", expected, out.String())
+	}
+}
+
+func TestExecEmptyProgram(t *testing.T) {
+	sources := []string{"", "\n\n", "// comment only\n", "// first\n// second"}
+
+	for _, source := range sources {
+		filePath, out := prepareScript(t, source)
+
+		Exec(filePath)
+
+		if out.String() != "" {
+			t.Errorf("wrong output for %q, expected empty, actual %q", source, out.String())
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		source   string
+		ok       bool
+		expected string
+	}{
+		{`let a = 1; puts(a)`, true, ""},
+		{`puts("side effect"); undefinedVariable`, false,
+			"Compilation failed: 1:22: undefined variable undefinedVariable\n"},
+		{`let = 1; let b 2;`, false, "Parser errors:\n" +
+			"\t1:5: expected next token type \"IDENT\", actual \"=\"\n" +
+			"\t1:16: expected next token type \"=\", actual \"INT\"\n"},
+		{"let a = 1;\nlet b = 2;\nputs(a)", true, "warning: 2:5: unused variable b\n"},
+	}
+
+	for _, test := range tests {
+		filePath, out := prepareScript(t, test.source)
+
+		ok := Check(filePath)
+		if ok != test.ok {
+			t.Errorf("wrong result for %q, expected %t, actual %t", test.source, test.ok, ok)
+		}
+
+		// 脚本不会被执行，所以 puts 不会输出任何内容
+		if strings.Contains(out.String(), "side effect") {
+			t.Errorf("script should not be executed, output %q", out.String())
+		}
+
+		if test.expected != "" && out.String() != test.expected {
+			t.Errorf("wrong output, expected %q, actual %q", test.expected, out.String())
+		}
+		if test.ok && test.expected == "" && out.String() != "" {
+			t.Errorf("expected no output, actual %q", out.String())
+		}
+		if !test.ok && test.expected == "" && !strings.HasPrefix(out.String(), "Parser errors:\n") {
+			t.Errorf("parser errors not reported, output %q", out.String())
+		}
+	}
+}
@@ -2,31 +2,48 @@
 
 package lexer
 
-import "toyvm/token"
+import (
+	"strings"
+	"toyvm/token"
+	"unicode"
+	"unicode/utf8"
+)
 
 type Lexer struct {
 	input        string
-	position     int  // 当前字符的位置
+	position     int  // 当前字符的位置（字节的位置）
 	readPosition int  // 输入字符串的读取位置（即当前字符的下一个字符的位置）
-	ch           byte // 当前字符（只支持 ascii）
+	ch           rune // 当前字符（按 UTF-8 解码，支持 Unicode）
+	line         int  // 当前字符所在的行（从 1 开始）
+	column       int  // 当前字符所在的列（从 1 开始，以字符而不是字节计算）
 }
 
 func New(input string) *Lexer {
-	lx := &Lexer{input: input}
+	lx := &Lexer{input: input, line: 1}
 	lx.readChar()
 	return lx
 }
 
 func (lx *Lexer) readChar() {
+	width := 1 // 当前字符所占的字节数
 	if lx.readPosition >= len(lx.input) {
 		lx.ch = 0
 	} else {
-		lx.ch = lx.input[lx.readPosition]
+		// 无效的 UTF-8 字节被解码为 utf8.RuneError，宽度为 1
+		lx.ch, width = utf8.DecodeRuneInString(lx.input[lx.readPosition:])
+	}
+
+	// 上一个字符是换行符时，当前字符位于新的一行
+	if lx.position < len(lx.input) && lx.readPosition > 0 && lx.input[lx.position] == '\n' {
+		lx.line += 1
+		lx.column = 1
+	} else {
+		lx.column += 1
 	}
 
 	// 移动光标到下一个字符
 	lx.position = lx.readPosition
-	lx.readPosition += 1
+	lx.readPosition += width
 }
 
 func (lx *Lexer) NextToken() token.Token {
@@ -36,12 +53,19 @@ func (lx *Lexer) NextToken() token.Token {
 		//
 	}
 
+	// 记录 token 第一个字符的位置
+	line, column := lx.line, lx.column
+
 	switch lx.ch {
 	case '=':
 		if lx.peekChar() == '=' {
 			lx.readChar() // 消耗下一个字符
 			tk = token.Token{Type: token.EQ, Literal: "=="}
 
+		} else if lx.peekChar() == '>' {
+			lx.readChar() // 消耗下一个字符
+			tk = token.Token{Type: token.ARROW, Literal: "=>"}
+
 		} else {
 			tk = newToken(token.ASSIGN, lx.ch)
 		}
@@ -56,13 +80,15 @@ func (lx *Lexer) NextToken() token.Token {
 		}
 
 	case '+':
-		tk = newToken(token.PLUS, lx.ch)
+		tk = lx.newOperatorToken(token.PLUS, token.PLUS_ASSIGN)
 	case '-':
-		tk = newToken(token.MINUS, lx.ch)
+		tk = lx.newOperatorToken(token.MINUS, token.MINUS_ASSIGN)
 	case '/':
-		tk = newToken(token.SLASH, lx.ch)
+		tk = lx.newOperatorToken(token.SLASH, token.SLASH_ASSIGN)
 	case '*':
-		tk = newToken(token.ASTERISK, lx.ch)
+		tk = lx.newOperatorToken(token.ASTERISK, token.ASTERISK_ASSIGN)
+	case '%':
+		tk = newToken(token.PERCENT, lx.ch)
 
 	case '<':
 		tk = newToken(token.LT, lx.ch)
@@ -75,6 +101,8 @@ func (lx *Lexer) NextToken() token.Token {
 		tk = newToken(token.COMMA, lx.ch)
 	case ':':
 		tk = newToken(token.COLON, lx.ch)
+	case '?':
+		tk = newToken(token.QUESTION, lx.ch)
 
 	case '(':
 		tk = newToken(token.LPAREN, lx.ch)
@@ -108,8 +136,22 @@ func (lx *Lexer) NextToken() token.Token {
 		}
 
 	case '"':
-		tk.Type = token.STRING
-		tk.Literal = lx.readString()
+		str, ok := lx.readString()
+		if ok {
+			tk = token.Token{Type: token.STRING, Literal: str}
+		} else {
+			// 直到输入结束都未遇到结尾的双引号
+			tk = token.Token{Type: token.ILLEGAL, Literal: "unterminated string"}
+		}
+
+	case '`':
+		str, ok := lx.readRawString()
+		if ok {
+			tk = token.Token{Type: token.STRING, Literal: str}
+		} else {
+			// 直到输入结束都未遇到结尾的反引号
+			tk = token.Token{Type: token.ILLEGAL, Literal: "unterminated raw string"}
+		}
 
 	case 0:
 		// 到达文件末尾。
@@ -121,13 +163,13 @@ func (lx *Lexer) NextToken() token.Token {
 		if isAlphabet(lx.ch) {
 			s := lx.readIdentifier()
 
-			tk = token.Token{Type: token.LookupTokenType(s), Literal: s}
+			tk = token.Token{Type: token.LookupTokenType(s), Literal: s, Line: line, Column: column}
 			return tk // 跳过后面的语句，因为 readIdentifier() 已经读了下一个字符
 
 		} else if isDigit(lx.ch) {
 			s := lx.readNumber()
 
-			tk = token.Token{Type: token.INT, Literal: s}
+			tk = token.Token{Type: token.INT, Literal: s, Line: line, Column: column}
 			return tk // 跳过后面的语句，因为 readNumber() 已经读了下一个字符
 
 		} else {
@@ -135,19 +177,22 @@ func (lx *Lexer) NextToken() token.Token {
 		}
 	}
 
+	tk.Line, tk.Column = line, column
+
 	lx.readChar() // 读下一个字符
 	return tk
 }
 
-func (lx *Lexer) peekChar() byte {
+func (lx *Lexer) peekChar() rune {
 	if lx.readPosition >= len(lx.input) {
 		return 0
 	} else {
-		return lx.input[lx.readPosition]
+		ch, _ := utf8.DecodeRuneInString(lx.input[lx.readPosition:])
+		return ch
 	}
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{
 		Type:    tokenType,
 		Literal: string(ch),
@@ -184,39 +229,137 @@ func (lx *Lexer) readIdentifier() string {
 	return lx.input[startPosition:lx.position]
 }
 
-func (lx *Lexer) readNumber() string { // 以字符串的形式返回数字
+// 以字符串的形式返回数字
+// 支持十进制数字以及以 0x/0b/0o 开头的十六进制、二进制和八进制数字，
+// 数字之间可以使用 "_" 分隔，比如 1_000_000（在语法分析时会被去除）
+func (lx *Lexer) readNumber() string {
 	startPosition := lx.position
-	for isDigit(lx.ch) {
+
+	isDigitOf := isDigit
+	if lx.ch == '0' {
+		prefixed := true
+		switch lx.peekChar() {
+		case 'x', 'X':
+			isDigitOf = isHexDigit
+		case 'b', 'B':
+			isDigitOf = isBinaryDigit
+		case 'o', 'O':
+			isDigitOf = isOctalDigit
+		default:
+			prefixed = false
+		}
+
+		if prefixed {
+			lx.readChar() // 跳过 "0"
+			lx.readChar() // 跳过 "x"/"b"/"o"
+		}
+	}
+
+	for isDigitOf(lx.ch) || lx.ch == '_' {
 		lx.readChar() // 读下一个字符
 	}
 
+	// 紧接着的其他字母或者数字（比如 0b12 当中的 2）也视为数字的一部分，
+	// 以便在语法分析时报告错误，而不是被拆分为两个 token
+	for isLetter(lx.ch) {
+		lx.readChar()
+	}
+
 	// 返回从 startPosition 到 lx.position 之间的字符
 	return lx.input[startPosition:lx.position]
 }
 
-func (lx *Lexer) readString() string { // 返回的字符串值不包含前后双引号
-	startPosition := lx.position
+// 返回的字符串值不包含前后双引号，并且已经处理了转义字符
+// 支持的转义字符有 \n, \t, \r, \" 以及 \\，其余的（不支持的）转义序列则原样保留
+// 如果直到输入结束都未遇到结尾的双引号，则第二个返回值为 false
+func (lx *Lexer) readString() (string, bool) {
+	var out strings.Builder
 	for {
 		lx.readChar() // 读下一个字符
-		if lx.ch == '"' || lx.ch == 0 {
+		if lx.ch == '"' {
 			break
 		}
+		if lx.ch == 0 {
+			return out.String(), false
+		}
+
+		if lx.ch == '\\' && lx.peekChar() != 0 {
+			lx.readChar()
+			switch lx.ch {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			case '"', '\\':
+				out.WriteRune(lx.ch)
+			default:
+				out.WriteByte('\\')
+				out.WriteRune(lx.ch)
+			}
+			continue
+		}
+
+		out.WriteRune(lx.ch)
 	}
 
-	// 返回从 startPosition + 1 到 lx.position 之间的字符
-	return lx.input[startPosition+1 : lx.position]
+	return out.String(), true
 }
 
-func isAlphabet(ch byte) bool {
+// 读取以反引号包围的原始字符串，返回的字符串值不包含前后反引号，
+// 原始字符串不处理转义字符，并且可以包含换行符
+// 如果直到输入结束都未遇到结尾的反引号，则第二个返回值为 false
+func (lx *Lexer) readRawString() (string, bool) {
+	startPosition := lx.position + 1
+	for {
+		lx.readChar()
+		if lx.ch == '`' {
+			break
+		}
+		if lx.ch == 0 {
+			return lx.input[startPosition:lx.position], false
+		}
+	}
+
+	return lx.input[startPosition:lx.position], true
+}
+
+// 如果当前字符后面紧接着 "="，则生成复合赋值运算符（比如 "+="）的 token，
+// 否则生成单个字符的运算符 token
+func (lx *Lexer) newOperatorToken(single token.TokenType, assign token.TokenType) token.Token {
+	if lx.peekChar() == '=' {
+		ch := lx.ch
+		lx.readChar() // 消耗 "="
+		return token.Token{Type: assign, Literal: string(ch) + "="}
+	}
+	return newToken(single, lx.ch)
+}
+
+// 标识符可以包含 Unicode 字母（比如 "π" 和 "变量"），但不能包含 emoji 等符号
+func isAlphabet(ch rune) bool {
 	return ch >= 'a' && ch <= 'z' ||
 		ch >= 'A' && ch <= 'Z' ||
-		ch == '_'
+		ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return ch >= '0' && ch <= '9'
 }
 
-func isLetter(ch byte) bool {
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || ch >= 'a' && ch <= 'f' || ch >= 'A' && ch <= 'F'
+}
+
+func isBinaryDigit(ch rune) bool {
+	return ch == '0' || ch == '1'
+}
+
+func isOctalDigit(ch rune) bool {
+	return ch >= '0' && ch <= '7'
+}
+
+func isLetter(ch rune) bool {
 	return isAlphabet(ch) || isDigit(ch)
 }
@@ -236,3 +236,307 @@ func TestNextToken6(t *testing.T) {
 		}
 	}
 }
+
+func TestAssignOperators(t *testing.T) {
+	input := `x = 1; x += 2; x -= 3; x *= 4; x /= 5; x + -1`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "3"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "4"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "5"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.PLUS, "+"},
+		{token.MINUS, "-"},
+		{token.INT, "1"},
+		{token.EOF, ""},
+	}
+
+	lx := New(input)
+
+	for i, test := range tests {
+		tk := lx.NextToken()
+
+		if tk.Type != test.expectedType {
+			t.Fatalf("tests [%d] - token type wrong. expected %q, actual %q",
+				i, test.expectedType, tk.Type)
+		}
+
+		if tk.Literal != test.expectedLiteral {
+			t.Fatalf("tests [%d] - token value wrong. expected %q, actual %q",
+				i, test.expectedLiteral, tk.Literal)
+		}
+	}
+}
+
+func TestArrowToken(t *testing.T) {
+	input := `match x { 1 => a, _ => b }`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.MATCH, "match"},
+		{token.IDENT, "x"},
+		{token.LBRACE, "{"},
+		{token.INT, "1"},
+		{token.ARROW, "=>"},
+		{token.IDENT, "a"},
+		{token.COMMA, ","},
+		{token.IDENT, "_"},
+		{token.ARROW, "=>"},
+		{token.IDENT, "b"},
+		{token.RBRACE, "}"},
+		{token.EOF, ""},
+	}
+
+	lx := New(input)
+
+	for i, test := range tests {
+		tk := lx.NextToken()
+
+		if tk.Type != test.expectedType {
+			t.Fatalf("tests [%d] - token type wrong. expected %q, actual %q",
+				i, test.expectedType, tk.Type)
+		}
+
+		if tk.Literal != test.expectedLiteral {
+			t.Fatalf("tests [%d] - token value wrong. expected %q, actual %q",
+				i, test.expectedLiteral, tk.Literal)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	input := `
+	"a\tb"
+	"line1\nline2\r"
+	"say \"hi\""
+	"back\\slash"
+	"unknown \q"
+	`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING, "a\tb"},
+		{token.STRING, "line1\nline2\r"},
+		{token.STRING, "say \"hi\""},
+		{token.STRING, "back\\slash"},
+		{token.STRING, "unknown \\q"},
+		{token.EOF, ""},
+	}
+
+	lx := New(input)
+
+	for i, test := range tests {
+		tk := lx.NextToken()
+
+		if tk.Type != test.expectedType {
+			t.Fatalf("tests [%d] - token type wrong. expected %q, actual %q",
+				i, test.expectedType, tk.Type)
+		}
+
+		if tk.Literal != test.expectedLiteral {
+			t.Fatalf("tests [%d] - token value wrong. expected %q, actual %q",
+				i, test.expectedLiteral, tk.Literal)
+		}
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	input := `let s = "unterminated`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "s"},
+		{token.ASSIGN, "="},
+		{token.ILLEGAL, "unterminated string"},
+		{token.EOF, ""},
+	}
+
+	lx := New(input)
+
+	for i, test := range tests {
+		tk := lx.NextToken()
+
+		if tk.Type != test.expectedType {
+			t.Fatalf("tests [%d] - token type wrong. expected %q, actual %q",
+				i, test.expectedType, tk.Type)
+		}
+
+		if tk.Literal != test.expectedLiteral {
+			t.Fatalf("tests [%d] - token value wrong. expected %q, actual %q",
+				i, test.expectedLiteral, tk.Literal)
+		}
+	}
+}
+
+func TestRawStrings(t *testing.T) {
+	input := "`{\"name\": \"foo\",\n  \"path\": \"C:\\dir\\n\"}`\n" +
+		"``\n" +
+		"let s = `unterminated\nraw"
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING, "{\"name\": \"foo\",\n  \"path\": \"C:\\dir\\n\"}"},
+		{token.STRING, ""},
+		{token.LET, "let"},
+		{token.IDENT, "s"},
+		{token.ASSIGN, "="},
+		{token.ILLEGAL, "unterminated raw string"},
+		{token.EOF, ""},
+	}
+
+	lx := New(input)
+
+	for i, test := range tests {
+		tk := lx.NextToken()
+
+		if tk.Type != test.expectedType {
+			t.Fatalf("tests [%d] - token type wrong. expected %q, actual %q",
+				i, test.expectedType, tk.Type)
+		}
+
+		if tk.Literal != test.expectedLiteral {
+			t.Fatalf("tests [%d] - token value wrong. expected %q, actual %q",
+				i, test.expectedLiteral, tk.Literal)
+		}
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	input := `0xFF 0Xab 0b1010 0o17 1_000 0x_FF_FF 0b12 42;`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "0xFF"},
+		{token.INT, "0Xab"},
+		{token.INT, "0b1010"},
+		{token.INT, "0o17"},
+		{token.INT, "1_000"},
+		{token.INT, "0x_FF_FF"},
+		{token.INT, "0b12"}, // 无效的数字，在语法分析时报告错误
+		{token.INT, "42"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	lx := New(input)
+
+	for i, test := range tests {
+		tk := lx.NextToken()
+
+		if tk.Type != test.expectedType {
+			t.Fatalf("tests [%d] - token type wrong. expected %q, actual %q",
+				i, test.expectedType, tk.Type)
+		}
+
+		if tk.Literal != test.expectedLiteral {
+			t.Fatalf("tests [%d] - token value wrong. expected %q, actual %q",
+				i, test.expectedLiteral, tk.Literal)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let π = \"héllo 😀\"; 变量_1 + π; 😀"
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedColumn  int
+	}{
+		{token.LET, "let", 1},
+		{token.IDENT, "π", 5},
+		{token.ASSIGN, "=", 7},
+		{token.STRING, "héllo 😀", 9},
+		{token.SEMICOLON, ";", 18},
+		{token.IDENT, "变量_1", 20},
+		{token.PLUS, "+", 25},
+		{token.IDENT, "π", 27},
+		{token.SEMICOLON, ";", 28},
+		{token.ILLEGAL, "😀", 30}, // emoji 不能作为标识符
+		{token.EOF, "", 31},
+	}
+
+	lx := New(input)
+
+	for i, test := range tests {
+		tk := lx.NextToken()
+
+		if tk.Type != test.expectedType {
+			t.Fatalf("tests [%d] - token type wrong. expected %q, actual %q",
+				i, test.expectedType, tk.Type)
+		}
+
+		if tk.Literal != test.expectedLiteral {
+			t.Fatalf("tests [%d] - token value wrong. expected %q, actual %q",
+				i, test.expectedLiteral, tk.Literal)
+		}
+
+		if tk.Column != test.expectedColumn {
+			t.Fatalf("tests [%d] - column wrong. expected %d, actual %d",
+				i, test.expectedColumn, tk.Column)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  x += `a\nb`;\n\"s\" // c\n10"
+
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"5", 1, 9},
+		{";", 1, 10},
+		{"x", 2, 3},
+		{"+=", 2, 5},
+		{"a\nb", 2, 8},
+		{";", 3, 3},
+		{"s", 4, 1},
+		{"10", 5, 1},
+		{"", 5, 3},
+	}
+
+	lx := New(input)
+
+	for i, test := range tests {
+		tk := lx.NextToken()
+
+		if tk.Literal != test.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong literal, expected %q, actual %q",
+				i, test.expectedLiteral, tk.Literal)
+		}
+
+		if tk.Line != test.expectedLine || tk.Column != test.expectedColumn {
+			t.Errorf("tests[%d] - wrong position of %q, expected %d:%d, actual %d:%d",
+				i, tk.Literal, test.expectedLine, test.expectedColumn, tk.Line, tk.Column)
+		}
+	}
+}
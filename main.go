@@ -11,19 +11,53 @@ func main() {
 	args := os.Args
 	count := len(args)
 
+	// REPL 输出到终端时使用颜色，设置了环境变量 NO_COLOR 时不使用
+	repl.SetColor(repl.ColorSupported(os.Stdout))
+
 	if count == 1 {
 		// 进入 REPL 交互模式
 		fmt.Println("Toy VM REPL")
 		repl.Start(os.Stdin, os.Stdout)
 
+	} else if count == 3 && args[1] == "-r" {
+		// 进入 REPL 交互模式，并加载/保存会话
+		fmt.Println("Toy VM REPL")
+		repl.StartWithSession(os.Stdin, os.Stdout, args[2])
+
 	} else if count == 2 {
 		// 编译及执行脚本
 		executor.Exec(args[1])
 
+	} else if count == 3 && args[2] == "-t" {
+		// 编译及执行脚本，并输出各个阶段所花费的时间
+		executor.ExecWithTimings(args[1])
+
+	} else if count == 3 && args[2] == "-p" {
+		// 编译及执行脚本，并输出每种指令的执行次数
+		executor.ExecWithProfiling(args[1])
+
+	} else if count == 3 && args[2] == "-d" {
+		// 编译脚本并进入调试模式
+		executor.Debug(args[1])
+
 	} else if count == 3 && args[2] == "-s" {
 		// 编译及打印汇编文本
 		executor.Assembly(args[1])
 
+	} else if count == 4 && args[2] == "-s" && args[3] == "-v" {
+		// 编译及打印汇编文本，并在指令前面插入对应的源码行
+		executor.AssemblyWithSource(args[1])
+
+	} else if count == 3 && args[2] == "-c" {
+		// 解析及编译脚本（而不执行），检查失败时以非零状态退出
+		if !executor.Check(args[1]) {
+			os.Exit(1)
+		}
+
+	} else if count == 3 && args[2] == "-a" {
+		// 解析及打印语法树
+		executor.PrintAST(args[1])
+
 	} else {
 		fmt.Println(`Toy VM interpreter
 Usage:
@@ -31,10 +65,31 @@ Usage:
 1. Launch REPL mode
 $ go run .
 
-2. Compile and execute toy lang script source code file
+2. Launch REPL mode, load the session from the file and save it on ":quit"
+$ go run . -r path_to_session_file
+
+3. Compile and execute toy lang script source code file
 $ go run . path_to_script_file
 
-3. Compile and print the assembly text
-$ go run . path_to_script_file -s`)
+4. Compile and execute the script, and print the time spent in each stage
+$ go run . path_to_script_file -t
+
+5. Compile and execute the script, and print how many times each opcode was executed
+$ go run . path_to_script_file -p
+
+6. Debug the script interactively (step, continue, break, stack, locals, disasm)
+$ go run . path_to_script_file -d
+
+7. Compile and print the assembly text
+$ go run . path_to_script_file -s
+
+8. Compile and print the assembly text of the script and its functions, annotated with the source lines
+$ go run . path_to_script_file -s -v
+
+9. Parse and print the syntax tree
+$ go run . path_to_script_file -a
+
+10. Check the script for parser and compiler errors (and warn about unused bindings) without executing it
+$ go run . path_to_script_file -c`)
 	}
 }
@@ -1,12 +1,47 @@
 package object
 
-import "fmt"
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 输出类内置函数（比如 puts）的输出目标，默认为标准输出
+var output io.Writer = os.Stdout
+
+// 输入类内置函数（比如 input）的输入来源，默认为标准输入
+var input = bufio.NewReader(os.Stdin)
+
+// 设置输出类内置函数的输出目标，比如 REPL 的输出，或者测试时用于捕捉输出的 buffer
+func SetOutput(w io.Writer) {
+	output = w
+}
+
+// 设置输入类内置函数的输入来源，比如 REPL 的输入，或者测试时用于提供输入内容的 Reader
+// 注：
+// 如果 r 已经是 *bufio.Reader 则直接使用（bufio.NewReader 不会再包装一层），
+// 所以调用者可以跟内置函数共用同一个 Reader 而不会互相抢读缓存的内容
+func SetInput(r io.Reader) {
+	input = bufio.NewReader(r)
+}
 
 var Builtins = []struct {
 	Name    string
 	Builtin *Builtin
 }{
 	{
+		// len(value)
+		// 字符串的长度是字符（Unicode 码点，即 rune）的数量而不是字节数，
+		// 跟字符串的索引操作一致
 		"len",
 		&Builtin{Fn: func(args ...Object) Object {
 			if len(args) != 1 {
@@ -15,9 +50,11 @@ var Builtins = []struct {
 			}
 			switch arg := args[0].(type) {
 			case *Array:
-				return &Integer{Value: int64(len(arg.Elements))}
+				return NewInteger(int64(len(arg.Elements)))
 			case *String:
-				return &Integer{Value: int64(len(arg.Value))}
+				return NewInteger(int64(utf8.RuneCountInString(arg.Value)))
+			case *Hash:
+				return NewInteger(int64(len(arg.Pairs)))
 			default:
 				return newError("argument type to `len` not supported, actual %s",
 					args[0].Type())
@@ -29,7 +66,7 @@ var Builtins = []struct {
 		"puts",
 		&Builtin{Fn: func(args ...Object) Object {
 			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+				fmt.Fprintln(output, arg.Inspect())
 			}
 			return nil
 		},
@@ -117,12 +154,843 @@ var Builtins = []struct {
 		},
 		},
 	},
+	{
+		// iterate(fn, initial, n)
+		// 以 initial 为初始值，重复调用函数 fn n 次，每次调用的结果作为下一次调用的实参，
+		// 返回最后一次调用的结果。
+		"iterate",
+		&Builtin{CallbackFn: func(call CallFunction, args ...Object) Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					3, len(args))
+			}
+			if args[0].Type() != CLOSURE_OBJ && args[0].Type() != BUILTIN_OBJ {
+				return newError("argument type to `iterate` must be FUNCTION, actual %s",
+					args[0].Type())
+			}
+			if args[2].Type() != INTEGER_OBJ {
+				return newError("argument type to `iterate` must be INTEGER, actual %s",
+					args[2].Type())
+			}
+
+			n := args[2].(*Integer).Value
+			if n < 0 {
+				return newError("argument to `iterate` must be non-negative, actual %d", n)
+			}
+
+			result := args[1]
+			for i := int64(0); i < n; i++ {
+				value, err := call(args[0], result)
+				if err != nil {
+					return callError(err)
+				}
+				result = value
+			}
+			return result
+		},
+		},
+	},
+	{
+		// sort(array)
+		// sort(array, less)
+		// 返回一个新的升序排列的数组。
+		// 只有一个参数时，数组的元素必须全部是 Integer 或者全部是 String；
+		// 第二个参数是比较函数 less(a, b)，当 a 应该排在 b 前面时返回 true，
+		// 此时数组的元素可以是任意类型。
+		"sort",
+		&Builtin{CallbackFn: func(call CallFunction, args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments, expected %d or %d, actual %d",
+					1, 2, len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument type to `sort` must be ARRAY, actual %s",
+					args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			newElements := make([]Object, len(arr.Elements))
+			copy(newElements, arr.Elements)
+
+			if len(args) == 1 {
+				if err := checkOrderedElements("sort", arr.Elements); err != nil {
+					return err
+				}
+
+				sort.SliceStable(newElements, func(i, j int) bool {
+					return compareOrdered(newElements[i], newElements[j]) < 0
+				})
+				return &Array{Elements: newElements}
+			}
+
+			if args[1].Type() != CLOSURE_OBJ && args[1].Type() != BUILTIN_OBJ {
+				return newError("argument type to `sort` must be FUNCTION, actual %s",
+					args[1].Type())
+			}
+
+			// 比较函数出错之后不再调用，排序结束之后返回第一个错误
+			var callErr *Error
+			sort.SliceStable(newElements, func(i, j int) bool {
+				if callErr != nil {
+					return false
+				}
+
+				result, err := call(args[1], newElements[i], newElements[j])
+				if err != nil {
+					callErr = callError(err)
+					return false
+				}
+
+				less, ok := result.(*Boolean)
+				if !ok {
+					callErr = newError("comparison function of `sort` must return BOOLEAN, actual %s",
+						result.Type())
+					return false
+				}
+				return less.Value
+			})
+
+			if callErr != nil {
+				return callErr
+			}
+			return &Array{Elements: newElements}
+		},
+		},
+	},
+	{
+		// maxOf(array)
+		// 返回数组中最大的元素，数组为空时返回 null
+		"maxOf",
+		&Builtin{Fn: func(args ...Object) Object {
+			return extremumOf("maxOf", 1, args)
+		},
+		},
+	},
+	{
+		// minOf(array)
+		// 返回数组中最小的元素，数组为空时返回 null
+		"minOf",
+		&Builtin{Fn: func(args ...Object) Object {
+			return extremumOf("minOf", -1, args)
+		},
+		},
+	},
+	{
+		// slice(array, start, end)
+		// slice(string, start, end)
+		// 返回从 start（包括）到 end（不包括）之间的元素或者字符，
+		// start 和 end 超出范围时会被限制在 0 到长度之间，start >= end 时返回空数组或者空字符串
+		"slice",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					3, len(args))
+			}
+			if args[1].Type() != INTEGER_OBJ || args[2].Type() != INTEGER_OBJ {
+				return newError("argument type to `slice` must be INTEGER, actual %s and %s",
+					args[1].Type(), args[2].Type())
+			}
+
+			start := args[1].(*Integer).Value
+			end := args[2].(*Integer).Value
+
+			switch arg := args[0].(type) {
+			case *Array:
+				start, end := clampRange(start, end, len(arg.Elements))
+				newElements := make([]Object, end-start)
+				copy(newElements, arg.Elements[start:end])
+				return &Array{Elements: newElements}
+			case *String:
+				// 以字符（rune）而不是字节计算位置，跟 len 一致
+				runes := []rune(arg.Value)
+				start, end := clampRange(start, end, len(runes))
+				return &String{Value: string(runes[start:end])}
+			default:
+				return newError("argument type to `slice` must be ARRAY or STRING, actual %s",
+					args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		// reverse(array)
+		// reverse(string)
+		// 返回一个新的倒序排列的数组或者字符串
+		"reverse",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Array:
+				length := len(arg.Elements)
+				newElements := make([]Object, length)
+				for i, element := range arg.Elements {
+					newElements[length-1-i] = element
+				}
+				return &Array{Elements: newElements}
+			case *String:
+				// 按字符（rune）倒序，以免拆开多字节的字符
+				runes := []rune(arg.Value)
+				length := len(runes)
+				for i := 0; i < length/2; i++ {
+					runes[i], runes[length-1-i] = runes[length-1-i], runes[i]
+				}
+				return &String{Value: string(runes)}
+			default:
+				return newError("argument type to `reverse` must be ARRAY or STRING, actual %s",
+					args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		// range(n)
+		// range(start, end)
+		// 返回从 start（包括，默认为 0）到 end（不包括）的整数数组，start >= end 时返回空数组
+		"range",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments, expected %d or %d, actual %d",
+					1, 2, len(args))
+			}
+			for _, arg := range args {
+				if arg.Type() != INTEGER_OBJ {
+					return newError("argument type to `range` must be INTEGER, actual %s",
+						arg.Type())
+				}
+			}
+
+			start, end := int64(0), args[0].(*Integer).Value
+			if len(args) == 2 {
+				start, end = args[0].(*Integer).Value, args[1].(*Integer).Value
+			}
+
+			elements := []Object{}
+			for i := start; i < end; i++ {
+				elements = append(elements, NewInteger(i))
+			}
+			return &Array{Elements: elements}
+		},
+		},
+	},
+	{
+		// assert(cond)
+		// assert(cond, message)
+		// cond 为 false 或者 null 时中止程序的执行，否则返回 null
+		"assert",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments, expected %d or %d, actual %d",
+					1, 2, len(args))
+			}
+
+			switch cond := args[0].(type) {
+			case *Boolean:
+				if cond.Value {
+					return nil
+				}
+			case *Null:
+			default:
+				return nil
+			}
+
+			if len(args) == 2 {
+				return newFatalError("assertion failed: %s", messageOf(args[1]))
+			}
+			return newFatalError("assertion failed")
+		},
+		},
+	},
+	{
+		// panic(message)
+		// 以指定的信息中止程序的执行
+		"panic",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+			return newFatalError("%s", messageOf(args[0]))
+		},
+		},
+	},
+	{
+		// input()
+		// input(prompt)
+		// 从输入来源读取一行（不包括行尾的换行符），如果有 prompt 则先输出 prompt，
+		// 遇到输入结束（EOF）时返回 null
+		"input",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments, expected %d or %d, actual %d",
+					0, 1, len(args))
+			}
+			if len(args) == 1 {
+				prompt, ok := args[0].(*String)
+				if !ok {
+					return newError("argument type to `input` must be STRING, actual %s",
+						args[0].Type())
+				}
+				fmt.Fprint(output, prompt.Value)
+			}
+
+			line, err := input.ReadString('\n')
+			if err != nil && line == "" {
+				// 输入已经结束
+				return nil
+			}
+
+			// 遇到 EOF 之前读到的内容（没有换行符）仍然作为最后一行返回
+			return &String{Value: strings.TrimRight(line, "\r\n")}
+		},
+		},
+	},
+	{
+		// copy(value)
+		// 返回 Array 或者 Hash 的深拷贝（嵌套的 Array 和 Hash 也会被复制），其他类型的值原样返回
+		"copy",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+			return deepCopy(args[0], make(map[Object]Object))
+		},
+		},
+	},
+	{
+		// abs(n)
+		// 返回整数或者浮点数的绝对值
+		"abs",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Integer:
+				// 最小的整数没有对应的正数
+				if arg.Value == math.MinInt64 {
+					return newError("integer overflow: abs(%d)", arg.Value)
+				}
+				if arg.Value < 0 {
+					return NewInteger(-arg.Value)
+				}
+				return arg
+			case *Float:
+				return &Float{Value: math.Abs(arg.Value)}
+			default:
+				return newError("argument type to `abs` must be INTEGER or FLOAT, actual %s",
+					args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		// min(a, b, ...)
+		// 返回两个或者更多个整数当中最小的一个
+		"min",
+		&Builtin{Fn: func(args ...Object) Object {
+			return extremumOfIntegers("min", -1, args)
+		},
+		},
+	},
+	{
+		// max(a, b, ...)
+		// 返回两个或者更多个整数当中最大的一个
+		"max",
+		&Builtin{Fn: func(args ...Object) Object {
+			return extremumOfIntegers("max", 1, args)
+		},
+		},
+	},
+	{
+		// pow(base, exp)
+		// 两个参数都是整数时返回整数（exp 必须为非负数），否则返回浮点数
+		// 注：
+		// 整数结果溢出时跟加法、乘法一样按 int64 回绕（wrap around），不会报错
+		"pow",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					2, len(args))
+			}
+
+			base, baseIsInteger := args[0].(*Integer)
+			exp, expIsInteger := args[1].(*Integer)
+			if baseIsInteger && expIsInteger {
+				if exp.Value < 0 {
+					return newError("exponent to `pow` must be non-negative for INTEGER, actual %d",
+						exp.Value)
+				}
+				return NewInteger(powInteger(base.Value, exp.Value))
+			}
+
+			baseValue, ok := floatValueOf(args[0])
+			if !ok {
+				return newError("argument type to `pow` must be INTEGER or FLOAT, actual %s",
+					args[0].Type())
+			}
+			expValue, ok := floatValueOf(args[1])
+			if !ok {
+				return newError("argument type to `pow` must be INTEGER or FLOAT, actual %s",
+					args[1].Type())
+			}
+			return &Float{Value: math.Pow(baseValue, expValue)}
+		},
+		},
+	},
+	{
+		// sqrt(n)
+		// 返回整数或者浮点数的平方根（浮点数），n 不能为负数
+		"sqrt",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+
+			value, ok := floatValueOf(args[0])
+			if !ok {
+				return newError("argument type to `sqrt` must be INTEGER or FLOAT, actual %s",
+					args[0].Type())
+			}
+			if value < 0 {
+				return newError("argument to `sqrt` must be non-negative, actual %s",
+					args[0].Inspect())
+			}
+			return &Float{Value: math.Sqrt(value)}
+		},
+		},
+	},
+	{
+		// append(arr, value)
+		// 把 value 添加到数组 arr 的末尾，并返回 arr 本身。
+		// 跟 push 不同，append 直接修改原数组（不复制），多次添加的均摊开销为 O(1)，
+		// 所以适合在循环里构建大数组。
+		"append",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					2, len(args))
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return newError("argument type to `append` must be ARRAY, actual %s",
+					args[0].Type())
+			}
+			arr.Elements = append(arr.Elements, args[1])
+			return arr
+		},
+		},
+	},
+	{
+		// concat(a, b, ...)
+		// 按顺序连接两个或者更多数组，返回一个新的数组（原数组不变）
+		"concat",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) < 2 {
+				return newError("wrong number of arguments, expected at least %d, actual %d",
+					2, len(args))
+			}
+
+			length := 0
+			for _, arg := range args {
+				arr, ok := arg.(*Array)
+				if !ok {
+					return newError("argument type to `concat` must be ARRAY, actual %s",
+						arg.Type())
+				}
+				length += len(arr.Elements)
+			}
+
+			elements := make([]Object, 0, length)
+			for _, arg := range args {
+				elements = append(elements, arg.(*Array).Elements...)
+			}
+			return &Array{Elements: elements}
+		},
+		},
+	},
+	{
+		// at(arr, index)
+		// 返回数组 arr 位于 index 的元素，跟索引表达式 `arr[index]` 不同，
+		// 索引为负数或者超出范围时返回错误而不是 null（开启严格模式时会中止执行）
+		"at",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					2, len(args))
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return newError("argument type to `at` must be ARRAY, actual %s",
+					args[0].Type())
+			}
+			index, ok := args[1].(*Integer)
+			if !ok {
+				return newError("index type to `at` must be INTEGER, actual %s",
+					args[1].Type())
+			}
+
+			length := int64(len(arr.Elements))
+			if index.Value < 0 || index.Value >= length {
+				return newError("index out of range: %d, length %d", index.Value, length)
+			}
+			return arr.Elements[index.Value]
+		},
+		},
+	},
+	{
+		// exit(value)
+		// 结束整个程序，value（可省略）作为程序的结果。
+		// 直接调用时由编译器转换为 OpHalt 指令，通过变量间接调用时由 VM 结束程序，
+		// 这里的实现只用于报告参数个数的错误
+		"exit",
+		&Builtin{Fn: func(args ...Object) Object {
+			return newError("wrong number of arguments, expected 0 or 1, actual %d", len(args))
+		},
+		},
+	},
+	{
+		// keys(hash)
+		// 按照插入顺序返回 Hash 的所有键
+		"keys",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return newError("argument type to `keys` must be HASH, actual %s",
+					args[0].Type())
+			}
+
+			pairs := hash.OrderedPairs()
+			elements := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				elements[i] = pair.Key
+			}
+			return &Array{Elements: elements}
+		},
+		},
+	},
+	{
+		// json(value)
+		// 返回 value 的 JSON 文本，支持 Integer, Float, String, Boolean, Null, Array 以及
+		// 键为 String 的 Hash（按照插入顺序输出键值对）
+		"json",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+
+			var out bytes.Buffer
+			err := encodeJSON(&out, args[0], make(map[Object]bool))
+			if err != nil {
+				return err
+			}
+			return &String{Value: out.String()}
+		},
+		},
+	},
+	{
+		// parseJson(str)
+		// 把 JSON 文本转换为对象，对象（object）转换为 Hash（保持键的顺序），
+		// 整数值的数字转换为 Integer，其他数字转换为 Float
+		"parseJson",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+			str, ok := args[0].(*String)
+			if !ok {
+				return newError("argument type to `parseJson` must be STRING, actual %s",
+					args[0].Type())
+			}
+
+			decoder := json.NewDecoder(strings.NewReader(str.Value))
+			decoder.UseNumber()
+
+			result, err := decodeJSON(decoder)
+			if err != nil {
+				return newError("invalid JSON: %s", err)
+			}
+
+			// 检查 JSON 值后面是否还有其他内容
+			if _, err := decoder.Token(); err != io.EOF {
+				return newError("invalid JSON: unexpected data after top-level value")
+			}
+			return result
+		},
+		},
+	},
+	{
+		// env(name, defaultValue)
+		// 返回环境变量 name 的值，环境变量不存在时返回 defaultValue（可省略，默认为 null）
+		"env",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments, expected %d or %d, actual %d",
+					1, 2, len(args))
+			}
+			name, ok := args[0].(*String)
+			if !ok {
+				return newError("argument type to `env` must be STRING, actual %s",
+					args[0].Type())
+			}
+
+			value, ok := os.LookupEnv(name.Value)
+			if ok {
+				return &String{Value: value}
+			}
+			if len(args) == 2 {
+				return args[1]
+			}
+			return nil
+		},
+		},
+	},
+	{
+		// read(path)
+		// 返回文件 path 的内容
+		"read",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+			path, ok := args[0].(*String)
+			if !ok {
+				return newError("argument type to `read` must be STRING, actual %s",
+					args[0].Type())
+			}
+
+			content, err := os.ReadFile(path.Value)
+			if err != nil {
+				return newError("%s", err)
+			}
+			return &String{Value: string(content)}
+		},
+		},
+	},
+	{
+		// write(path, contents)
+		// 把字符串 contents 写入文件 path（文件已存在时覆盖原有的内容），返回 null
+		"write",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					2, len(args))
+			}
+			path, ok := args[0].(*String)
+			if !ok {
+				return newError("argument type to `write` must be STRING, actual %s",
+					args[0].Type())
+			}
+			contents, ok := args[1].(*String)
+			if !ok {
+				return newError("contents type to `write` must be STRING, actual %s",
+					args[1].Type())
+			}
+
+			err := os.WriteFile(path.Value, []byte(contents.Value), 0644)
+			if err != nil {
+				return newError("%s", err)
+			}
+			return nil
+		},
+		},
+	},
+	{
+		// chars(string)
+		// 把字符串按字符（rune）拆分为只包含一个字符的字符串的数组
+		"chars",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+			str, ok := args[0].(*String)
+			if !ok {
+				return newError("argument type to `chars` must be STRING, actual %s",
+					args[0].Type())
+			}
+
+			elements := make([]Object, 0, utf8.RuneCountInString(str.Value))
+			for _, ch := range str.Value {
+				elements = append(elements, &String{Value: string(ch)})
+			}
+			return &Array{Elements: elements}
+		},
+		},
+	},
+	{
+		// ord(string)
+		// 返回只包含一个字符的字符串的 Unicode 码点
+		"ord",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+			str, ok := args[0].(*String)
+			if !ok {
+				return newError("argument type to `ord` must be STRING, actual %s",
+					args[0].Type())
+			}
+
+			count := utf8.RuneCountInString(str.Value)
+			if count != 1 {
+				return newError("argument to `ord` must be a single character, actual length %d",
+					count)
+			}
+			ch, _ := utf8.DecodeRuneInString(str.Value)
+			return NewInteger(int64(ch))
+		},
+		},
+	},
+	{
+		// chr(code)
+		// 返回 Unicode 码点所对应的只包含一个字符的字符串，是 ord 的逆运算
+		"chr",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+			code, ok := args[0].(*Integer)
+			if !ok {
+				return newError("argument type to `chr` must be INTEGER, actual %s",
+					args[0].Type())
+			}
+
+			if code.Value < 0 || code.Value > utf8.MaxRune || !utf8.ValidRune(rune(code.Value)) {
+				return newError("invalid code point for `chr`: %d", code.Value)
+			}
+			return &String{Value: string(rune(code.Value))}
+		},
+		},
+	},
+	{
+		// int(string)
+		// int(string, base)
+		// 把字符串解析为整数，base 可以是 2, 8, 10（默认）或者 16，
+		// 字符串可以以 "+" 或者 "-" 开头，但不能带有 "0x" 等前缀
+		"int",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments, expected %d or %d, actual %d",
+					1, 2, len(args))
+			}
+			str, ok := args[0].(*String)
+			if !ok {
+				return newError("argument type to `int` must be STRING, actual %s",
+					args[0].Type())
+			}
+
+			base := int64(10)
+			if len(args) == 2 {
+				baseArg, ok := args[1].(*Integer)
+				if !ok {
+					return newError("base type to `int` must be INTEGER, actual %s",
+						args[1].Type())
+				}
+				base = baseArg.Value
+			}
+
+			switch base {
+			case 2, 8, 10, 16:
+			default:
+				return newError("invalid base for `int`: %d, expected 2, 8, 10 or 16", base)
+			}
+
+			value, err := strconv.ParseInt(str.Value, int(base), 64)
+			if err != nil {
+				if errors.Is(err, strconv.ErrRange) {
+					return newError("integer out of range for `int`: %q", str.Value)
+				}
+				return newError("invalid integer for `int` with base %d: %q", base, str.Value)
+			}
+			return NewInteger(value)
+		},
+		},
+	},
+	{
+		// dbg(value)
+		// 输出带有类型的值的内部结构（比如 `ARRAY[INTEGER(1), STRING("x")]`），
+		// 然后原样返回 value，以便包围任意的子表达式
+		"dbg",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+
+			var out strings.Builder
+			writeDebugStructure(&out, args[0], "", map[Object]bool{})
+			fmt.Fprintln(output, out.String())
+			return args[0]
+		},
+		},
+	},
+	{
+		// values(hash)
+		// 按照插入顺序返回 Hash 的所有值，跟 keys 的结果一一对应
+		"values",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments, expected %d, actual %d",
+					1, len(args))
+			}
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return newError("argument type to `values` must be HASH, actual %s",
+					args[0].Type())
+			}
+
+			pairs := hash.OrderedPairs()
+			elements := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				elements[i] = pair.Value
+			}
+			return &Array{Elements: elements}
+		},
+		},
+	},
 }
 
 func newError(format string, a ...interface{}) *Error {
 	return &Error{Message: fmt.Sprintf(format, a...)}
 }
 
+func newFatalError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...), Fatal: true}
+}
+
+// 把回调函数返回的错误转换为 Error 对象，致命错误原样返回
+func callError(err error) *Error {
+	if errObj, ok := err.(*Error); ok && errObj.Fatal {
+		return errObj
+	}
+	return newError("%s", err)
+}
+
+// 字符串直接作为信息，其他对象则使用 Inspect() 的结果
+func messageOf(obj Object) string {
+	if str, ok := obj.(*String); ok {
+		return str.Value
+	}
+	return obj.Inspect()
+}
+
 func GetBuiltinByName(name string) *Builtin {
 	for _, def := range Builtins {
 		if def.Name == name {
@@ -131,3 +999,401 @@ func GetBuiltinByName(name string) *Builtin {
 	}
 	return nil
 }
+
+// 检查数组的元素是否可以排序，即全部是 Integer 或者全部是 String
+func checkOrderedElements(name string, elements []Object) *Error {
+	if len(elements) == 0 {
+		return nil
+	}
+
+	first := elements[0].Type()
+	if first != INTEGER_OBJ && first != STRING_OBJ {
+		return newError("element type to `%s` must be INTEGER or STRING, actual %s",
+			name, first)
+	}
+
+	for _, element := range elements[1:] {
+		if element.Type() != first {
+			return newError("elements to `%s` must be the same type, actual %s and %s",
+				name, first, element.Type())
+		}
+	}
+	return nil
+}
+
+// 比较两个同类型（Integer 或者 String）的对象，返回值的含义跟 CompareStrings 相同
+func compareOrdered(left, right Object) int {
+	switch left := left.(type) {
+	case *Integer:
+		rightValue := right.(*Integer).Value
+		switch {
+		case left.Value < rightValue:
+			return -1
+		case left.Value > rightValue:
+			return 1
+		default:
+			return 0
+		}
+	case *String:
+		return CompareStrings(left, right.(*String))
+	default:
+		return 0
+	}
+}
+
+// maxOf 和 minOf 的共同实现，
+// direction 为 1 时返回最大的元素，为 -1 时返回最小的元素
+func extremumOf(name string, direction int, args []Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments, expected %d, actual %d",
+			1, len(args))
+	}
+	if args[0].Type() != ARRAY_OBJ {
+		return newError("argument type to `%s` must be ARRAY, actual %s",
+			name, args[0].Type())
+	}
+
+	arr := args[0].(*Array)
+	if err := checkOrderedElements(name, arr.Elements); err != nil {
+		return err
+	}
+	if len(arr.Elements) == 0 {
+		return nil
+	}
+
+	result := arr.Elements[0]
+	for _, element := range arr.Elements[1:] {
+		if compareOrdered(element, result)*direction > 0 {
+			result = element
+		}
+	}
+	return result
+}
+
+// min 和 max 的共同实现，参数必须是两个或者更多个整数，
+// direction 为 1 时返回最大的参数，为 -1 时返回最小的参数
+func extremumOfIntegers(name string, direction int, args []Object) Object {
+	if len(args) < 2 {
+		return newError("wrong number of arguments, expected at least %d, actual %d",
+			2, len(args))
+	}
+	for _, arg := range args {
+		if arg.Type() != INTEGER_OBJ {
+			return newError("argument type to `%s` must be INTEGER, actual %s",
+				name, arg.Type())
+		}
+	}
+
+	return extremumOf(name, direction, []Object{&Array{Elements: args}})
+}
+
+// 以平方求幂的方式计算 base 的 exp 次方，exp 为非负数
+func powInteger(base, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+// 把 Integer 或者 Float 转换为 float64
+func floatValueOf(obj Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value), true
+	case *Float:
+		return obj.Value, true
+	default:
+		return 0, false
+	}
+}
+
+// 把 [start, end) 限制在 [0, length] 之内，且 start 不大于 end
+func clampRange(start, end int64, length int) (int, int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > int64(length) {
+		end = int64(length)
+	}
+	if start > end {
+		start = end
+	}
+	return int(start), int(end)
+}
+
+// 复制 Array 和 Hash，copied 记录已经复制过的对象，
+// 用于保持对象之间的共享关系，以及避免循环引用（比如数组包含自身）导致无限递归
+func deepCopy(obj Object, copied map[Object]Object) Object {
+	if result, ok := copied[obj]; ok {
+		return result
+	}
+
+	switch obj := obj.(type) {
+	case *Array:
+		result := &Array{Elements: make([]Object, len(obj.Elements))}
+		copied[obj] = result
+		for i, element := range obj.Elements {
+			result.Elements[i] = deepCopy(element, copied)
+		}
+		return result
+	case *Hash:
+		result := NewHash()
+		copied[obj] = result
+		for _, pair := range obj.OrderedPairs() {
+			hashKey := pair.Key.(Hashable).HashKey()
+			result.Set(hashKey, HashPair{Key: pair.Key, Value: deepCopy(pair.Value, copied)})
+		}
+		return result
+	default:
+		return obj
+	}
+}
+
+// 把对象转换为 JSON 文本并写入 out，
+// visiting 记录正在转换的 Array 和 Hash，用于检查循环引用（比如数组包含自身）
+func encodeJSON(out *bytes.Buffer, obj Object, visiting map[Object]bool) *Error {
+	switch obj := obj.(type) {
+	case *Integer:
+		out.WriteString(strconv.FormatInt(obj.Value, 10))
+	case *Float:
+		if math.IsNaN(obj.Value) || math.IsInf(obj.Value, 0) {
+			return newError("unsupported value for `json`: %s", obj.Inspect())
+		}
+		out.WriteString(strconv.FormatFloat(obj.Value, 'g', -1, 64))
+	case *Boolean:
+		out.WriteString(strconv.FormatBool(obj.Value))
+	case *Null:
+		out.WriteString("null")
+	case *String:
+		encodeJSONString(out, obj.Value)
+	case *Array:
+		if visiting[obj] {
+			return newError("cyclic structure is not supported by `json`")
+		}
+		visiting[obj] = true
+		defer delete(visiting, obj)
+
+		out.WriteString("[")
+		for i, element := range obj.Elements {
+			if i > 0 {
+				out.WriteString(",")
+			}
+			err := encodeJSON(out, element, visiting)
+			if err != nil {
+				return err
+			}
+		}
+		out.WriteString("]")
+	case *Hash:
+		if visiting[obj] {
+			return newError("cyclic structure is not supported by `json`")
+		}
+		visiting[obj] = true
+		defer delete(visiting, obj)
+
+		out.WriteString("{")
+		for i, pair := range obj.OrderedPairs() {
+			key, ok := pair.Key.(*String)
+			if !ok {
+				return newError("hash key type to `json` must be STRING, actual %s",
+					pair.Key.Type())
+			}
+			if i > 0 {
+				out.WriteString(",")
+			}
+			encodeJSONString(out, key.Value)
+			out.WriteString(":")
+			err := encodeJSON(out, pair.Value, visiting)
+			if err != nil {
+				return err
+			}
+		}
+		out.WriteString("}")
+	default:
+		return newError("argument type to `json` not supported, actual %s", obj.Type())
+	}
+	return nil
+}
+
+// 写入带双引号及转义字符的 JSON 字符串
+// 注：
+// 不转义 HTML 字符（比如 `<` 和 `&`），以保持输出的可读性
+func encodeJSONString(out *bytes.Buffer, value string) {
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	out.Truncate(out.Len() - 1) // 移除 Encode 在末尾添加的换行符
+}
+
+// 从 decoder 读取一个 JSON 值并转换为对象
+// 注：
+// 使用 Token 逐个读取而不是 Decode 到 interface{}，以便保持对象的键的顺序
+func decodeJSON(decoder *json.Decoder) (Object, error) {
+	token, err := decoder.Token()
+	if err == io.EOF {
+		// 跟 decoder 在值的中间遇到结尾时的错误信息保持一致
+		return nil, fmt.Errorf("unexpected end of JSON input")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '[' {
+			elements := []Object{}
+			for decoder.More() {
+				element, err := decodeJSON(decoder)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, element)
+			}
+			_, err := decoder.Token() // 读取 ']'
+			if err != nil {
+				return nil, err
+			}
+			return &Array{Elements: elements}, nil
+		}
+
+		// 因为 Token 会检查语法，所以这里只可能是 '{'
+		hash := NewHash()
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := &String{Value: keyToken.(string)}
+
+			value, err := decodeJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(key.HashKey(), HashPair{Key: key, Value: value})
+		}
+		_, err := decoder.Token() // 读取 '}'
+		if err != nil {
+			return nil, err
+		}
+		return hash, nil
+
+	case json.Number:
+		return numberFromJSON(token)
+	case string:
+		return &String{Value: token}, nil
+	case bool:
+		if token {
+			return TRUE, nil
+		}
+		return FALSE, nil
+	default: // nil，即 JSON 的 null
+		return NULL, nil
+	}
+}
+
+// 整数值（比如 `3` 和 `3.0`）的数字转换为 Integer，其他的转换为 Float
+func numberFromJSON(number json.Number) (Object, error) {
+	if value, err := strconv.ParseInt(string(number), 10, 64); err == nil {
+		return NewInteger(value), nil
+	}
+
+	value, err := strconv.ParseFloat(string(number), 64)
+	if err != nil {
+		return nil, err
+	}
+	if value == math.Trunc(value) && value >= math.MinInt64 && value < math.MaxInt64 {
+		return NewInteger(int64(value)), nil
+	}
+	return &Float{Value: value}, nil
+}
+
+// 输出带有类型的值的结构，用于内置函数 dbg
+// 只包含简单值（或者为空）的 Array 和 Hash 输出为一行，
+// 包含其他 Array 或者 Hash 时，每个元素（或者键值对）各占一行，并且按层级缩进
+func writeDebugStructure(out *strings.Builder, obj Object, indent string, visiting map[Object]bool) {
+	switch obj := obj.(type) {
+	case *String:
+		out.WriteString("STRING(" + strconv.Quote(obj.Value) + ")")
+	case *Error:
+		out.WriteString("ERROR(" + strconv.Quote(obj.Message) + ")")
+	case *Null:
+		out.WriteString("NULL")
+	case *Array:
+		if visiting[obj] {
+			out.WriteString("ARRAY[...]") // 循环引用
+			return
+		}
+		visiting[obj] = true
+		defer delete(visiting, obj)
+
+		nested := false
+		for _, element := range obj.Elements {
+			nested = nested || isDebugContainer(element)
+		}
+
+		out.WriteString("ARRAY[")
+		for i, element := range obj.Elements {
+			writeDebugSeparator(out, i, nested, indent)
+			writeDebugStructure(out, element, indent+"  ", visiting)
+		}
+		if nested {
+			out.WriteString("\n" + indent)
+		}
+		out.WriteString("]")
+	case *Hash:
+		if visiting[obj] {
+			out.WriteString("HASH{...}") // 循环引用
+			return
+		}
+		visiting[obj] = true
+		defer delete(visiting, obj)
+
+		pairs := obj.OrderedPairs()
+		nested := false
+		for _, pair := range pairs {
+			nested = nested || isDebugContainer(pair.Key) || isDebugContainer(pair.Value)
+		}
+
+		out.WriteString("HASH{")
+		for i, pair := range pairs {
+			writeDebugSeparator(out, i, nested, indent)
+			writeDebugStructure(out, pair.Key, indent+"  ", visiting)
+			out.WriteString(": ")
+			writeDebugStructure(out, pair.Value, indent+"  ", visiting)
+		}
+		if nested {
+			out.WriteString("\n" + indent)
+		}
+		out.WriteString("}")
+	default:
+		out.WriteString(fmt.Sprintf("%s(%s)", obj.Type(), obj.Inspect()))
+	}
+}
+
+// 判断值是否为非空的 Array 或者 Hash
+func isDebugContainer(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Array:
+		return len(obj.Elements) > 0
+	case *Hash:
+		return len(obj.Pairs) > 0
+	}
+	return false
+}
+
+// 输出第 i 个元素（或者键值对）之前的分隔符，nested 为 true 时每项各占一行并且缩进
+func writeDebugSeparator(out *strings.Builder, i int, nested bool, indent string) {
+	if i > 0 {
+		out.WriteString(",")
+	}
+	if nested {
+		out.WriteString("\n" + indent + "  ")
+	} else if i > 0 {
+		out.WriteString(" ")
+	}
+}
@@ -0,0 +1,715 @@
+package object
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func callBuiltin(name string, args ...Object) Object {
+	builtin := GetBuiltinByName(name)
+	if builtin.CallbackFn != nil {
+		// 这里的测试不需要回调函数
+		return builtin.CallbackFn(nil, args...)
+	}
+	return builtin.Fn(args...)
+}
+
+func integers(values ...int64) *Array {
+	elements := make([]Object, len(values))
+	for i, value := range values {
+		elements[i] = &Integer{Value: value}
+	}
+	return &Array{Elements: elements}
+}
+
+func strs(values ...string) *Array {
+	elements := make([]Object, len(values))
+	for i, value := range values {
+		elements[i] = &String{Value: value}
+	}
+	return &Array{Elements: elements}
+}
+
+func TestSortBuiltin(t *testing.T) {
+	tests := []struct {
+		args     []Object
+		expected string // 结果的 Inspect()
+	}{
+		{[]Object{integers(3, 1, 2)}, "[1, 2, 3]"},
+		{[]Object{integers()}, "[]"},
+		{[]Object{integers(-1, 10, 2, -5)}, "[-5, -1, 2, 10]"},
+		{[]Object{strs("b", "a")}, "[a, b]"},
+		{[]Object{strs("b", "ab", "a", "")}, "[, a, ab, b]"},
+		{
+			[]Object{&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}},
+			"ERROR: elements to `sort` must be the same type, actual INTEGER and STRING",
+		},
+		{
+			[]Object{&Array{Elements: []Object{&Boolean{Value: true}}}},
+			"ERROR: element type to `sort` must be INTEGER or STRING, actual BOOLEAN",
+		},
+		{[]Object{&Integer{Value: 1}}, "ERROR: argument type to `sort` must be ARRAY, actual INTEGER"},
+		{[]Object{}, "ERROR: wrong number of arguments, expected 1 or 2, actual 0"},
+		{
+			[]Object{integers(1), &Integer{Value: 1}},
+			"ERROR: argument type to `sort` must be FUNCTION, actual INTEGER",
+		},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin("sort", test.args...)
+		if result.Inspect() != test.expected {
+			t.Errorf("wrong result, expected %q, actual %q", test.expected, result.Inspect())
+		}
+	}
+}
+
+func TestSortBuiltinDoesNotModifyArgument(t *testing.T) {
+	arr := integers(3, 1, 2)
+	callBuiltin("sort", arr)
+
+	if arr.Inspect() != "[3, 1, 2]" {
+		t.Errorf("argument modified, actual %q", arr.Inspect())
+	}
+}
+
+func TestRangeBuiltin(t *testing.T) {
+	tests := []struct {
+		args     []Object
+		expected string // 结果的 Inspect()
+	}{
+		{[]Object{&Integer{Value: 3}}, "[0, 1, 2]"},
+		{[]Object{&Integer{Value: 0}}, "[]"},
+		{[]Object{&Integer{Value: -2}}, "[]"},
+		{[]Object{&Integer{Value: 2}, &Integer{Value: 5}}, "[2, 3, 4]"},
+		{[]Object{&Integer{Value: 5}, &Integer{Value: 2}}, "[]"},
+		{[]Object{&Integer{Value: -2}, &Integer{Value: 1}}, "[-2, -1, 0]"},
+		{[]Object{&String{Value: "3"}}, "ERROR: argument type to `range` must be INTEGER, actual STRING"},
+		{
+			[]Object{&Integer{Value: 1}, &Boolean{Value: true}},
+			"ERROR: argument type to `range` must be INTEGER, actual BOOLEAN",
+		},
+		{[]Object{}, "ERROR: wrong number of arguments, expected 1 or 2, actual 0"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin("range", test.args...)
+		if result.Inspect() != test.expected {
+			t.Errorf("wrong result, expected %q, actual %q", test.expected, result.Inspect())
+		}
+	}
+}
+
+func TestPutsOutput(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+
+	result := callBuiltin("puts", &String{Value: "hi"}, &Integer{Value: 42})
+	if result != nil {
+		t.Errorf("puts should return nil, actual %v", result)
+	}
+
+	expected := "hi\n42\n"
+	if out.String() != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, out.String())
+	}
+}
+
+func TestInputBuiltin(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(&out)
+	SetInput(strings.NewReader("alice\r\nbob\nlast"))
+	defer SetOutput(os.Stdout)
+	defer SetInput(os.Stdin)
+
+	tests := []struct {
+		args     []Object
+		expected string // 结果的 Inspect()
+	}{
+		{[]Object{}, "alice"},
+		{[]Object{&String{Value: "name: "}}, "bob"},
+		{[]Object{}, "last"},
+		{[]Object{}, "null"},
+		{[]Object{&Integer{Value: 1}}, "ERROR: argument type to `input` must be STRING, actual INTEGER"},
+		{[]Object{&String{Value: "a"}, &String{Value: "b"}},
+			"ERROR: wrong number of arguments, expected 0 or 1, actual 2"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin("input", test.args...)
+
+		actual := "null"
+		if result != nil {
+			actual = result.Inspect()
+		}
+		if actual != test.expected {
+			t.Errorf("wrong result, expected %q, actual %q", test.expected, actual)
+		}
+	}
+
+	if out.String() != "name: " {
+		t.Errorf("wrong prompt output, expected %q, actual %q", "name: ", out.String())
+	}
+}
+
+func TestLenBuiltin(t *testing.T) {
+	one := &Integer{Value: 1}
+	two := &Integer{Value: 2}
+	hash := &Hash{Pairs: map[HashKey]HashPair{
+		one.HashKey(): {Key: one, Value: two},
+		two.HashKey(): {Key: two, Value: one},
+	}}
+
+	tests := []struct {
+		arg      Object
+		expected string // 结果的 Inspect()
+	}{
+		{&Hash{Pairs: map[HashKey]HashPair{}}, "0"},
+		{hash, "2"},
+		{integers(1, 2, 3), "3"},
+		{&String{Value: "four"}, "4"},
+		{&String{Value: "四个字符"}, "4"},
+		{one, "ERROR: argument type to `len` not supported, actual INTEGER"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin("len", test.arg)
+		if result.Inspect() != test.expected {
+			t.Errorf("wrong result, expected %q, actual %q", test.expected, result.Inspect())
+		}
+	}
+}
+
+func TestCopyBuiltin(t *testing.T) {
+	key := &String{Value: "list"}
+	nested := integers(1, 2)
+	original := &Array{Elements: []Object{
+		nested,
+		&Hash{Pairs: map[HashKey]HashPair{
+			key.HashKey(): {Key: key, Value: integers(3)},
+		}},
+		&Integer{Value: 4},
+	}}
+
+	result := callBuiltin("copy", original)
+	copied, ok := result.(*Array)
+	if !ok {
+		t.Fatalf("result is not Array, actual %T", result)
+	}
+	if copied.Inspect() != original.Inspect() {
+		t.Fatalf("copy differs, expected %q, actual %q", original.Inspect(), copied.Inspect())
+	}
+
+	// 修改副本（包括嵌套的 Array 和 Hash）不影响原对象
+	expected := original.Inspect()
+	copied.Elements[0].(*Array).Elements[0] = &Integer{Value: 100}
+	copiedHash := copied.Elements[1].(*Hash)
+	copiedHash.Pairs[key.HashKey()].Value.(*Array).Elements[0] = &Integer{Value: 300}
+	copied.Elements[2] = &Integer{Value: 400}
+
+	if original.Inspect() != expected {
+		t.Errorf("original modified, expected %q, actual %q", expected, original.Inspect())
+	}
+
+	// 数组包含自身
+	cyclic := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	cyclic.Elements[1] = cyclic
+	copiedCyclic := callBuiltin("copy", cyclic).(*Array)
+	if copiedCyclic == cyclic || copiedCyclic.Elements[1] != copiedCyclic {
+		t.Errorf("cyclic array not copied correctly")
+	}
+
+	// 其他类型的值原样返回
+	str := &String{Value: "a"}
+	if callBuiltin("copy", str) != str {
+		t.Errorf("scalar value should be returned as-is")
+	}
+}
+
+func TestNumericBuiltins(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []Object
+		expected string // 结果的 Inspect()
+	}{
+		{"abs", []Object{&Integer{Value: -5}}, "5"},
+		{"abs", []Object{&Integer{Value: 5}}, "5"},
+		{"abs", []Object{&Integer{Value: 0}}, "0"},
+		{"abs", []Object{&Float{Value: -1.5}}, "1.5"},
+		{"abs", []Object{&Integer{Value: math.MinInt64}}, "ERROR: integer overflow: abs(-9223372036854775808)"},
+		{"abs", []Object{&String{Value: "-1"}}, "ERROR: argument type to `abs` must be INTEGER or FLOAT, actual STRING"},
+		{"abs", []Object{}, "ERROR: wrong number of arguments, expected 1, actual 0"},
+
+		{"min", []Object{&Integer{Value: 3}, &Integer{Value: 1}}, "1"},
+		{"min", integers(4, -2, 7, -2, 0).Elements, "-2"},
+		{"max", []Object{&Integer{Value: 3}, &Integer{Value: 1}}, "3"},
+		{"max", integers(4, -2, 7, 0).Elements, "7"},
+		{"max", []Object{&Integer{Value: 1}}, "ERROR: wrong number of arguments, expected at least 2, actual 1"},
+		{"min", []Object{&Integer{Value: 1}, &String{Value: "a"}}, "ERROR: argument type to `min` must be INTEGER, actual STRING"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin(test.name, test.args...)
+		if result.Inspect() != test.expected {
+			t.Errorf("%s: wrong result, expected %q, actual %q",
+				test.name, test.expected, result.Inspect())
+		}
+	}
+}
+
+func TestPowAndSqrtBuiltins(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []Object
+		expected string // 结果的 Inspect()
+	}{
+		{"pow", integers(2, 10).Elements, "1024"},
+		{"pow", integers(3, 0).Elements, "1"},
+		{"pow", integers(-2, 3).Elements, "-8"},
+		{"pow", integers(0, 0).Elements, "1"},
+		// 溢出时按 int64 回绕
+		{"pow", integers(2, 64).Elements, "0"},
+		{"pow", []Object{&Float{Value: 2}, &Integer{Value: -1}}, "0.5"},
+		{"pow", []Object{&Integer{Value: 9}, &Float{Value: 0.5}}, "3"},
+		{"pow", integers(2, -1).Elements, "ERROR: exponent to `pow` must be non-negative for INTEGER, actual -1"},
+		{"pow", []Object{&String{Value: "2"}, &Integer{Value: 1}}, "ERROR: argument type to `pow` must be INTEGER or FLOAT, actual STRING"},
+
+		{"sqrt", []Object{&Integer{Value: 16}}, "4"},
+		{"sqrt", []Object{&Float{Value: 2.25}}, "1.5"},
+		{"sqrt", []Object{&Integer{Value: -4}}, "ERROR: argument to `sqrt` must be non-negative, actual -4"},
+		{"sqrt", []Object{&Boolean{Value: true}}, "ERROR: argument type to `sqrt` must be INTEGER or FLOAT, actual BOOLEAN"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin(test.name, test.args...)
+		if result.Inspect() != test.expected {
+			t.Errorf("%s: wrong result, expected %q, actual %q",
+				test.name, test.expected, result.Inspect())
+		}
+	}
+
+	if _, ok := callBuiltin("sqrt", &Integer{Value: 16}).(*Float); !ok {
+		t.Errorf("result of sqrt should be Float")
+	}
+}
+
+func TestConcatBuiltin(t *testing.T) {
+	tests := []struct {
+		args     []Object
+		expected string // 结果的 Inspect()
+	}{
+		{[]Object{integers(1, 2), integers(3)}, "[1, 2, 3]"},
+		{[]Object{integers(1), strs("a", "b"), integers()}, "[1, a, b]"},
+		{[]Object{integers(), integers()}, "[]"},
+		{[]Object{integers(1), &Integer{Value: 2}}, "ERROR: argument type to `concat` must be ARRAY, actual INTEGER"},
+		{[]Object{integers(1)}, "ERROR: wrong number of arguments, expected at least 2, actual 1"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin("concat", test.args...)
+		if result.Inspect() != test.expected {
+			t.Errorf("wrong result, expected %q, actual %q", test.expected, result.Inspect())
+		}
+	}
+
+	// 结果是新的数组，原数组不变
+	first := integers(1)
+	result := callBuiltin("concat", first, integers(2)).(*Array)
+	result.Elements[0] = &Integer{Value: 9}
+	if first.Inspect() != "[1]" {
+		t.Errorf("original array modified, actual %q", first.Inspect())
+	}
+}
+
+func TestAtBuiltin(t *testing.T) {
+	tests := []struct {
+		args     []Object
+		expected string // 结果的 Inspect()
+	}{
+		{[]Object{integers(10, 20, 30), &Integer{Value: 0}}, "10"},
+		{[]Object{integers(10, 20, 30), &Integer{Value: 2}}, "30"},
+		{[]Object{integers(10, 20, 30), &Integer{Value: 3}}, "ERROR: index out of range: 3, length 3"},
+		{[]Object{integers(10, 20, 30), &Integer{Value: -1}}, "ERROR: index out of range: -1, length 3"},
+		{[]Object{integers(), &Integer{Value: 0}}, "ERROR: index out of range: 0, length 0"},
+		{[]Object{&String{Value: "abc"}, &Integer{Value: 0}}, "ERROR: argument type to `at` must be ARRAY, actual STRING"},
+		{[]Object{integers(1), &String{Value: "0"}}, "ERROR: index type to `at` must be INTEGER, actual STRING"},
+		{[]Object{integers(1)}, "ERROR: wrong number of arguments, expected 2, actual 1"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin("at", test.args...)
+		if result.Inspect() != test.expected {
+			t.Errorf("wrong result, expected %q, actual %q", test.expected, result.Inspect())
+		}
+	}
+}
+
+func TestJsonBuiltin(t *testing.T) {
+	hash := NewHash()
+	for i, key := range []string{"b", "a"} {
+		str := &String{Value: key}
+		hash.Set(str.HashKey(), HashPair{Key: str, Value: &Integer{Value: int64(i)}})
+	}
+
+	nested := NewHash()
+	nestedKey := &String{Value: "list"}
+	nested.Set(nestedKey.HashKey(), HashPair{
+		Key:   nestedKey,
+		Value: &Array{Elements: []Object{integers(1, 2), hash, &Null{}, &Boolean{Value: true}}},
+	})
+
+	intKeyHash := NewHash()
+	intKey := &Integer{Value: 1}
+	intKeyHash.Set(intKey.HashKey(), HashPair{Key: intKey, Value: intKey})
+
+	cyclic := integers(1)
+	cyclic.Elements = append(cyclic.Elements, cyclic)
+
+	tests := []struct {
+		arg      Object
+		expected string // 结果的 Inspect()
+	}{
+		{&Integer{Value: -12}, "-12"},
+		{&Float{Value: 1.5}, "1.5"},
+		{&Boolean{Value: false}, "false"},
+		{&Null{}, "null"},
+		{&String{Value: "a\"b\n<c>"}, `"a\"b\n<c>"`},
+		{integers(), "[]"},
+		{NewHash(), "{}"},
+		{hash, `{"b":0,"a":1}`},
+		{nested, `{"list":[[1,2],{"b":0,"a":1},null,true]}`},
+		// 同一个对象出现多次（而不是循环引用）是允许的
+		{&Array{Elements: []Object{hash, hash}}, `[{"b":0,"a":1},{"b":0,"a":1}]`},
+		{intKeyHash, "ERROR: hash key type to `json` must be STRING, actual INTEGER"},
+		{&Array{Elements: []Object{&Closure{}}}, "ERROR: argument type to `json` not supported, actual CLOSURE"},
+		{&Builtin{}, "ERROR: argument type to `json` not supported, actual BUILTIN"},
+		{&Float{Value: math.Inf(1)}, "ERROR: unsupported value for `json`: +Inf"},
+		{cyclic, "ERROR: cyclic structure is not supported by `json`"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin("json", test.arg)
+		if result.Inspect() != test.expected {
+			t.Errorf("wrong result, expected %q, actual %q", test.expected, result.Inspect())
+		}
+	}
+}
+
+func TestParseJsonBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // 结果的 Inspect()
+	}{
+		{"12", "12"},
+		{"-3.0", "-3"},
+		{"1e3", "1000"},
+		{"1.5", "1.5"},
+		{`"a\nb"`, "a\nb"},
+		{"true", "true"},
+		{"null", "null"},
+		{" [1, [2, 3], {}] ", "[1, [2, 3], {}]"},
+		{`{"b": 1, "a": {"c": [true, null]}}`, "{b: 1, a: {c: [true, null]}}"},
+		{"", "ERROR: invalid JSON: unexpected end of JSON input"},
+		{"[1, 2", "ERROR: invalid JSON: unexpected end of JSON input"},
+		{"{1: 2}", "ERROR: invalid JSON: object member name must be a string"},
+		{"[1,]", "ERROR: invalid JSON: invalid character ',' looking for beginning of value"},
+		{"1 2", "ERROR: invalid JSON: unexpected data after top-level value"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin("parseJson", &String{Value: test.input})
+		if result.Inspect() != test.expected {
+			t.Errorf("wrong result for %q, expected %q, actual %q",
+				test.input, test.expected, result.Inspect())
+		}
+	}
+
+	// Boolean 和 Null 使用唯一的实例
+	if callBuiltin("parseJson", &String{Value: "true"}) != TRUE {
+		t.Errorf("parseJson should return the TRUE instance")
+	}
+	if callBuiltin("parseJson", &String{Value: "[null]"}).(*Array).Elements[0] != NULL {
+		t.Errorf("parseJson should return the NULL instance")
+	}
+
+	result := callBuiltin("parseJson", &Integer{Value: 1})
+	expected := "ERROR: argument type to `parseJson` must be STRING, actual INTEGER"
+	if result.Inspect() != expected {
+		t.Errorf("wrong result, expected %q, actual %q", expected, result.Inspect())
+	}
+}
+
+func TestJsonRoundTrip(t *testing.T) {
+	inputs := []string{
+		`1`,
+		`"x\ty"`,
+		`[1,2.5,"a",true,false,null]`,
+		`{"z":[{"a":1},{}],"y":null,"x":"<&>"}`,
+	}
+
+	for _, input := range inputs {
+		parsed := callBuiltin("parseJson", &String{Value: input})
+		if _, ok := parsed.(*Error); ok {
+			t.Fatalf("parseJson error for %q: %s", input, parsed.Inspect())
+		}
+
+		encoded := callBuiltin("json", parsed)
+		if encoded.Inspect() != input {
+			t.Errorf("wrong round trip, expected %q, actual %q", input, encoded.Inspect())
+		}
+	}
+}
+
+func TestEnvBuiltin(t *testing.T) {
+	t.Setenv("TOYVM_TEST_ENV", "value")
+	t.Setenv("TOYVM_TEST_EMPTY", "")
+
+	tests := []struct {
+		args     []Object
+		expected string // 结果的 Inspect()，nil 表示 null
+	}{
+		{[]Object{&String{Value: "TOYVM_TEST_ENV"}}, "value"},
+		{[]Object{&String{Value: "TOYVM_TEST_ENV"}, &String{Value: "default"}}, "value"},
+		// 值为空字符串的环境变量是存在的
+		{[]Object{&String{Value: "TOYVM_TEST_EMPTY"}, &String{Value: "default"}}, ""},
+		{[]Object{&String{Value: "TOYVM_TEST_UNSET"}, &String{Value: "default"}}, "default"},
+		{[]Object{&String{Value: "TOYVM_TEST_UNSET"}, &Integer{Value: 1}}, "1"},
+		{[]Object{&Integer{Value: 1}}, "ERROR: argument type to `env` must be STRING, actual INTEGER"},
+		{[]Object{}, "ERROR: wrong number of arguments, expected 1 or 2, actual 0"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin("env", test.args...)
+		if result.Inspect() != test.expected {
+			t.Errorf("wrong result, expected %q, actual %q", test.expected, result.Inspect())
+		}
+	}
+
+	// 环境变量不存在并且没有默认值时返回 null（即 nil，由 VM 转换为 Null）
+	result := callBuiltin("env", &String{Value: "TOYVM_TEST_UNSET"})
+	if result != nil {
+		t.Errorf("expected nil for unset variable, actual %s", result.Inspect())
+	}
+}
+
+func TestReadWriteBuiltins(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.txt")
+
+	result := callBuiltin("write", &String{Value: path}, &String{Value: "line 1\nline 2\n"})
+	if result != nil {
+		t.Fatalf("write should return nil, actual %s", result.Inspect())
+	}
+
+	result = callBuiltin("read", &String{Value: path})
+	if result.Inspect() != "line 1\nline 2\n" {
+		t.Errorf("wrong content, expected %q, actual %q", "line 1\nline 2\n", result.Inspect())
+	}
+
+	// 覆盖已存在的文件
+	callBuiltin("write", &String{Value: path}, &String{Value: "new"})
+	result = callBuiltin("read", &String{Value: path})
+	if result.Inspect() != "new" {
+		t.Errorf("wrong content, expected %q, actual %q", "new", result.Inspect())
+	}
+
+	missing := filepath.Join(dir, "missing.txt")
+	tests := []struct {
+		name     string
+		args     []Object
+		expected string // 结果的 Inspect()
+	}{
+		{"read", []Object{&String{Value: missing}},
+			"ERROR: open " + missing + ": no such file or directory"},
+		{"write", []Object{&String{Value: filepath.Join(dir, "no", "such", "dir")}, &String{Value: ""}},
+			"ERROR: open " + filepath.Join(dir, "no", "such", "dir") + ": no such file or directory"},
+		{"read", []Object{&Integer{Value: 1}}, "ERROR: argument type to `read` must be STRING, actual INTEGER"},
+		{"write", []Object{&String{Value: path}, &Integer{Value: 1}},
+			"ERROR: contents type to `write` must be STRING, actual INTEGER"},
+		{"write", []Object{&String{Value: path}}, "ERROR: wrong number of arguments, expected 2, actual 1"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin(test.name, test.args...)
+		if result.Inspect() != test.expected {
+			t.Errorf("wrong result for %s, expected %q, actual %q",
+				test.name, test.expected, result.Inspect())
+		}
+	}
+}
+
+func TestCharsBuiltin(t *testing.T) {
+	tests := []struct {
+		args     []Object
+		expected string // 结果的 InspectDebug()，字符串带有双引号
+	}{
+		{[]Object{&String{Value: "abc"}}, `["a", "b", "c"]`},
+		{[]Object{&String{Value: ""}}, "[]"},
+		{[]Object{&String{Value: "你好😀"}}, `["你", "好", "😀"]`},
+		{[]Object{&Integer{Value: 1}}, "ERROR: argument type to `chars` must be STRING, actual INTEGER"},
+		{[]Object{}, "ERROR: wrong number of arguments, expected 1, actual 0"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin("chars", test.args...)
+		if InspectDebug(result) != test.expected {
+			t.Errorf("wrong result, expected %q, actual %q", test.expected, InspectDebug(result))
+		}
+	}
+}
+
+func TestOrdChrBuiltins(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []Object
+		expected string // 结果的 InspectDebug()，字符串带有双引号
+	}{
+		{"ord", []Object{&String{Value: "A"}}, "65"},
+		{"ord", []Object{&String{Value: "好"}}, "22909"},
+		{"ord", []Object{&String{Value: "AB"}},
+			"ERROR: argument to `ord` must be a single character, actual length 2"},
+		{"ord", []Object{&String{Value: ""}},
+			"ERROR: argument to `ord` must be a single character, actual length 0"},
+		{"ord", []Object{&Integer{Value: 65}}, "ERROR: argument type to `ord` must be STRING, actual INTEGER"},
+		{"chr", []Object{&Integer{Value: 65}}, `"A"`},
+		{"chr", []Object{&Integer{Value: 0x1F600}}, `"😀"`},
+		{"chr", []Object{&Integer{Value: -1}}, "ERROR: invalid code point for `chr`: -1"},
+		{"chr", []Object{&Integer{Value: 0xD800}}, "ERROR: invalid code point for `chr`: 55296"},
+		{"chr", []Object{&Integer{Value: 1 << 40}}, "ERROR: invalid code point for `chr`: 1099511627776"},
+		{"chr", []Object{&String{Value: "A"}}, "ERROR: argument type to `chr` must be INTEGER, actual STRING"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin(test.name, test.args...)
+		if InspectDebug(result) != test.expected {
+			t.Errorf("wrong result of %s, expected %q, actual %q", test.name, test.expected, InspectDebug(result))
+		}
+	}
+}
+
+func TestIntBuiltin(t *testing.T) {
+	tests := []struct {
+		args     []Object
+		expected string // 结果的 Inspect()
+	}{
+		{[]Object{&String{Value: "42"}}, "42"},
+		{[]Object{&String{Value: "-42"}}, "-42"},
+		{[]Object{&String{Value: "ff"}, &Integer{Value: 16}}, "255"},
+		{[]Object{&String{Value: "FF"}, &Integer{Value: 16}}, "255"},
+		{[]Object{&String{Value: "1010"}, &Integer{Value: 2}}, "10"},
+		{[]Object{&String{Value: "17"}, &Integer{Value: 8}}, "15"},
+		{[]Object{&String{Value: "zz"}, &Integer{Value: 16}},
+			"ERROR: invalid integer for `int` with base 16: \"zz\""},
+		{[]Object{&String{Value: "12"}, &Integer{Value: 2}},
+			"ERROR: invalid integer for `int` with base 2: \"12\""},
+		{[]Object{&String{Value: ""}}, "ERROR: invalid integer for `int` with base 10: \"\""},
+		{[]Object{&String{Value: "99999999999999999999"}},
+			"ERROR: integer out of range for `int`: \"99999999999999999999\""},
+		{[]Object{&String{Value: "10"}, &Integer{Value: 3}},
+			"ERROR: invalid base for `int`: 3, expected 2, 8, 10 or 16"},
+		{[]Object{&String{Value: "10"}, &String{Value: "16"}},
+			"ERROR: base type to `int` must be INTEGER, actual STRING"},
+		{[]Object{&Integer{Value: 1}}, "ERROR: argument type to `int` must be STRING, actual INTEGER"},
+		{[]Object{}, "ERROR: wrong number of arguments, expected 1 or 2, actual 0"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin("int", test.args...)
+		if result.Inspect() != test.expected {
+			t.Errorf("wrong result, expected %q, actual %q", test.expected, result.Inspect())
+		}
+	}
+}
+
+func TestDbgBuiltin(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+
+	hash := NewHash()
+	for _, pair := range []HashPair{
+		{Key: &String{Value: "name"}, Value: &String{Value: "x"}},
+		{Key: &String{Value: "list"}, Value: integers(1, 2)},
+	} {
+		hash.Set(pair.Key.(Hashable).HashKey(), pair)
+	}
+
+	tests := []struct {
+		arg      Object
+		expected string // 输出的文本（不包括末尾的换行符）
+	}{
+		{&Integer{Value: 1}, "INTEGER(1)"},
+		{&String{Value: "a\"b"}, `STRING("a\"b")`},
+		{NULL, "NULL"},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "x"}, TRUE}},
+			`ARRAY[INTEGER(1), STRING("x"), BOOLEAN(true)]`},
+		{&Array{Elements: []Object{}}, "ARRAY[]"},
+		{&Array{Elements: []Object{&Integer{Value: 1}, hash, &Array{Elements: []Object{}}}},
+			"ARRAY[\n" +
+				"  INTEGER(1),\n" +
+				"  HASH{\n" +
+				`    STRING("name"): STRING("x"),` + "\n" +
+				`    STRING("list"): ARRAY[INTEGER(1), INTEGER(2)]` + "\n" +
+				"  },\n" +
+				"  ARRAY[]\n" +
+				"]"},
+	}
+
+	for _, test := range tests {
+		out.Reset()
+		result := callBuiltin("dbg", test.arg)
+		if result != test.arg {
+			t.Errorf("dbg should return its argument, actual %v", result)
+		}
+		if out.String() != test.expected+"\n" {
+			t.Errorf("wrong output, expected %q, actual %q", test.expected+"\n", out.String())
+		}
+	}
+
+	// 循环引用
+	out.Reset()
+	cyclic := &Array{Elements: []Object{&Integer{Value: 1}}}
+	cyclic.Elements = append(cyclic.Elements, cyclic)
+	callBuiltin("dbg", cyclic)
+	expected := "ARRAY[\n  INTEGER(1),\n  ARRAY[...]\n]\n"
+	if out.String() != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, out.String())
+	}
+}
+
+func TestKeysAndValuesBuiltins(t *testing.T) {
+	hash := NewHash()
+	for _, pair := range []HashPair{
+		{Key: &Integer{Value: 3}, Value: &String{Value: "c"}},
+		{Key: &Integer{Value: 1}, Value: &String{Value: "a"}},
+		{Key: &Integer{Value: 2}, Value: &String{Value: "b"}},
+		// 更新已存在的键不改变顺序
+		{Key: &Integer{Value: 1}, Value: &String{Value: "x"}},
+	} {
+		hash.Set(pair.Key.(Hashable).HashKey(), pair)
+	}
+
+	tests := []struct {
+		name     string
+		args     []Object
+		expected string // 结果的 Inspect()
+	}{
+		{"keys", []Object{hash}, "[3, 1, 2]"},
+		{"values", []Object{hash}, "[c, x, b]"},
+		{"values", []Object{NewHash()}, "[]"},
+		{"values", []Object{&Integer{Value: 1}}, "ERROR: argument type to `values` must be HASH, actual INTEGER"},
+		{"values", []Object{}, "ERROR: wrong number of arguments, expected 1, actual 0"},
+	}
+
+	for _, test := range tests {
+		result := callBuiltin(test.name, test.args...)
+		if result.Inspect() != test.expected {
+			t.Errorf("wrong result of %s, expected %q, actual %q", test.name, test.expected, result.Inspect())
+		}
+	}
+}
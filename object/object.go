@@ -6,6 +6,9 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
 	"toyvm/code"
 )
@@ -15,6 +18,7 @@ type ObjectType string
 // ObjectType 可能的值
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	STRING_OBJ       = "STRING"
 	NULL_OBJ         = "NULL"
@@ -48,6 +52,43 @@ func (i *Integer) Inspect() string {
 	return fmt.Sprintf("%d", i.Value)
 }
 
+// 被缓存的小整数的范围
+const (
+	minCachedInteger = -128
+	maxCachedInteger = 255
+)
+
+// 小整数缓存，跟 True/False/Null 一样，同一个值只有一个实例。
+// 因为 Integer 是不可变的，所以共享同一个实例是安全的。
+var cachedIntegers = func() []*Integer {
+	integers := make([]*Integer, maxCachedInteger-minCachedInteger+1)
+	for i := range integers {
+		integers[i] = &Integer{Value: int64(i + minCachedInteger)}
+	}
+	return integers
+}()
+
+// 返回值为 value 的 Integer，对于小整数返回缓存的实例，以减少内存分配
+func NewInteger(value int64) *Integer {
+	if value >= minCachedInteger && value <= maxCachedInteger {
+		return cachedIntegers[value-minCachedInteger]
+	}
+	return &Integer{Value: value}
+}
+
+// 浮点数
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType {
+	return ObjectType(FLOAT_OBJ)
+}
+
+func (f *Float) Inspect() string {
+	return strconv.FormatFloat(f.Value, 'g', -1, 64)
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -64,6 +105,15 @@ type Null struct {
 	//
 }
 
+// Boolean 和 Null 的唯一实例（VM 的 True, False, Null 常量）
+// VM 通过比较指针判断 Boolean 和 Null（比如 `==`），所以内置函数需要返回这些实例，
+// 而不是创建新的 Boolean 和 Null
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
+)
+
 func (n *Null) Type() ObjectType {
 	return ObjectType(NULL_OBJ)
 }
@@ -79,6 +129,31 @@ type String struct {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 
+// 用于调试（比如 REPL 输出表达式的值）的文本：添加双引号，并转义换行符等控制字符，
+// 以便区分 "1" 跟 1，以及看到字符串里的空白字符。
+// 注：puts 等输出字符串的内容时仍然使用 Inspect
+func (s *String) InspectDebug() string { return strconv.Quote(s.Value) }
+
+// 有单独的调试用文本的 Object
+type DebugInspector interface {
+	InspectDebug() string
+}
+
+// 返回 Object 用于调试的文本，如果 Object 没有实现 DebugInspector 则返回 Inspect() 的结果
+func InspectDebug(obj Object) string {
+	if inspector, ok := obj.(DebugInspector); ok {
+		return inspector.InspectDebug()
+	}
+	return obj.Inspect()
+}
+
+// 比较两个字符串的大小（按字节的字典顺序），
+// left 较小时返回 -1，相等时返回 0，left 较大时返回 1。
+// 运算符 `<`/`>` 以及内置函数 sort、maxOf、minOf 都使用这个函数比较字符串。
+func CompareStrings(left, right *String) int {
+	return strings.Compare(left.Value, right.Value)
+}
+
 // 包裹其他 Object 的 Object，用于 return 语句
 type ReturnValue struct {
 	Value Object
@@ -89,11 +164,15 @@ func (r *ReturnValue) Inspect() string  { return r.Value.Inspect() }
 
 type Error struct {
 	Message string
+	Fatal   bool // 致命错误，虚拟机遇到由内置函数返回的致命错误时会中止执行
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
 
+// 实现 error 接口，以便致命错误可以穿过回调函数（CallFunction）传递
+func (e *Error) Error() string { return e.Message }
+
 // 函数 // --
 //
 // type Function struct {
@@ -123,9 +202,12 @@ func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
 
 // 用于编译的用户自定义函数
 type CompiledFunction struct {
+	Name          string            // 函数的名称（函数字面量被绑定到的标识符），匿名函数为空字符串
 	Instructions  code.Instructions // 用户自定义函数主体的指令（[]byte）
 	NumLocals     int               // 函数内局部变量的数量，用于在运算栈保留空间给局部变量使用
 	NumParameters int               // 参数的个数
+	NumDefaults   int               // 有默认值的参数（即最后若干个参数）的个数
+	Lines         code.LineTable    // 指令位置跟源码行号的对应表
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -136,8 +218,15 @@ func (cf *CompiledFunction) Inspect() string {
 // 内置函数
 type BuiltinFunction func(args ...Object) Object
 
+// 由 VM 提供的用于回调函数（闭包或者内置函数）的方法，返回函数的返回值
+type CallFunction func(fn Object, args ...Object) (Object, error)
+
+// 需要回调函数的内置函数，比如 iterate
+type CallbackBuiltinFunction func(call CallFunction, args ...Object) Object
+
 type Builtin struct {
-	Fn BuiltinFunction
+	Fn         BuiltinFunction
+	CallbackFn CallbackBuiltinFunction // 不为 nil 时，VM 调用这个函数而不是 Fn
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -145,8 +234,9 @@ func (b *Builtin) Inspect() string  { return "builtin function" }
 
 // 闭包
 type Closure struct {
-	Fn   *CompiledFunction
-	Free []Object // 被捕捉的局部变量，相当于 interpreter 当中的 Environment
+	Fn       *CompiledFunction
+	Free     []Object // 被捕捉的局部变量，相当于 interpreter 当中的 Environment
+	Defaults []Object // 参数的默认值，在创建闭包（即定义函数）时求值
 }
 
 func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
@@ -161,10 +251,19 @@ type Array struct {
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
 func (ao *Array) Inspect() string {
+	return ao.inspect(Object.Inspect)
+}
+
+// 元素使用调试用的文本
+func (ao *Array) InspectDebug() string {
+	return ao.inspect(InspectDebug)
+}
+
+func (ao *Array) inspect(inspectElement func(Object) string) string {
 	var out bytes.Buffer
 	elements := []string{}
 	for _, element := range ao.Elements {
-		elements = append(elements, element.Inspect())
+		elements = append(elements, inspectElement(element))
 	}
 	out.WriteString("[")
 	out.WriteString(strings.Join(elements, ", "))
@@ -172,7 +271,7 @@ func (ao *Array) Inspect() string {
 	return out.String()
 }
 
-// Map 的 Key，当前只支持 Boolean/Integer/String 作为 Key 的值
+// Map 的 Key，当前只支持 Boolean/Integer/Float/String 作为 Key 的值
 type HashKey struct {
 	Type  ObjectType
 	Value uint64
@@ -196,6 +295,13 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// 使用浮点数的位模式作为 HashKey 的值
+// 注：
+// 因为 HashKey 包含类型，所以 1.0 跟 1 是不同的 key
+func (f *Float) HashKey() HashKey {
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -210,15 +316,70 @@ type HashPair struct {
 // 映射表（即 Map）
 type Hash struct {
 	Pairs map[HashKey]HashPair
+
+	// 键的插入顺序，由 Set 维护。
+	// 直接构造 Pairs 的 Hash 的 Keys 可能为空，此时没有插入顺序，见 OrderedPairs
+	Keys []HashKey
+}
+
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
+}
+
+// 添加或者更新一个键值对，新的键添加到插入顺序的末尾，已存在的键则保持原来的位置
+func (h *Hash) Set(hashKey HashKey, pair HashPair) {
+	if _, ok := h.Pairs[hashKey]; !ok {
+		h.Keys = append(h.Keys, hashKey)
+	}
+	h.Pairs[hashKey] = pair
+}
+
+// 按照插入顺序返回所有键值对
+// 对于没有插入顺序的 Hash（即 Keys 跟 Pairs 不一致），按照 HashKey 排列，
+// 以保证结果是确定的
+func (h *Hash) OrderedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+
+	if len(h.Keys) == len(h.Pairs) {
+		for _, hashKey := range h.Keys {
+			pairs = append(pairs, h.Pairs[hashKey])
+		}
+		return pairs
+	}
+
+	hashKeys := make([]HashKey, 0, len(h.Pairs))
+	for hashKey := range h.Pairs {
+		hashKeys = append(hashKeys, hashKey)
+	}
+	sort.Slice(hashKeys, func(i, j int) bool {
+		if hashKeys[i].Type != hashKeys[j].Type {
+			return hashKeys[i].Type < hashKeys[j].Type
+		}
+		return hashKeys[i].Value < hashKeys[j].Value
+	})
+
+	for _, hashKey := range hashKeys {
+		pairs = append(pairs, h.Pairs[hashKey])
+	}
+	return pairs
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 func (h *Hash) Inspect() string {
+	return h.inspect(Object.Inspect)
+}
+
+// 键和值使用调试用的文本
+func (h *Hash) InspectDebug() string {
+	return h.inspect(InspectDebug)
+}
+
+func (h *Hash) inspect(inspectElement func(Object) string) string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.OrderedPairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
-			pair.Key.Inspect(), pair.Value.Inspect()))
+			inspectElement(pair.Key), inspectElement(pair.Value)))
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
@@ -18,3 +18,66 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	float1 := &Float{Value: 1.5}
+	float2 := &Float{Value: 1.5}
+	diff := &Float{Value: 2.5}
+	one := &Float{Value: 1.0}
+	integerOne := &Integer{Value: 1}
+
+	if float1.HashKey() != float2.HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+	if float1.HashKey() == diff.HashKey() {
+		t.Errorf("floats with different values have same hash keys")
+	}
+	if one.HashKey() == integerOne.HashKey() {
+		t.Errorf("float 1.0 and integer 1 have same hash keys")
+	}
+}
+
+func TestNewInteger(t *testing.T) {
+	for _, value := range []int64{-129, -128, -1, 0, 1, 255, 256, 100000} {
+		integer := NewInteger(value)
+		if integer.Value != value {
+			t.Errorf("wrong value, expected %d, actual %d", value, integer.Value)
+		}
+
+		cached := value >= -128 && value <= 255
+		if (NewInteger(value) == integer) != cached {
+			t.Errorf("wrong cache behavior for %d, expected cached=%t", value, cached)
+		}
+	}
+}
+
+func TestStringInspectDebug(t *testing.T) {
+	str := &String{Value: "a\tb\nc"}
+
+	if str.Inspect() != "a\tb\nc" {
+		t.Errorf("wrong Inspect, expected %q, actual %q", "a\tb\nc", str.Inspect())
+	}
+
+	expected := `"a\tb\nc"`
+	if str.InspectDebug() != expected {
+		t.Errorf("wrong InspectDebug, expected %q, actual %q", expected, str.InspectDebug())
+	}
+
+	tests := []struct {
+		obj      Object
+		expected string
+	}{
+		{str, expected},
+		{NewInteger(1), "1"},
+		{&Array{Elements: []Object{NewInteger(1), &String{Value: "1"}}}, `[1, "1"]`},
+		{&Array{Elements: []Object{str}}, `["a\tb\nc"]`},
+	}
+
+	for _, test := range tests {
+		actual := InspectDebug(test.obj)
+		if actual != test.expected {
+			t.Errorf("wrong InspectDebug for %s, expected %q, actual %q",
+				test.obj.Inspect(), test.expected, actual)
+		}
+	}
+}
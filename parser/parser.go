@@ -5,6 +5,7 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"toyvm/ast"
 	"toyvm/lexer"
 	"toyvm/token"
@@ -14,6 +15,8 @@ import (
 const (
 	_           int = iota
 	LOWEST          // 最低优先级，比如从 “语句” 进来的 "表达式" 解析阶段。
+	ASSIGNMENT      // = += -= *= /=
+	CONDITIONAL     // a ? b : c
 	LOGICOR         // ||
 	LOGICAND        // &&
 	EQUALS          // ==
@@ -28,6 +31,14 @@ const (
 
 // 各个运算符 token 对应的优先级
 var precedences = map[token.TokenType]int{
+	token.ASSIGN:          ASSIGNMENT, // =
+	token.PLUS_ASSIGN:     ASSIGNMENT, // +=
+	token.MINUS_ASSIGN:    ASSIGNMENT, // -=
+	token.ASTERISK_ASSIGN: ASSIGNMENT, // *=
+	token.SLASH_ASSIGN:    ASSIGNMENT, // /=
+
+	token.QUESTION: CONDITIONAL, // ?
+
 	token.AND: LOGICAND, // &&
 	token.OR:  LOGICOR,  // ||
 
@@ -41,6 +52,7 @@ var precedences = map[token.TokenType]int{
 	token.MINUS:    SUM,     // -
 	token.SLASH:    PRODUCT, // /
 	token.ASTERISK: PRODUCT, // *
+	token.PERCENT:  PRODUCT, // %
 
 	token.LPAREN:   CALL,  // (
 	token.LBRACKET: INDEX, // [
@@ -85,10 +97,13 @@ type Parser struct {
 	curToken  token.Token // current token
 	peekToken token.Token // next token
 
-	errors []string
+	errors ParseErrors
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	// 被括号包围的表达式，用于区分 `a < b < c`（链式比较）和 `(a < b) < c`（比较布尔值）
+	grouped map[ast.Expression]bool
 }
 
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
@@ -101,8 +116,9 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:       l,
+		errors:  ParseErrors{},
+		grouped: map[ast.Expression]bool{},
 	}
 
 	// 读两次，让 current token 和 peek token 都赋予值
@@ -118,13 +134,16 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.TRUE, p.parseBooleanLiteral)
 	p.registerPrefix(token.FALSE, p.parseBooleanLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegalToken)
 
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression) // 表达式括号 (...)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)    // 数组字面量中括号 [...]
-	p.registerPrefix(token.LBRACE, p.parseHashLiteral)       // 映射表字面量花括号 {...}
+	p.registerPrefix(token.LBRACE, p.parseBraceExpression)   // 映射表字面量或者语句块表达式 {...}
 
 	p.registerPrefix(token.IF, p.parseIfExpression)             // 当前 toy lang 里，if 是表达式（而不是语句）
 	p.registerPrefix(token.FUNCTION, p.parseFunctionExpression) // 当前 toy lang 里，fn 是表达式
+	p.registerPrefix(token.TRY, p.parseTryExpression)           // try {...} catch (e) {...}
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)       // match x {1 => a, _ => b}
 
 	// 注册一元操作符解析过程
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)  // !
@@ -136,6 +155,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.parseInfixExpression)    // -
 	p.registerInfix(token.SLASH, p.parseInfixExpression)    // /
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression) // *
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)  // %
 	p.registerInfix(token.EQ, p.parseInfixExpression)       // ==
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)   // "!="
 	p.registerInfix(token.LT, p.parseInfixExpression)       // <
@@ -144,6 +164,14 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.AND, p.parseInfixExpression) // &&
 	p.registerInfix(token.OR, p.parseInfixExpression)  // ||
 
+	p.registerInfix(token.QUESTION, p.parseConditionalExpression) // a ? b : c
+
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)          // =
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)     // +=
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)    // -=
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression) // *=
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)    // /=
+
 	// 解析函数调用和索引
 	//p.registerInfix(token.LPAREN, p.parseCallExpression // "(...)"
 	//p.registerInfix(token.LBRACKET, p.parseIndexExpression) // "[...]"
@@ -151,15 +179,48 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
-func (p *Parser) Errors() []string {
+// 解析错误，除了错误信息，还记录了出错的 token 及其位置，以便编辑器等工具使用
+type ParseError struct {
+	Message string
+	Line    int
+	Column  int
+	Token   token.Token
+}
+
+func (e ParseError) String() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+type ParseErrors []ParseError
+
+// 返回所有错误信息（不包括位置），用于兼容以前的输出格式
+func (errors ParseErrors) Strings() []string {
+	messages := make([]string, len(errors))
+	for i, e := range errors {
+		messages[i] = e.Message
+	}
+	return messages
+}
+
+func (p *Parser) Errors() ParseErrors {
 	return p.errors
 }
 
+// 添加一个错误，tk 为出错的 token
+func (p *Parser) addError(tk token.Token, msg string) {
+	p.errors = append(p.errors, ParseError{
+		Message: msg,
+		Line:    tk.Line,
+		Column:  tk.Column,
+		Token:   tk,
+	})
+}
+
 func (p *Parser) peekError(t token.TokenType) {
 	msg := fmt.Sprintf("expected next token type %q, actual %q",
 		t,
 		p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addError(p.peekToken, msg)
 }
 
 func (p *Parser) nextToken() {
@@ -191,8 +252,13 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curToken.Type != token.EOF {
+		numErrors := len(p.errors)
 		statement := p.parseStatement()
-		if statement != nil {
+		if len(p.errors) > numErrors {
+			// 语句解析失败，跳过剩余的 token，从下一条语句开始继续解析，
+			// 以便一次报告多个互不相关的错误
+			p.synchronize()
+		} else if statement != nil {
 			program.Statements = append(program.Statements, statement)
 		}
 
@@ -205,17 +271,71 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return program
 }
 
+// 错误恢复：跳过 token 直到当前 token 为 ";"，或者下一个 token 是
+// 语句的开始关键字（let、const、letrec、return）
+func (p *Parser) synchronize() {
+	for !p.curTokenIs(token.SEMICOLON) && !p.curTokenIs(token.EOF) {
+		switch p.peekToken.Type {
+		case token.LET, token.CONST, token.LETREC, token.RETURN:
+			return
+		}
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
-	case token.LET:
+	case token.LET, token.CONST:
+		// const 语句跟 let 语句的语法相同，只是其 token 为 CONST
 		return p.parseLetStatement()
+	case token.LETREC:
+		return p.parseLetRecStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.FUNCTION:
+		if p.peekTokenIs(token.IDENT) {
+			return p.parseFunctionDeclaration()
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
+// fn <name> <parameters> <block statement>
+// e.g.
+// "fn add(a, b) { a + b }"
+// 函数声明语句是 `let add = fn(a, b) { a + b };` 的语法糖，
+// 解析结果是一个 let 语句，函数字面量的 Name 为函数的名称，所以函数内可以递归调用自身。
+func (p *Parser) parseFunctionDeclaration() *ast.LetStatement {
+	function := &ast.FunctionLiteral{Token: p.curToken}
+
+	// 移动到函数名称
+	p.nextToken()
+	name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	function.Name = name.Value
+
+	if !p.parseFunctionLiteralRest(function) {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	// 使用 fn token 的位置，以便语句有正确的行号（用于行号表及调用栈）
+	return &ast.LetStatement{
+		Token: token.Token{
+			Type:    token.LET,
+			Literal: "let",
+			Line:    function.Token.Line,
+			Column:  function.Token.Column,
+		},
+		Name:  name,
+		Value: function,
+	}
+}
+
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	statement := &ast.LetStatement{Token: p.curToken}
 
@@ -253,6 +373,58 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return statement
 }
 
+// letrec { <name> = <expression>; <name> = <expression>; ... }
+// e.g.
+// "letrec { isEven = fn(n) {...}; isOdd = fn(n) {...} }"
+func (p *Parser) parseLetRecStatement() *ast.LetRecStatement {
+	statement := &ast.LetRecStatement{Token: p.curToken}
+
+	// 移动到 "{"
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		name := &ast.Identifier{
+			Token: p.curToken,
+			Value: p.curToken.Literal,
+		}
+
+		if !p.expectPeek(token.ASSIGN) {
+			return nil
+		}
+
+		p.nextToken()
+
+		value := p.parseExpression(LOWEST)
+
+		if fl, ok := value.(*ast.FunctionLiteral); ok {
+			fl.Name = name.Value
+		}
+
+		statement.Names = append(statement.Names, name)
+		statement.Values = append(statement.Values, value)
+
+		// 各个绑定之间使用 ";" 分隔，最后一个绑定的 ";" 是可省的
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+	}
+
+	// 移动到 "}"
+	p.nextToken()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	// 当前 token 停留在 "}" 或者 ';' 位置
+	return statement
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	statement := &ast.ReturnStatement{
 		Token: p.curToken,
@@ -412,10 +584,13 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 		Token: p.curToken,
 	}
 
-	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	// 去除数字之间的分隔符 "_"，比如 1_000_000
+	digits := strings.ReplaceAll(p.curToken.Literal, "_", "")
+
+	value, err := strconv.ParseInt(digits, 0, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken, msg)
 		return nil
 	}
 
@@ -459,6 +634,9 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 		return nil
 	}
 
+	if expression != nil {
+		p.grouped[expression] = true
+	}
 	return expression
 }
 
@@ -468,30 +646,93 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	return array
 }
 
-func (p *Parser) parseHashLiteral() ast.Expression {
-	hash := &ast.HashLiteral{Token: p.curToken}
-	hash.Pairs = make(map[ast.Expression]ast.Expression)
+// "{" 开始的表达式有可能是映射表字面量，也有可能是语句块表达式
+// 判断的方法：
+// - 空的花括号 "{}" 是空映射表
+// - 以语句（比如 let、const、return 以及函数声明）开始的是语句块表达式
+// - 否则先解析第一个表达式，如果紧接着 ":" 则是映射表字面量，否则是语句块表达式
+func (p *Parser) parseBraceExpression() ast.Expression {
+	if p.peekTokenIs(token.RBRACE) {
+		return p.parseHashLiteral()
+	}
 
-	// 当前位于 token "{"
+	startToken := p.curToken
 
-	for !p.peekTokenIs(token.RBRACE) { // 有可能存在空映射表，即 "{}"
+	if p.peekTokenIs(token.LET) || p.peekTokenIs(token.CONST) ||
+		p.peekTokenIs(token.LETREC) || p.peekTokenIs(token.RETURN) {
+		block := &ast.BlockExpression{Token: startToken}
 		p.nextToken()
+		return p.parseBlockExpressionRest(block)
+	}
 
-		key := p.parseExpression(LOWEST) // key 和 value 都有可能是任意 expression
-		if !p.expectPeek(token.COLON) {
+	p.nextToken()
+	// 函数声明语句（"fn" 之后紧接着函数名称）也是语句，而函数字面量则是表达式
+	if p.curTokenIs(token.FUNCTION) && p.peekTokenIs(token.IDENT) {
+		block := &ast.BlockExpression{Token: startToken}
+		return p.parseBlockExpressionRest(block)
+	}
+
+	firstStatementToken := p.curToken
+	first := p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.COLON) {
+		hash := &ast.HashLiteral{Token: startToken}
+		hash.Pairs = make(map[ast.Expression]ast.Expression)
+		if !p.parseHashPair(hash, first) {
 			return nil
 		}
+		return p.parseHashLiteralRest(hash)
+	}
 
-		// 当前处于 token ":"
+	block := &ast.BlockExpression{Token: startToken}
+	block.Statements = append(block.Statements,
+		&ast.ExpressionStatement{Token: firstStatementToken, Expression: first})
+
+	// 第一个表达式后面的 ';' 是可省的
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
+	}
+	p.nextToken()
+	return p.parseBlockExpressionRest(block)
+}
 
-		value := p.parseExpression(LOWEST)
+// 从当前 token 开始解析语句块表达式剩余的语句，直到遇到 "}"
+func (p *Parser) parseBlockExpressionRest(block *ast.BlockExpression) ast.Expression {
+	for !p.curTokenIs(token.RBRACE) {
+		if p.curTokenIs(token.EOF) {
+			msg := fmt.Sprintf("expected next token type %q, actual %q",
+				token.RBRACE, token.EOF)
+			p.addError(p.curToken, msg)
+			return nil
+		}
+
+		statement := p.parseStatement()
+		if statement != nil {
+			block.Statements = append(block.Statements, statement)
+		}
+		p.nextToken()
+	}
 
-		hash.Pairs[key] = value
+	// 当前 token 处于 "}" 符号上
+	return block
+}
 
-		// 下一个应该是 "," 或者 "}"
-		if p.peekTokenIs(token.COMMA) {
-			p.nextToken()
+func (p *Parser) parseHashLiteral() ast.Expression {
+	hash := &ast.HashLiteral{Token: p.curToken}
+	hash.Pairs = make(map[ast.Expression]ast.Expression)
+
+	// 当前位于 token "{"
+	return p.parseHashLiteralRest(hash)
+}
+
+// 解析映射表字面量剩余的成员，直到遇到 "}"
+func (p *Parser) parseHashLiteralRest(hash *ast.HashLiteral) ast.Expression {
+	for !p.peekTokenIs(token.RBRACE) { // 有可能存在空映射表，即 "{}"
+		p.nextToken()
+
+		key := p.parseExpression(LOWEST) // key 和 value 都有可能是任意 expression
+		if !p.parseHashPair(hash, key) {
+			return nil
 		}
 	}
 
@@ -502,9 +743,39 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	return hash
 }
 
+// 解析已解析的 key 后面的 ": value" 部分，以及可能存在的 ","
+func (p *Parser) parseHashPair(hash *ast.HashLiteral, key ast.Expression) bool {
+	if !p.expectPeek(token.COLON) {
+		return false
+	}
+
+	// 当前处于 token ":"
+	p.nextToken()
+
+	value := p.parseExpression(LOWEST)
+
+	hash.Pairs[key] = value
+	hash.Keys = append(hash.Keys, key)
+
+	// 下一个应该是 "," 或者 "}"
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+	}
+
+	return true
+}
+
+// 词法分析遇到的错误（比如不明字符、未结束的字符串）以 ILLEGAL token 的形式出现，
+// 其 Literal 为不明字符或者错误信息
+func (p *Parser) parseIllegalToken() ast.Expression {
+	msg := fmt.Sprintf("illegal token: %s", p.curToken.Literal)
+	p.addError(p.curToken, msg)
+	return nil
+}
+
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %q found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(p.curToken, msg)
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
@@ -531,6 +802,101 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	// 如果这里让 parseExpression(precedence -1) 可以实现
 	// 同一个运算符实现右->左结合
 	expression.Right = p.parseExpression(precedence)
+
+	// 左边的比较表达式被括号包围时，按照括号的意图比较其结果（布尔值），而不是链式比较
+	if isComparison(expression) && !p.grouped[left] {
+		return chainComparison(expression)
+	}
+	return expression
+}
+
+func isComparison(node ast.Expression) bool {
+	infix, ok := node.(*ast.InfixExpression)
+	return ok && (infix.Operator == "<" || infix.Operator == ">")
+}
+
+// 链式比较
+// e.g.
+// `a < b < c` 转换为 `a < b && b < c`，
+// `a < b < c < d` 转换为 `(a < b && b < c) && c < d`
+//
+// 中间的操作数（比如 b）在前后两个比较表达式里是同一个节点，
+// 编译器据此保证它只被求值一次。
+func chainComparison(expression *ast.InfixExpression) ast.Expression {
+	previous := expression.Left
+
+	// 左边已经是一个链式比较，则跟它的最后一个比较表达式连接
+	if and, ok := previous.(*ast.InfixExpression); ok && and.Operator == "&&" {
+		previous = and.Right
+	}
+
+	if !isComparison(previous) {
+		return expression
+	}
+
+	left := expression.Left
+	expression.Left = previous.(*ast.InfixExpression).Right
+
+	return &ast.InfixExpression{
+		Token: token.Token{
+			Type:    token.AND,
+			Literal: "&&",
+			Line:    expression.Token.Line,
+			Column:  expression.Token.Column,
+		},
+		Operator: "&&",
+		Left:     left,
+		Right:    expression,
+	}
+}
+
+// <condition> ? <consequence> : <alternative>
+//
+// 条件表达式是右结合的，即 `a ? b : c ? d : e` 等同于 `a ? b : (c ? d : e)`。
+// 注：
+// 因为 ":" 不是中缀运算符，所以解析 consequence 时遇到 ":" 就会停止，
+// 在映射表字面量里（比如 `{"x": a ? 1 : 2}`）也不会跟键值之间的 ":" 混淆。
+func (p *Parser) parseConditionalExpression(condition ast.Expression) ast.Expression {
+	expression := &ast.ConditionalExpression{
+		Token:     p.curToken,
+		Condition: condition,
+	}
+
+	p.nextToken()
+	expression.Consequence = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Alternative = p.parseExpression(CONDITIONAL - 1)
+	return expression
+}
+
+// <target> = <value>
+// <target> += <value>
+// <target> 可以是标识符或者索引表达式，比如 `x = 1`、`arr[0] = 1`
+//
+// 赋值运算符是右结合的，即 `a = b = 1` 等同于 `a = (b = 1)`
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		msg := fmt.Sprintf("invalid assignment target: %s", target.String())
+		p.addError(p.curToken, msg)
+		return nil
+	}
+
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Target:   target,
+	}
+	precedence := p.curPrecedence()
+	p.nextToken()
+
+	expression.Value = p.parseExpression(precedence - 1)
 	return expression
 }
 
@@ -569,6 +935,26 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		// 移动到 ELSE
 		p.nextToken()
 
+		if p.peekTokenIs(token.IF) {
+			// `else if (...) {...}`
+			// 把后面的 if 表达式包装成只有一个表达式语句的语句块，作为 alternative
+			p.nextToken()
+			ifToken := p.curToken
+
+			nested := p.parseIfExpression()
+			if nested == nil {
+				return nil
+			}
+
+			expression.Alternative = &ast.BlockStatement{
+				Token: ifToken,
+				Statements: []ast.Statement{
+					&ast.ExpressionStatement{Token: ifToken, Expression: nested},
+				},
+			}
+			return expression
+		}
+
 		// 移动到 "{"
 		if !p.expectPeek(token.LBRACE) {
 			return nil
@@ -581,6 +967,103 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+// try {<statements>} catch (<identifier>) {<statements>}
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	// 移动到 "{"
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Body = p.parseBlockStatement()
+
+	// 移动到 "catch"
+	if !p.expectPeek(token.CATCH) {
+		return nil
+	}
+
+	// 移动到 "("
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	// 移动到错误对象的标识符
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	expression.Parameter = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	// 移动到 ")"
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	// 移动到 "{"
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Handler = p.parseBlockStatement()
+
+	// 当前 token 处于 "}" 符号上
+	return expression
+}
+
+// match <expression> { <pattern> => <expression>, ..., _ => <expression> }
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+
+	// 移动到 "{"
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		// 默认分支只能是最后一个分支
+		if expression.Default != nil {
+			p.addError(p.curToken, "default arm `_` must be the last arm of match")
+			return nil
+		}
+
+		pattern := p.parseExpression(LOWEST)
+		identifier, ok := pattern.(*ast.Identifier)
+		isDefault := ok && identifier.Value == "_"
+
+		// 移动到 "=>"
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+		p.nextToken()
+
+		body := p.parseExpression(LOWEST)
+
+		if isDefault {
+			expression.Default = body
+		} else {
+			expression.Arms = append(expression.Arms, &ast.MatchArm{Pattern: pattern, Body: body})
+		}
+
+		// 下一个应该是 "," 或者 "}"
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		}
+	}
+
+	// 移动到 "}"
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return expression
+}
+
 // {<statements>}
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken} // "{"
@@ -609,29 +1092,43 @@ func (p *Parser) parseFunctionExpression() ast.Expression {
 
 	expression := &ast.FunctionLiteral{Token: p.curToken}
 
+	if !p.parseFunctionLiteralRest(expression) {
+		return nil
+	}
+
+	// 当前 token 处于 "}" 符号上
+	return expression
+}
+
+// 解析函数字面量的参数列表和函数体，即 "fn" 关键字（以及函数名称）之后的部分，
+// 解析失败时返回 false
+func (p *Parser) parseFunctionLiteralRest(function *ast.FunctionLiteral) bool {
 	// 移动到 "("
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return false
 	}
 
 	// 解析参数列表
-	expression.Parameters = p.parseFunctionParameters()
+	function.Parameters, function.Defaults = p.parseFunctionParameters()
 
 	// 当前处于 ")"，下一个 token 应该是 "{"
 
 	// 移动到 "{"
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return false
 	}
 
-	expression.Body = p.parseBlockStatement()
-
-	// 当前 token 处于 "}" 符号上
-	return expression
+	function.Body = p.parseBlockStatement()
+	return true
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
+// <parameter> = <identifier>
+// <parameter> = <identifier> = <default value>
+// 返回参数列表，以及最后若干个参数的默认值
+// 有默认值的参数之后的参数也必须有默认值，比如 "fn(a, b = 1, c = 2)"
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, []ast.Expression) {
 	identifiers := []*ast.Identifier{}
+	defaults := []ast.Expression{}
 
 	// 当前处于 "("
 
@@ -641,11 +1138,22 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	for !p.curTokenIs(token.RPAREN) {
 		identifier, ok := p.parseIdentifier().(*ast.Identifier)
 		if !ok {
-			return nil
+			return nil, nil
 		}
 
 		identifiers = append(identifiers, identifier)
 
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken() // 移动到 "="
+			p.nextToken() // 移动到默认值表达式
+			defaults = append(defaults, p.parseExpression(LOWEST))
+		} else if len(defaults) > 0 {
+			msg := fmt.Sprintf("parameter %s without default value follows parameter with default value",
+				identifier.Value)
+			p.addError(p.curToken, msg)
+			return nil, nil
+		}
+
 		p.nextToken()
 
 		if p.curTokenIs(token.COMMA) {
@@ -654,7 +1162,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	}
 
 	// 当前处于 ")"
-	return identifiers
+	return identifiers, defaults
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
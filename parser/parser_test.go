@@ -7,6 +7,7 @@ import (
 	"testing"
 	"toyvm/ast"
 	"toyvm/lexer"
+	"toyvm/token"
 )
 
 func checkParserErrors(t *testing.T, p *Parser) {
@@ -18,9 +19,9 @@ func checkParserErrors(t *testing.T, p *Parser) {
 
 	t.Errorf("parser has %d errors", len(errors))
 
-	for i, msg := range errors {
+	for i, e := range errors {
 		t.Errorf("parser error #%d: %q",
-			i, msg)
+			i, e.String())
 	}
 
 	t.FailNow()
@@ -61,6 +62,53 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestConstStatements(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedIdentifier string
+		expectedValue      interface{}
+		expectedString     string
+	}{
+		{"const x = 1;", "x", 1, "const x = 1;"},
+		{"const name = foo", "name", "foo", "const name = foo;"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("expected 1 statement, actual %d", len(program.Statements))
+		}
+
+		statement, ok := program.Statements[0].(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("statement is not *ast.LetStatement, actual %T", program.Statements[0])
+		}
+		if !statement.IsConst() {
+			t.Errorf("statement is not const")
+		}
+		if statement.Name.Value != test.expectedIdentifier {
+			t.Errorf("wrong name, expected %q, actual %q", test.expectedIdentifier, statement.Name.Value)
+		}
+		if !testLiteralExpression(t, statement.Value, test.expectedValue) {
+			return
+		}
+		if statement.String() != test.expectedString {
+			t.Errorf("wrong String(), expected %q, actual %q", test.expectedString, statement.String())
+		}
+	}
+
+	// let 语句不是 const
+	program := New(lexer.New("let x = 1;")).ParseProgram()
+	if program.Statements[0].(*ast.LetStatement).IsConst() {
+		t.Errorf("let statement should not be const")
+	}
+}
+
 func testLetStatement(t *testing.T, statement ast.Statement, identifierName string) bool {
 	if statement.TokenLiteral() != "let" { // LET token 本身
 		t.Errorf("TokenLiteral expected 'let', actual '%q'", statement.TokenLiteral())
@@ -473,6 +521,46 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"!-a",
 			"(!(-a))",
 		},
+		{
+			"a < b < c",
+			"((a < b) && (b < c))",
+		},
+		{
+			"(a < b) < c",
+			"((a < b) < c)",
+		},
+		{
+			"(a < b < c) > d",
+			"(((a < b) && (b < c)) > d)",
+		},
+		{
+			"a < (b < c) < d",
+			"((a < (b < c)) && ((b < c) < d))",
+		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
+		{
+			"a ? b : c ? d : e",
+			"(a ? b : (c ? d : e))",
+		},
+		{
+			"a > b ? a + 1 : b * 2",
+			"((a > b) ? (a + 1) : (b * 2))",
+		},
+		{
+			"x = a ? b : c",
+			"(x = (a ? b : c))",
+		},
+		{
+			"a < b > c < d",
+			"(((a < b) && (b > c)) && (c < d))",
+		},
+		{
+			"a < b == c < d",
+			"((a < b) == (c < d))",
+		},
 		{
 			"a + b + c",
 			"((a + b) + c)",
@@ -692,6 +780,76 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestElseIfExpression(t *testing.T) {
+	input := `if (x < y) { x } else if (x > y) { y } else { 0 }`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected 1 statement, actual %d\n", len(program.Statements))
+	}
+
+	statement, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("expected ast.ExpressionStatement, actual %T",
+			program.Statements[0])
+	}
+
+	expression, ok := statement.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("statement.Expression expected *ast.IfExpression, actual %T", statement.Expression)
+	}
+
+	if !testInfixExpression(t, expression.Condition, "x", "<", "y") {
+		return
+	}
+
+	// `else if` 被包装成只有一个表达式语句的 alternative
+	if len(expression.Alternative.Statements) != 1 {
+		t.Fatalf("alternative expected 1 statement, actual %d\n",
+			len(expression.Alternative.Statements))
+	}
+
+	alternative, ok := expression.Alternative.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("expected *ast.ExpressionStatement, actual %T",
+			expression.Alternative.Statements[0])
+	}
+
+	nested, ok := alternative.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("alternative expected *ast.IfExpression, actual %T", alternative.Expression)
+	}
+
+	if !testInfixExpression(t, nested.Condition, "x", ">", "y") {
+		return
+	}
+
+	consequence, ok := nested.Consequence.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("expected *ast.ExpressionStatement, actual %T",
+			nested.Consequence.Statements[0])
+	}
+
+	if !testIdentifier(t, consequence.Expression, "y") {
+		return
+	}
+
+	last, ok := nested.Alternative.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("expected *ast.ExpressionStatement, actual %T",
+			nested.Alternative.Statements[0])
+	}
+
+	if !testIntegerLiteral(t, last.Expression, 0) {
+		return
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 
@@ -770,6 +928,101 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestFunctionDefaultParameters(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedParams   []string
+		expectedDefaults []string
+	}{
+		{"fn(a, b = 10) {};", []string{"a", "b"}, []string{"10"}},
+		{"fn(a = 1, b = 2 + 3) {};", []string{"a", "b"}, []string{"1", "(2 + 3)"}},
+		{"fn(a, b, c = [1, 2]) {};", []string{"a", "b", "c"}, []string{"[1, 2]"}},
+		{"fn(a, b) {};", []string{"a", "b"}, []string{}},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		statement := program.Statements[0].(*ast.ExpressionStatement)
+		functionLiteral := statement.Expression.(*ast.FunctionLiteral)
+
+		if len(functionLiteral.Parameters) != len(test.expectedParams) {
+			t.Fatalf("expected parameters %d, actual %d\n",
+				len(test.expectedParams), len(functionLiteral.Parameters))
+		}
+		for i, identifierName := range test.expectedParams {
+			testLiteralExpression(t, functionLiteral.Parameters[i], identifierName)
+		}
+
+		if len(functionLiteral.Defaults) != len(test.expectedDefaults) {
+			t.Fatalf("expected default values %d, actual %d\n",
+				len(test.expectedDefaults), len(functionLiteral.Defaults))
+		}
+		for i, expected := range test.expectedDefaults {
+			if functionLiteral.Defaults[i].String() != expected {
+				t.Errorf("wrong default value, expected %q, actual %q",
+					expected, functionLiteral.Defaults[i].String())
+			}
+		}
+	}
+
+	l := lexer.New("fn(a = 1, b) {}")
+	p := New(l)
+	p.ParseProgram()
+
+	expected := "parameter b without default value follows parameter with default value"
+	if len(p.Errors()) == 0 || p.Errors()[0].Message != expected {
+		t.Errorf("wrong parser errors, expected %q, actual %v", expected, p.Errors())
+	}
+}
+
+func TestFunctionDeclaration(t *testing.T) {
+	input := `fn add(a, b) { a + b }; fn(x) { x };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("expected 2 statements, actual %d", len(program.Statements))
+	}
+
+	// 函数声明语句被解析为 let 语句
+	statement, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("expected *ast.LetStatement, actual %T", program.Statements[0])
+	}
+	if !testLetStatement(t, statement, "add") {
+		return
+	}
+
+	function, ok := statement.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("expected *ast.FunctionLiteral, actual %T", statement.Value)
+	}
+	if function.Name != "add" {
+		t.Errorf("wrong function name, expected %q, actual %q", "add", function.Name)
+	}
+	if len(function.Parameters) != 2 {
+		t.Fatalf("expected 2 parameters, actual %d", len(function.Parameters))
+	}
+
+	expected := "let add = fn<add>(a, b) (a + b);"
+	if statement.String() != expected {
+		t.Errorf("wrong statement, expected %q, actual %q", expected, statement.String())
+	}
+
+	// 没有名称的函数仍然是函数字面量表达式
+	if _, ok := program.Statements[1].(*ast.ExpressionStatement); !ok {
+		t.Errorf("expected *ast.ExpressionStatement, actual %T", program.Statements[1])
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -1067,3 +1320,559 @@ func TestFunctionLiteralWithName(t *testing.T) {
 			function.Name)
 	}
 }
+
+func TestLetRecStatement(t *testing.T) {
+	input := `
+	letrec {
+		isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+		isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } }
+	}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected 1 statement, actual %d", len(program.Statements))
+	}
+
+	statement, ok := program.Statements[0].(*ast.LetRecStatement)
+	if !ok {
+		t.Fatalf("expected *ast.LetRecStatement, actual %T", program.Statements[0])
+	}
+
+	expectedNames := []string{"isEven", "isOdd"}
+	if len(statement.Names) != len(expectedNames) {
+		t.Fatalf("expected %d names, actual %d", len(expectedNames), len(statement.Names))
+	}
+
+	for i, name := range expectedNames {
+		if statement.Names[i].Value != name {
+			t.Errorf("name #%d expected %q, actual %q", i, name, statement.Names[i].Value)
+		}
+
+		function, ok := statement.Values[i].(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("value #%d expected *ast.FunctionLiteral, actual %T", i, statement.Values[i])
+		}
+
+		if function.Name != name {
+			t.Errorf("function literal name expected %q, actual %q", name, function.Name)
+		}
+	}
+}
+
+func TestIllegalTokenErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{`let s = "unterminated`, "illegal token: unterminated string"},
+		{`"hello`, "illegal token: unterminated string"},
+		{`1 @ 2`, "illegal token: @"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors().Strings()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, but got none", test.input)
+			continue
+		}
+
+		if errors[0] != test.expectedError {
+			t.Errorf("wrong parser error for %q, expected %q, actual %q",
+				test.input, test.expectedError, errors[0])
+		}
+	}
+}
+
+func TestPrefixedAndSeparatedIntegerLiterals(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedValue int64
+	}{
+		{"0xFF;", 255},
+		{"0b1010;", 10},
+		{"0o17;", 15},
+		{"1_000;", 1000},
+		{"1_000_000;", 1000000},
+		{"0xFF_FF;", 65535},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("expected 1 statement, actual %d",
+				len(program.Statements))
+		}
+
+		statement, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] expected ast.ExpressionStatement, actual %T",
+				program.Statements[0])
+		}
+
+		literal, ok := statement.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("expected *ast.IntegerLiteral, actual %T", statement.Expression)
+		}
+
+		if literal.Value != test.expectedValue {
+			t.Errorf("literal.Value for %q expected %d, actual %d",
+				test.input, test.expectedValue, literal.Value)
+		}
+	}
+}
+
+func TestInvalidIntegerLiteral(t *testing.T) {
+	l := lexer.New("0b12;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors().Strings()
+	expected := `could not parse "0b12" as integer`
+	if len(errors) != 1 || errors[0] != expected {
+		t.Errorf("expected parser error %q, actual %q", expected, errors)
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	input := `try { x / y } catch (e) { e }`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected 1 statement, actual %d", len(program.Statements))
+	}
+
+	statement, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("expected ast.ExpressionStatement, actual %T", program.Statements[0])
+	}
+	expression, ok := statement.Expression.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("statement.Expression expected *ast.TryExpression, actual %T", statement.Expression)
+	}
+
+	if len(expression.Body.Statements) != 1 {
+		t.Fatalf("body expected 1 statement, actual %d", len(expression.Body.Statements))
+	}
+	body := expression.Body.Statements[0].(*ast.ExpressionStatement)
+	if !testInfixExpression(t, body.Expression, "x", "/", "y") {
+		return
+	}
+
+	if expression.Parameter.Value != "e" {
+		t.Errorf("wrong parameter, expected %q, actual %q", "e", expression.Parameter.Value)
+	}
+
+	if len(expression.Handler.Statements) != 1 {
+		t.Fatalf("handler expected 1 statement, actual %d", len(expression.Handler.Statements))
+	}
+	handler := expression.Handler.Statements[0].(*ast.ExpressionStatement)
+	if !testIdentifier(t, handler.Expression, "e") {
+		return
+	}
+
+	expected := "try (x / y) catch (e) e"
+	if expression.String() != expected {
+		t.Errorf("wrong String(), expected %q, actual %q", expected, expression.String())
+	}
+
+	// 缺少 catch 语句块
+	p = New(lexer.New(`try { 1 }`))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected parser errors for try without catch")
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedArms   int
+		hasDefault     bool
+		expectedString string
+	}{
+		{"match x { 1 => a, 2 => b, _ => c }", 2, true, "match x {1 => a, 2 => b, _ => c}"},
+		{"match x { 1 => a, 2 => b, }", 2, false, "match x {1 => a, 2 => b}"},
+		{"match x + 1 { y * 2 => { 1; 2 } _ => 0 }", 1, true, "match (x + 1) {(y * 2) => {12}, _ => 0}"},
+		{"match x { }", 0, false, "match x {}"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("expected 1 statement, actual %d", len(program.Statements))
+		}
+
+		statement, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("expected ast.ExpressionStatement, actual %T", program.Statements[0])
+		}
+		expression, ok := statement.Expression.(*ast.MatchExpression)
+		if !ok {
+			t.Fatalf("statement.Expression expected *ast.MatchExpression, actual %T", statement.Expression)
+		}
+
+		if len(expression.Arms) != test.expectedArms {
+			t.Errorf("wrong number of arms, expected %d, actual %d", test.expectedArms, len(expression.Arms))
+		}
+		if (expression.Default != nil) != test.hasDefault {
+			t.Errorf("wrong default arm for %q, actual %v", test.input, expression.Default)
+		}
+		if expression.String() != test.expectedString {
+			t.Errorf("wrong String(), expected %q, actual %q", test.expectedString, expression.String())
+		}
+	}
+
+	// 默认分支之后不能再有其他分支
+	p := New(lexer.New("match x { _ => 1, 2 => 3 }"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || p.Errors()[0].Message != "default arm `_` must be the last arm of match" {
+		t.Errorf("expected default arm error, actual %v", p.Errors())
+	}
+}
+
+func TestBlockExpression(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedStatements int
+		expectedString     string
+	}{
+		{"let x = { 1; 2; 3 };", 3, "let x = {123};"},
+		{"{ let a = 1; a + 2 }", 2, "{let a = 1;(a + 2)}"},
+		{"{ a }", 1, "{a}"},
+		{"{ return 1; }", 1, "{return 1;}"},
+		{"{ f(1) \n g(2) }", 2, "{f(1)g(2)}"},
+		{"let v = { const y = 1; y }", 2, "let v = {const y = 1;y};"},
+		{"{ fn f() { 2 }; f() }", 2, "{let f = fn<f>() 2;f()}"},
+		{"{ fn() { 2 } }", 1, "{fn() 2}"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("expected 1 statement, actual %d", len(program.Statements))
+		}
+
+		var expression ast.Expression
+		switch statement := program.Statements[0].(type) {
+		case *ast.LetStatement:
+			expression = statement.Value
+		case *ast.ExpressionStatement:
+			expression = statement.Expression
+		}
+
+		block, ok := expression.(*ast.BlockExpression)
+		if !ok {
+			t.Fatalf("expected *ast.BlockExpression, actual %T", expression)
+		}
+
+		if len(block.Statements) != test.expectedStatements {
+			t.Errorf("wrong number of statements for %q, expected %d, actual %d",
+				test.input, test.expectedStatements, len(block.Statements))
+		}
+
+		if program.String() != test.expectedString {
+			t.Errorf("wrong program string, expected %q, actual %q",
+				test.expectedString, program.String())
+		}
+	}
+}
+
+func TestBlockExpressionAndHashLiteral(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedHash bool
+	}{
+		{"{}", true},
+		{"{1: 2}", true},
+		{`{"a" + "b": 2, "c": 3}`, true},
+		{"{1}", false},
+		{"{1; 2}", false},
+		{"{let a = 1;}", false},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		statement := program.Statements[0].(*ast.ExpressionStatement)
+		_, isHash := statement.Expression.(*ast.HashLiteral)
+		if isHash != test.expectedHash {
+			t.Errorf("%q parsed as %T", test.input, statement.Expression)
+		}
+	}
+}
+
+func TestUnterminatedBlockExpression(t *testing.T) {
+	l := lexer.New("let x = { 1; 2")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors().Strings()
+	expected := `expected next token type "}", actual "EOF"`
+	if len(errors) == 0 || errors[0] != expected {
+		t.Errorf("expected parser error %q, actual %q", expected, errors)
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedValue string
+	}{
+		{"x = 1", "(x = 1)"},
+		{"x += 1 + 2 * 3", "(x += (1 + (2 * 3)))"},
+		{"a = b = c", "(a = (b = c))"},
+		{"a -= b *= 2", "(a -= (b *= 2))"},
+		{"x /= -y", "(x /= (-y))"},
+		{"f(x = 1)", "f((x = 1))"},
+		{"arr[0] = 99", "((arr[0]) = 99)"},
+		{"arr[i + 1] = arr[i] * 2", "((arr[(i + 1)]) = ((arr[i]) * 2))"},
+		{"m[0][1] = 2", "(((m[0])[1]) = 2)"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		actual := program.String()
+		if actual != test.expectedValue {
+			t.Errorf("expected %q, actual %q", test.expectedValue, actual)
+		}
+	}
+
+	errorTests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"1 = 2", "invalid assignment target: 1"},
+		{"a + b = 2", "invalid assignment target: (a + b)"},
+		{"f() = 2", "invalid assignment target: f()"},
+	}
+
+	for _, test := range errorTests {
+		l := lexer.New(test.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors().Strings()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, but got none", test.input)
+			continue
+		}
+
+		if errors[0] != test.expectedError {
+			t.Errorf("wrong parser error for %q, expected %q, actual %q",
+				test.input, test.expectedError, errors[0])
+		}
+	}
+}
+
+func TestStructuredParseErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedLine    int
+		expectedColumn  int
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{"let x = 1;\nlet 5 = y;", `expected next token type "IDENT", actual "INT"`, 2, 5, token.INT, "5"},
+		{"let a = 1;\n  let b 2;", `expected next token type "=", actual "INT"`, 2, 9, token.INT, "2"},
+		{"if (x {\n  1\n}", `expected next token type ")", actual "{"`, 1, 7, token.LBRACE, "{"},
+		{"1 +\n\n   ;", `no prefix parse function for ";" found`, 3, 4, token.SEMICOLON, ";"},
+	}
+
+	for _, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, but got none", test.input)
+			continue
+		}
+
+		e := errors[0]
+		if e.Message != test.expectedMessage {
+			t.Errorf("wrong message for %q, expected %q, actual %q",
+				test.input, test.expectedMessage, e.Message)
+		}
+		if e.Line != test.expectedLine || e.Column != test.expectedColumn {
+			t.Errorf("wrong position for %q, expected %d:%d, actual %d:%d",
+				test.input, test.expectedLine, test.expectedColumn, e.Line, e.Column)
+		}
+		if e.Token.Type != test.expectedType || e.Token.Literal != test.expectedLiteral {
+			t.Errorf("wrong token for %q, expected %s(%q), actual %s(%q)",
+				test.input, test.expectedType, test.expectedLiteral, e.Token.Type, e.Token.Literal)
+		}
+	}
+
+	errors := ParseErrors{{Message: "foo", Line: 1, Column: 2}, {Message: "bar", Line: 3, Column: 4}}
+	messages := errors.Strings()
+	if len(messages) != 2 || messages[0] != "foo" || messages[1] != "bar" {
+		t.Errorf("wrong error strings, actual %q", messages)
+	}
+	if errors[1].String() != "3:4: bar" {
+		t.Errorf("wrong error string, expected %q, actual %q", "3:4: bar", errors[1].String())
+	}
+}
+
+func TestParserErrorRecovery(t *testing.T) {
+	input := `
+let = 1;
+let y 2;
+let z = 3;
+`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	expected := []string{
+		`expected next token type "IDENT", actual "="`,
+		`expected next token type "=", actual "INT"`,
+	}
+
+	errors := p.Errors()
+	if len(errors) != len(expected) {
+		t.Fatalf("wrong number of parser errors, expected %d, actual %d: %q",
+			len(expected), len(errors), errors.Strings())
+	}
+
+	for i, e := range errors {
+		if e.Message != expected[i] {
+			t.Errorf("parser error #%d: expected %q, actual %q", i, expected[i], e.Message)
+		}
+	}
+
+	if errors[0].Line != 2 || errors[1].Line != 3 {
+		t.Errorf("wrong error lines, expected 2 and 3, actual %d and %d",
+			errors[0].Line, errors[1].Line)
+	}
+
+	// 出错的语句之后的语句仍然被正常解析
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement, actual %d",
+			len(program.Statements))
+	}
+	if !testLetStatement(t, program.Statements[0], "z") {
+		return
+	}
+
+	// 没有分号分隔的语句，在下一个 let 关键字之前恢复
+	l = lexer.New("let 1 + 2 let x = 1")
+	p = New(l)
+	program = p.ParseProgram()
+
+	if len(p.Errors()) != 1 || len(program.Statements) != 1 {
+		t.Errorf("expected 1 error and 1 statement, actual %q and %d statements",
+			p.Errors().Strings(), len(program.Statements))
+	}
+}
+
+func TestChainedComparison(t *testing.T) {
+	l := lexer.New("1 < f() < 10")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	statement := program.Statements[0].(*ast.ExpressionStatement)
+	and, ok := statement.Expression.(*ast.InfixExpression)
+	if !ok || and.Operator != "&&" {
+		t.Fatalf("expression is not && InfixExpression, actual %T %q",
+			statement.Expression, statement.Expression.String())
+	}
+
+	left, ok := and.Left.(*ast.InfixExpression)
+	if !ok || left.String() != "(1 < f())" {
+		t.Fatalf("wrong left comparison, actual %q", and.Left.String())
+	}
+
+	right, ok := and.Right.(*ast.InfixExpression)
+	if !ok || right.String() != "(f() < 10)" {
+		t.Fatalf("wrong right comparison, actual %q", and.Right.String())
+	}
+
+	// 中间的操作数是同一个节点，以便编译器只对它求值一次
+	if left.Right != right.Left {
+		t.Errorf("middle operand should be shared by both comparisons")
+	}
+}
+
+func TestConditionalExpressionInHashLiteral(t *testing.T) {
+	input := `{"x": a ? 1 : 2, "y": b ? c ? 3 : 4 : 5, d ? "k" : "j": 6}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	statement := program.Statements[0].(*ast.ExpressionStatement)
+	hashLiteral, ok := statement.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("expected ast.HashLiteral, actual %T", statement.Expression)
+	}
+
+	// 键与值之间的 ":" 不会被条件表达式消耗，条件表达式也不会在 "?" 处中止
+	expected := map[string]string{
+		"x":           "(a ? 1 : 2)",
+		"y":           "(b ? (c ? 3 : 4) : 5)",
+		"(d ? k : j)": "6",
+	}
+
+	if len(hashLiteral.Pairs) != len(expected) {
+		t.Fatalf("expected %d Pairs, actual %d", len(expected), len(hashLiteral.Pairs))
+	}
+
+	for key, value := range hashLiteral.Pairs {
+		expectedValue, ok := expected[key.String()]
+		if !ok {
+			t.Errorf("unexpected key %q", key.String())
+			continue
+		}
+		if value.String() != expectedValue {
+			t.Errorf("wrong value for key %q, expected %q, actual %q",
+				key.String(), expectedValue, value.String())
+		}
+	}
+
+	l = lexer.New("a ? 1 2")
+	p = New(l)
+	p.ParseProgram()
+
+	expectedError := `expected next token type ":", actual "INT"`
+	if len(p.Errors()) == 0 || p.Errors()[0].Message != expectedError {
+		t.Errorf("wrong parser errors, expected %q, actual %q", expectedError, p.Errors().Strings())
+	}
+}
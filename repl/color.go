@@ -0,0 +1,43 @@
+package repl
+
+import (
+	"os"
+)
+
+// ANSI 颜色代码
+const (
+	colorReset  = "\x1b[0m"
+	colorPrompt = "\x1b[36m" // 青色
+	colorResult = "\x1b[32m" // 绿色
+	colorError  = "\x1b[31m" // 红色
+)
+
+// 是否使用 ANSI 颜色代码输出提示符、结果以及错误信息，默认不使用
+var color = false
+
+// 设置是否输出带颜色的文本，一般使用 ColorSupported 的结果
+func SetColor(enabled bool) {
+	color = enabled
+}
+
+// 判断输出的目标是否支持颜色，即输出到终端（TTY），
+// 并且没有设置环境变量 NO_COLOR（见 https://no-color.org/），TERM 也不是 "dumb"
+func ColorSupported(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// 使用指定的颜色包围文本，未开启颜色时原样返回
+func colorize(code string, text string) string {
+	if !color {
+		return text
+	}
+	return code + text + colorReset
+}
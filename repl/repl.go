@@ -4,74 +4,268 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"os"
+	"strings"
+	"toyvm/ast"
 	"toyvm/compiler"
 	"toyvm/lexer"
 	"toyvm/object"
 	"toyvm/parser"
+	"toyvm/token"
 	"toyvm/vm"
 )
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
-	// 编译器和 VM 的状态
+// 输入不完整（比如括号未闭合）时，继续读取下一行所使用的提示符
+const CONTINUATION_PROMPT = ".. "
+
+// 保存上一个结果的全局变量的名称，比如输入 `1 + 2` 之后，`_ * 10` 的值为 30
+const LAST_RESULT_NAME = "_"
+
+// 以 ":" 开头的输入行是 REPL 的命令，比如 ":dis 1 + 2"
+const COMMAND_PREFIX = ":"
+
+// REPL 会话的状态，即编译器和 VM 的状态
+type session struct {
+	symbolTable *compiler.SymbolTable
+	globals     []object.Object
+
+	// 注：constants 这个变量会被改变
+	constants []object.Object
+}
+
+func newSession() *session {
 	symbolTable := compiler.NewSymbolTable()
-	globals := make([]object.Object, vm.GlobalsSize)
 
 	// 添加内置函数
 	for i, v := range object.Builtins {
 		symbolTable.DefineBuiltin(i, v.Name)
 	}
 
-	// 注：constants 这个变量会被改变
-	constants := []object.Object{}
+	return &session{
+		symbolTable: symbolTable,
+		globals:     make([]object.Object, vm.GlobalsSize),
+		constants:   []object.Object{},
+	}
+}
+
+func Start(in io.Reader, out io.Writer) {
+	StartWithSession(in, out, "")
+}
+
+// 启动 REPL，并在启动时从文件 sessionPath 加载之前保存的会话（如果文件存在），
+// 在执行 ":quit" 命令时把会话（已定义的全局变量及常量）保存到该文件。
+// sessionPath 为空字符串时不加载也不保存会话。
+func StartWithSession(in io.Reader, out io.Writer, sessionPath string) {
+	// REPL 和内置函数 input 从同一个 Reader 逐行读取，
+	// 所以执行 input() 时读取的是 REPL 的下一行输入
+	reader := bufio.NewReader(in)
+	object.SetOutput(out)
+	object.SetInput(reader)
+	s := newSession()
+
+	if sessionPath != "" {
+		loaded, err := loadSession(sessionPath)
+		if err == nil {
+			s = loaded
+		} else if !os.IsNotExist(err) {
+			printError(out, fmt.Sprintf("Loading session failed: %s", err))
+		}
+	}
 
-	scanner := bufio.NewScanner(in)
 	for {
-		fmt.Fprint(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		fmt.Fprint(out, colorize(colorPrompt, PROMPT))
+		line, ok := readLine(reader)
+		if !ok {
 			return
 		}
 
-		line := scanner.Text()
+		if strings.HasPrefix(line, COMMAND_PREFIX) {
+			quit := executeCommand(out, s, line)
+			if quit {
+				if sessionPath != "" {
+					err := saveSession(sessionPath, s)
+					if err != nil {
+						printError(out, fmt.Sprintf("Saving session failed: %s", err))
+					}
+				}
+				return
+			}
+			continue
+		}
+
+		// 输入不完整时继续读取下一行，直到括号全部闭合
+		for isIncomplete(line) {
+			fmt.Fprint(out, colorize(colorPrompt, CONTINUATION_PROMPT))
+			next, ok := readLine(reader)
+			if !ok {
+				return
+			}
+			line += "\n" + next
+		}
+
 		l := lexer.New(line)
 
 		p := parser.New(l)
 		program := p.ParseProgram()
 
 		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+			printParserErrors(out, p.Errors().Strings())
 			continue
 		}
 
-		comp := compiler.NewWithState(symbolTable, constants)
+		// 空行或者只有注释的输入，没有需要执行的语句
+		if len(program.Statements) == 0 {
+			continue
+		}
+
+		comp := compiler.NewWithState(s.symbolTable, s.constants)
 		err := comp.Compile(program)
 		if err != nil {
-			fmt.Fprintf(out, "Compilation failed: %s\n", err)
+			printError(out, fmt.Sprintf("Compilation failed: %s", err))
 			continue
 		}
 
 		code := comp.Bytecode()
-		constants = code.Constants // 更新值
+		s.constants = code.Constants // 更新值
 
-		machine := vm.NewWithGlobalsStore(code, globals)
+		machine := vm.NewWithGlobalsStore(code, s.globals)
 		err = machine.Run()
 		if err != nil {
-			fmt.Fprintf(out, "Executing bytecode failed: %s\n", err)
+			printError(out, fmt.Sprintf("Executing bytecode failed: %s", err))
 			continue
 		}
 
 		// stackTop := machine.StackTop()
 		lastPopped := machine.LastPoppedStackElem()
-		io.WriteString(out, lastPopped.Inspect())
+		if lastPopped == nil { // 还没有任何值被弹出，比如第一个输入是 let 语句
+			continue
+		}
+
+		// 只有最后一条语句是表达式语句时，弹出的值才是这次输入的结果
+		last := program.Statements[len(program.Statements)-1]
+		if _, ok := last.(*ast.ExpressionStatement); ok {
+			s.setLastResult(lastPopped)
+		}
+		// 使用调试用的文本，字符串会被添加双引号并转义控制字符
+		// 内置函数返回的 Error 对象使用错误的颜色
+		resultColor := colorResult
+		if lastPopped.Type() == object.ERROR_OBJ {
+			resultColor = colorError
+		}
+		io.WriteString(out, colorize(resultColor, object.InspectDebug(lastPopped)))
 		io.WriteString(out, "\n")
 	}
 }
 
+// 读取一行输入（不包括行尾的换行符），输入已经结束时返回 false
+// 注：
+// 不使用 bufio.Scanner，因为它会预先读取（缓存）后面的行，而这些行可能需要由 input() 读取
+func readLine(reader *bufio.Reader) (string, bool) {
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false
+	}
+	return strings.TrimRight(line, "\r\n"), true
+}
+
+// 把结果保存到全局变量 `_`（Null 除外），第一次保存时定义该变量。
+// 如果用户已经把 `_` 定义为常量，则不再更新
+func (s *session) setLastResult(result object.Object) {
+	if result == vm.Null {
+		return
+	}
+
+	symbol, ok := s.symbolTable.Resolve(LAST_RESULT_NAME)
+	if !ok {
+		symbol = s.symbolTable.Define(LAST_RESULT_NAME)
+	} else if symbol.Scope != compiler.GlobalScope || symbol.Immutable {
+		return
+	}
+	s.globals[symbol.Index] = result
+}
+
+// 判断输入是否不完整，即存在未闭合的括号 "(", "[", "{"，或者未结束的原始字符串（可以包含换行符）
+// 注：
+// 多余的右括号不视为不完整，而是留给语法分析报告错误
+func isIncomplete(source string) bool {
+	l := lexer.New(source)
+	depth := 0
+	for {
+		tk := l.NextToken()
+		switch tk.Type {
+		case token.LPAREN, token.LBRACKET, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACKET, token.RBRACE:
+			depth--
+		case token.ILLEGAL:
+			if tk.Literal == "unterminated raw string" {
+				return true
+			}
+		case token.EOF:
+			return depth > 0
+		}
+	}
+}
+
+// 执行 REPL 命令，返回 true 表示退出 REPL
+func executeCommand(out io.Writer, s *session, line string) bool {
+	name, argument := line, ""
+	if idx := strings.Index(line, " "); idx >= 0 {
+		name, argument = line[:idx], strings.TrimSpace(line[idx+1:])
+	}
+
+	switch name {
+	case ":dis":
+		disassemble(out, argument)
+	case ":reset":
+		// 丢弃所有已定义的标识符、全局变量的值以及常量
+		*s = *newSession()
+	case ":env":
+		for _, name := range s.symbolTable.DefinedNames() {
+			io.WriteString(out, name+"\n")
+		}
+	case ":quit":
+		return true
+	default:
+		printError(out, fmt.Sprintf("unknown command: %s", name))
+	}
+
+	return false
+}
+
+// 编译源码并输出汇编文本（而不执行）
+// 注：
+// 使用单独的编译器，所以既不能引用会话中定义的标识符，也不会改变会话的状态。
+func disassemble(out io.Writer, source string) {
+	l := lexer.New(source)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		printParserErrors(out, p.Errors().Strings())
+		return
+	}
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		printError(out, fmt.Sprintf("Compilation failed: %s", err))
+		return
+	}
+
+	io.WriteString(out, comp.Bytecode().Instructions.String())
+}
+
 func printParserErrors(out io.Writer, errors []string) {
-	io.WriteString(out, "parser errors:\n")
+	io.WriteString(out, colorize(colorError, "parser errors:")+"\n")
 	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+		io.WriteString(out, "\t"+colorize(colorError, msg)+"\n")
 	}
 }
+
+// 输出一行错误信息
+func printError(out io.Writer, msg string) {
+	io.WriteString(out, colorize(colorError, msg)+"\n")
+}
@@ -0,0 +1,294 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runRepl(input string) string {
+	in := strings.NewReader(input)
+	var out bytes.Buffer
+	Start(in, &out)
+	return out.String()
+}
+
+func TestDisassembleCommand(t *testing.T) {
+	output := runRepl(":dis 1 + 2\n")
+
+	expected := PROMPT +
+		"0000 OpConstant 0\n" +
+		"0003 OpConstant 1\n" +
+		"0006 OpAdd\n" +
+		"0007 OpPop\n" +
+		PROMPT
+
+	if output != expected {
+		t.Fatalf("wrong output, expected %q, actual %q", expected, output)
+	}
+
+	if !strings.Contains(output, "OpAdd") {
+		t.Errorf("output does not contain OpAdd: %q", output)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	output := runRepl(":foo\n")
+
+	if !strings.Contains(output, "unknown command: :foo") {
+		t.Errorf("output does not report the unknown command: %q", output)
+	}
+}
+
+func TestEnvCommand(t *testing.T) {
+	output := runRepl("let b = 1;\nlet a = 2;\n:env\n")
+
+	expected := PROMPT + "1\n" +
+		PROMPT + "2\n" +
+		PROMPT + "b\na\n" +
+		PROMPT
+
+	if output != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, output)
+	}
+}
+
+func TestResetCommand(t *testing.T) {
+	output := runRepl("let a = 1;\n:reset\n:env\na\n")
+
+	expected := PROMPT + "1\n" +
+		PROMPT +
+		PROMPT +
+		PROMPT + "Compilation failed: undefined variable a\n" +
+		PROMPT
+
+	if output != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, output)
+	}
+}
+
+func TestQuitCommand(t *testing.T) {
+	output := runRepl(":quit\n1 + 2\n")
+
+	if output != PROMPT {
+		t.Errorf("REPL did not quit, output %q", output)
+	}
+}
+
+func runReplWithSession(input string, sessionPath string) string {
+	in := strings.NewReader(input)
+	var out bytes.Buffer
+	StartWithSession(in, &out, sessionPath)
+	return out.String()
+}
+
+func TestSaveAndRestoreSession(t *testing.T) {
+	sessionPath := filepath.Join(t.TempDir(), "session.json")
+
+	runReplWithSession(`let x = 5;
+let x = 6;
+let y = [1, "two", true, {"a": 1}];
+let add = fn(a) { fn(b) { a + b + x } };
+let add2 = add(2);
+let l = len;
+:quit
+`, sessionPath)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x", "6"},
+		{"y[1]", `"two"`},
+		{"y[2] == true", "true"},
+		{"y[3][\"a\"]", "1"},
+		{"add2(3)", "11"},
+		{"l(y)", "4"},
+		{"let z = x * 2; z", "12"},
+	}
+
+	for _, test := range tests {
+		output := runReplWithSession(test.input+"\n", sessionPath)
+		expected := PROMPT + test.expected + "\n" + PROMPT
+		if output != expected {
+			t.Errorf("wrong output for %q, expected %q, actual %q",
+				test.input, expected, output)
+		}
+	}
+}
+
+func TestSessionNotSavedWithoutQuit(t *testing.T) {
+	sessionPath := filepath.Join(t.TempDir(), "session.json")
+
+	runReplWithSession("let x = 5;\n", sessionPath)
+	output := runReplWithSession("x\n", sessionPath)
+
+	if !strings.Contains(output, "undefined variable x") {
+		t.Errorf("session unexpectedly restored, output %q", output)
+	}
+}
+
+func TestPutsWritesToReplOutput(t *testing.T) {
+	output := runRepl("puts(\"hi\")\n")
+
+	expected := PROMPT + "hi\nnull\n" + PROMPT
+	if output != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, output)
+	}
+}
+
+func TestInputBuiltin(t *testing.T) {
+	output := runRepl("let x = input();\nhello\nx\ninput(\"name: \")\nworld\n")
+
+	expected := PROMPT + "\"hello\"\n" +
+		PROMPT + "\"hello\"\n" +
+		PROMPT + "name: \"world\"\n" +
+		PROMPT
+	if output != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, output)
+	}
+}
+
+func TestEmptyInput(t *testing.T) {
+	output := runRepl("\n// comment\n1 + 1\n\n")
+
+	expected := PROMPT + PROMPT + PROMPT + "2\n" + PROMPT + PROMPT
+	if output != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, output)
+	}
+}
+
+func TestMultiLineInput(t *testing.T) {
+	output := runRepl("let double = fn(x) {\n  x * 2\n}; double(3)\n[1,\n2][1]\n")
+
+	expected := PROMPT + CONTINUATION_PROMPT + CONTINUATION_PROMPT + "6\n" +
+		PROMPT + CONTINUATION_PROMPT + "2\n" +
+		PROMPT
+	if output != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, output)
+	}
+
+	tests := []struct {
+		input      string
+		incomplete bool
+	}{
+		{"fn(x) {", true},
+		{"fn(x) { x }", false},
+		{"[1, (2", true},
+		{"`raw", true},
+		{`"{"`, false},
+		{"// {", false},
+		{"1 }", false},
+	}
+
+	for _, test := range tests {
+		if isIncomplete(test.input) != test.incomplete {
+			t.Errorf("wrong result for %q, expected %t", test.input, test.incomplete)
+		}
+	}
+}
+
+func TestLastResult(t *testing.T) {
+	output := runRepl("1 + 2\n_ * 10\nlet a = 5;\n_\nputs(1)\n_ + 1\n")
+
+	expected := PROMPT + "3\n" +
+		PROMPT + "30\n" +
+		PROMPT + "5\n" + // let 语句输出的值不是结果，所以 `_` 保持不变
+		PROMPT + "30\n" +
+		PROMPT + "1\nnull\n" + // 结果为 null 时 `_` 保持不变
+		PROMPT + "31\n" +
+		PROMPT
+	if output != expected {
+		t.Errorf("wrong output, expected %q, actual %q", expected, output)
+	}
+
+	// `_` 被定义为常量时不再被更新
+	output = runRepl("const _ = 7;\n1 + 2\n_\n")
+	if !strings.HasSuffix(output, PROMPT+"7\n"+PROMPT) {
+		t.Errorf("constant `_` was overwritten, output %q", output)
+	}
+}
+
+func TestColorOutput(t *testing.T) {
+	input := "1 + 2\nx\nfirst(1)\n"
+
+	SetColor(true)
+	t.Cleanup(func() { SetColor(false) })
+	output := runRepl(input)
+
+	for _, expected := range []string{
+		colorPrompt + PROMPT + colorReset,
+		colorResult + "3" + colorReset,
+		colorError + "Compilation failed: undefined variable x" + colorReset,
+		colorError + "ERROR: argument type to `first` must be ARRAY, actual INTEGER" + colorReset,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("output does not contain %q: %q", expected, output)
+		}
+	}
+
+	SetColor(false)
+	output = runRepl(input)
+	if strings.Contains(output, "\x1b[") {
+		t.Errorf("output contains ANSI codes when color is disabled: %q", output)
+	}
+}
+
+func TestColorSupported(t *testing.T) {
+	// 输出到普通文件（而不是终端）时不支持颜色
+	file, err := os.Create(filepath.Join(t.TempDir(), "out.txt"))
+	if err != nil {
+		t.Fatalf("create file error: %s", err)
+	}
+	defer file.Close()
+
+	t.Setenv("NO_COLOR", "")
+	if ColorSupported(file) {
+		t.Errorf("color should not be supported for a regular file")
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	if ColorSupported(os.Stdout) {
+		t.Errorf("color should not be supported when NO_COLOR is set")
+	}
+}
+
+func TestLoadInvalidSession(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected string
+	}{
+		{`{"numDefinitions": -1}`, "invalid number of globals in session: -1"},
+		{`{"numDefinitions": 70000}`, "invalid number of globals in session: 70000"},
+		{`{"symbols": [{"name": "x", "index": 1}], "numDefinitions": 1}`,
+			"invalid index of global x in session: 1"},
+		{`{"symbols": [{"name": "x", "index": -1}], "numDefinitions": 1}`,
+			"invalid index of global x in session: -1"},
+		{`{"numDefinitions": 1, "globals": [` + strings.Repeat("null,", 65536) + `null]}`,
+			"too many global values in session: 65537"},
+	}
+
+	for _, test := range tests {
+		sessionPath := filepath.Join(t.TempDir(), "session.json")
+		err := os.WriteFile(sessionPath, []byte(test.content), 0644)
+		if err != nil {
+			t.Fatalf("write session failed: %s", err)
+		}
+
+		_, err = loadSession(sessionPath)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("wrong error for %q, expected %q, actual %v", test.content, test.expected, err)
+		}
+	}
+
+	// 加载失败时 REPL 报告错误，然后以空的会话启动
+	sessionPath := filepath.Join(t.TempDir(), "session.json")
+	os.WriteFile(sessionPath, []byte(tests[2].content), 0644)
+	output := runReplWithSession("1\n", sessionPath)
+	if !strings.Contains(output, "Loading session failed: "+tests[2].expected) ||
+		!strings.Contains(output, "1\n") {
+		t.Errorf("wrong output, actual %q", output)
+	}
+}
@@ -0,0 +1,342 @@
+package repl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"toyvm/compiler"
+	"toyvm/object"
+	"toyvm/vm"
+)
+
+// 保存到磁盘的会话（JSON 格式）
+// 包括全局符号、全局变量的值以及常量，
+// 因为已编译的函数的指令是通过索引访问全局变量和常量的，所以这些索引都需要保持不变。
+type sessionFile struct {
+	Symbols        []savedSymbol  `json:"symbols"`
+	NumDefinitions int            `json:"numDefinitions"`
+	Globals        []*savedObject `json:"globals"` // 索引跟全局变量的索引一致，nil 表示未赋值
+	Constants      []*savedObject `json:"constants"`
+}
+
+type savedSymbol struct {
+	Name      string `json:"name"`
+	Index     int    `json:"index"`
+	Immutable bool   `json:"immutable,omitempty"`
+}
+
+// object.Object 的序列化形式，各个字段的使用取决于 Type
+type savedObject struct {
+	Type object.ObjectType `json:"type"`
+
+	Integer int64  `json:"integer,omitempty"`
+	Boolean bool   `json:"boolean,omitempty"`
+	String  string `json:"string,omitempty"` // String 的值、Error 的消息以及内置函数和用户自定义函数的名称
+
+	Elements []*savedObject `json:"elements,omitempty"` // Array 的元素以及 Closure 捕获的变量
+	Pairs    []savedPair    `json:"pairs,omitempty"`
+
+	Instructions  []byte         `json:"instructions,omitempty"`
+	NumLocals     int            `json:"numLocals,omitempty"`
+	NumParameters int            `json:"numParameters,omitempty"`
+	NumDefaults   int            `json:"numDefaults,omitempty"`
+	Function      *savedObject   `json:"function,omitempty"` // Closure 的函数
+	Defaults      []*savedObject `json:"defaults,omitempty"` // Closure 的参数默认值
+}
+
+type savedPair struct {
+	Key   *savedObject `json:"key"`
+	Value *savedObject `json:"value"`
+}
+
+// 把会话保存到文件
+func saveSession(path string, s *session) error {
+	numDefinitions := s.symbolTable.NumDefinitions()
+
+	file := sessionFile{
+		NumDefinitions: numDefinitions,
+		Globals:        make([]*savedObject, numDefinitions),
+		Constants:      make([]*savedObject, len(s.constants)),
+	}
+
+	for _, symbol := range s.symbolTable.DefinedSymbols() {
+		file.Symbols = append(file.Symbols,
+			savedSymbol{Name: symbol.Name, Index: symbol.Index, Immutable: symbol.Immutable})
+	}
+
+	var err error
+	for i := 0; i < numDefinitions; i++ {
+		if s.globals[i] == nil {
+			continue
+		}
+		file.Globals[i], err = encodeObject(s.globals[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, constant := range s.constants {
+		file.Constants[i], err = encodeObject(constant)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// 从文件加载会话
+func loadSession(path string) (*session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file sessionFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	// 会话文件可能被修改过，检查之后再恢复，避免索引越界
+	if file.NumDefinitions < 0 || file.NumDefinitions > vm.GlobalsSize {
+		return nil, fmt.Errorf("invalid number of globals in session: %d", file.NumDefinitions)
+	}
+	if len(file.Globals) > vm.GlobalsSize {
+		return nil, fmt.Errorf("too many global values in session: %d", len(file.Globals))
+	}
+
+	s := newSession()
+
+	symbols := make([]compiler.Symbol, len(file.Symbols))
+	for i, symbol := range file.Symbols {
+		if symbol.Index < 0 || symbol.Index >= file.NumDefinitions {
+			return nil, fmt.Errorf("invalid index of global %s in session: %d", symbol.Name, symbol.Index)
+		}
+		symbols[i] = compiler.Symbol{
+			Name:      symbol.Name,
+			Scope:     compiler.GlobalScope,
+			Index:     symbol.Index,
+			Immutable: symbol.Immutable,
+		}
+	}
+	s.symbolTable.Restore(symbols, file.NumDefinitions)
+
+	for i, global := range file.Globals {
+		if global == nil {
+			continue
+		}
+		s.globals[i], err = decodeObject(global)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, constant := range file.Constants {
+		obj, err := decodeObject(constant)
+		if err != nil {
+			return nil, err
+		}
+		s.constants = append(s.constants, obj)
+	}
+
+	return s, nil
+}
+
+func encodeObject(obj object.Object) (*savedObject, error) {
+	saved := &savedObject{Type: obj.Type()}
+
+	switch obj := obj.(type) {
+	case *object.Integer:
+		saved.Integer = obj.Value
+	case *object.Boolean:
+		saved.Boolean = obj.Value
+	case *object.Null:
+		// 没有值
+	case *object.String:
+		saved.String = obj.Value
+	case *object.Error:
+		saved.String = obj.Message
+
+	case *object.Array:
+		elements, err := encodeObjects(obj.Elements)
+		if err != nil {
+			return nil, err
+		}
+		saved.Elements = elements
+
+	case *object.Hash:
+		for _, pair := range obj.OrderedPairs() {
+			key, err := encodeObject(pair.Key)
+			if err != nil {
+				return nil, err
+			}
+			value, err := encodeObject(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			saved.Pairs = append(saved.Pairs, savedPair{Key: key, Value: value})
+		}
+
+	case *object.CompiledFunction:
+		saved.String = obj.Name
+		saved.Instructions = obj.Instructions
+		saved.NumLocals = obj.NumLocals
+		saved.NumParameters = obj.NumParameters
+		saved.NumDefaults = obj.NumDefaults
+
+	case *object.Closure:
+		fn, err := encodeObject(obj.Fn)
+		if err != nil {
+			return nil, err
+		}
+		free, err := encodeObjects(obj.Free)
+		if err != nil {
+			return nil, err
+		}
+		defaults, err := encodeObjects(obj.Defaults)
+		if err != nil {
+			return nil, err
+		}
+		saved.Function = fn
+		saved.Elements = free
+		saved.Defaults = defaults
+
+	case *object.Builtin:
+		// 内置函数只保存名称
+		name := ""
+		for _, def := range object.Builtins {
+			if def.Builtin == obj {
+				name = def.Name
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("unknown builtin function")
+		}
+		saved.String = name
+
+	default:
+		return nil, fmt.Errorf("cannot save object of type %s", obj.Type())
+	}
+
+	return saved, nil
+}
+
+func encodeObjects(objs []object.Object) ([]*savedObject, error) {
+	saved := make([]*savedObject, len(objs))
+	for i, obj := range objs {
+		var err error
+		saved[i], err = encodeObject(obj)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return saved, nil
+}
+
+func decodeObject(saved *savedObject) (object.Object, error) {
+	switch saved.Type {
+	case object.INTEGER_OBJ:
+		return &object.Integer{Value: saved.Integer}, nil
+
+	case object.BOOLEAN_OBJ:
+		// VM 通过比较指针判断 Boolean 和 Null，所以需要还原为 VM 的常量
+		if saved.Boolean {
+			return vm.True, nil
+		}
+		return vm.False, nil
+
+	case object.NULL_OBJ:
+		return vm.Null, nil
+	case object.STRING_OBJ:
+		return &object.String{Value: saved.String}, nil
+	case object.ERROR_OBJ:
+		return &object.Error{Message: saved.String}, nil
+
+	case object.ARRAY_OBJ:
+		elements, err := decodeObjects(saved.Elements)
+		if err != nil {
+			return nil, err
+		}
+		return &object.Array{Elements: elements}, nil
+
+	case object.HASH_OBJ:
+		hash := object.NewHash()
+		for _, pair := range saved.Pairs {
+			key, err := decodeObject(pair.Key)
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeObject(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+
+			hashKey, ok := key.(object.Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			hash.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
+		}
+		return hash, nil
+
+	case object.COMPILED_FUNCTION_OBJ:
+		return &object.CompiledFunction{
+			Name:          saved.String,
+			Instructions:  saved.Instructions,
+			NumLocals:     saved.NumLocals,
+			NumParameters: saved.NumParameters,
+			NumDefaults:   saved.NumDefaults,
+		}, nil
+
+	case object.CLOSURE_OBJ:
+		if saved.Function == nil {
+			return nil, fmt.Errorf("closure without function")
+		}
+		fn, err := decodeObject(saved.Function)
+		if err != nil {
+			return nil, err
+		}
+		compiledFn, ok := fn.(*object.CompiledFunction)
+		if !ok {
+			return nil, fmt.Errorf("closure function is not %s, actual %s",
+				object.COMPILED_FUNCTION_OBJ, fn.Type())
+		}
+		free, err := decodeObjects(saved.Elements)
+		if err != nil {
+			return nil, err
+		}
+		defaults, err := decodeObjects(saved.Defaults)
+		if err != nil {
+			return nil, err
+		}
+		return &object.Closure{Fn: compiledFn, Free: free, Defaults: defaults}, nil
+
+	case object.BUILTIN_OBJ:
+		builtin := object.GetBuiltinByName(saved.String)
+		if builtin == nil {
+			return nil, fmt.Errorf("unknown builtin function: %s", saved.String)
+		}
+		return builtin, nil
+
+	default:
+		return nil, fmt.Errorf("cannot load object of type %s", saved.Type)
+	}
+}
+
+func decodeObjects(saved []*savedObject) ([]object.Object, error) {
+	objs := make([]object.Object, len(saved))
+	for i, s := range saved {
+		var err error
+		objs[i], err = decodeObject(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
@@ -7,6 +7,8 @@ type TokenType string
 type Token struct {
 	Type    TokenType // token 的类型
 	Literal string    // token 的值
+	Line    int       // token 第一个字符所在的行（从 1 开始）
+	Column  int       // token 第一个字符所在的列（从 1 开始）
 }
 
 // token 的类型
@@ -25,9 +27,16 @@ const (
 	MINUS    = "-"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
 
 	BANG = "!"
 
+	// 复合赋值
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+
 	LT = "<"
 	GT = ">"
 
@@ -37,6 +46,10 @@ const (
 	AND = "&&"
 	OR  = "||"
 
+	QUESTION = "?" // 条件表达式 `a ? b : c`
+
+	ARROW = "=>" // match 表达式的分支 `pattern => value`
+
 	// 分隔符
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -53,11 +66,17 @@ const (
 	// 关键字
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
+	LETREC   = "LETREC"
+	CONST    = "CONST"
 
 	IF     = "IF"
 	ELSE   = "ELSE"
 	RETURN = "RETURN"
 
+	TRY   = "TRY"
+	CATCH = "CATCH"
+	MATCH = "MATCH"
+
 	TRUE  = "TRUE"
 	FALSE = "FALSE"
 )
@@ -65,9 +84,14 @@ const (
 var keywords = map[string]TokenType{
 	"fn":     FUNCTION,
 	"let":    LET,
+	"letrec": LETREC,
+	"const":  CONST,
 	"if":     IF,
 	"else":   ELSE,
 	"return": RETURN,
+	"try":    TRY,
+	"catch":  CATCH,
+	"match":  MATCH,
 
 	"true":  TRUE,
 	"false": FALSE,
@@ -1,6 +1,8 @@
 package vm
 
 import (
+	"fmt"
+	"strings"
 	"toyvm/code"
 	"toyvm/object"
 )
@@ -27,6 +29,77 @@ func NewFrame(
 	}
 }
 
+// 重置调用帧，用于重新使用已经弹出的调用帧
+func (f *Frame) reset(cl *object.Closure, basePointer int) {
+	f.cl = cl
+	f.ip = -1
+	f.basePointer = basePointer
+}
+
 func (f *Frame) Instructions() code.Instructions {
 	return f.cl.Fn.Instructions
 }
+
+// 调用帧的摘要，用于调试
+// 注：
+// ip 是最后一条已执行（或者正在执行）的指令的位置，刚创建的调用帧的 ip 为 -1
+func (f *Frame) String() string {
+	return fmt.Sprintf("Frame{ip: %d, basePointer: %d, numLocals: %d}",
+		f.ip, f.basePointer, f.cl.Fn.NumLocals)
+}
+
+// 运行时错误的调用栈当中的一项
+type StackFrame struct {
+	Function string // 函数的名称，最外层的程序为 "<main>"，匿名函数为 "<anonymous>"
+	Line     int    // 出错（或者调用下一层函数）时所在的源码行号，没有记录时为 0
+}
+
+func (sf StackFrame) String() string {
+	return fmt.Sprintf("at %s (line %d)", sf.Function, sf.Line)
+}
+
+// 运行时错误，包括发生错误时的调用栈（类似 Go 的 stack trace）
+type RuntimeError struct {
+	Message string
+	Frames  []StackFrame // 从发生错误的函数开始，一直到最外层的程序
+}
+
+// 只返回错误信息（不包括调用栈），以保持以前的输出格式
+func (e *RuntimeError) Error() string {
+	return e.Message
+}
+
+// 返回调用栈的文本，每个调用帧一行
+func (e *RuntimeError) StackTrace() string {
+	var out strings.Builder
+	for _, frame := range e.Frames {
+		out.WriteString("\t")
+		out.WriteString(frame.String())
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// 返回包括调用栈的错误信息
+func (e *RuntimeError) String() string {
+	return e.Message + "\n" + e.StackTrace()
+}
+
+// 返回调用帧所对应的函数名称以及当前指令所在的源码行号
+func (f *Frame) stackFrame(isMain bool) StackFrame {
+	name := f.cl.Fn.Name
+	if isMain {
+		name = "<main>"
+	} else if name == "" {
+		name = "<anonymous>"
+	}
+
+	// 注：
+	// ip 指向当前（或者最后执行的）指令，
+	// 对于调用者的调用帧，则是 OpCall 指令
+	ip := f.ip
+	if ip < 0 {
+		ip = 0
+	}
+	return StackFrame{Function: name, Line: f.cl.Fn.Lines.Line(ip)}
+}
@@ -2,6 +2,7 @@ package vm
 
 import (
 	"fmt"
+	"strings"
 	"toyvm/code"
 	"toyvm/compiler"
 	"toyvm/object"
@@ -11,9 +12,9 @@ const StackSize = 2048    // 运算栈容量
 const GlobalsSize = 65536 // 符号容量
 const MaxFrames = 1024    // 调用栈的容量
 
-var True = &object.Boolean{Value: true}   // Object 常量
-var False = &object.Boolean{Value: false} // Object 常量
-var Null = &object.Null{}                 // Object 常量
+var True = object.TRUE   // Object 常量
+var False = object.FALSE // Object 常量
+var Null = object.NULL   // Object 常量
 
 type VM struct {
 	constants []object.Object
@@ -33,11 +34,60 @@ type VM struct {
 
 	frames     []*Frame // 调用帧列表
 	frameIndex int      // 调用帧的数量，准确名称是 frameCount
+
+	// 当前调用帧（或者其执行的函数）是否已经改变，比如函数调用和返回，
+	// Run 据此决定是否需要重新获取当前调用帧及其指令
+	frameChanged bool
+
+	// 是否在运行时检测尾调用（tail call），见 SetTailCall
+	tailCall bool
+
+	// 运行过程中调用帧数量的最大值，用于观察调用栈的使用情况
+	maxFrameIndex int
+
+	// 是否统计每种指令的执行次数，见 SetProfiling
+	profiling    bool
+	opcodeCounts map[code.Opcode]int
+
+	// 是否把内置函数返回的所有 Error 都作为运行时错误，见 SetStrictErrors
+	strictErrors bool
+
+	// 整数除法和取余是否向负无穷取整，见 SetFloorDivision
+	floorDivision bool
+
+	// 空的 Array, Hash 和 String 是否视为 false，见 SetEmptyFalsy
+	emptyFalsy bool
+
+	// 程序是否已经通过 OpHalt（即 `exit()`）结束
+	exited bool
+
+	// 断点，见 SetBreakpoint
+	breakpoints map[breakpoint]bool
+
+	// 异常处理器栈，由 OpSetHandler 压入，OpPopHandler 弹出，见 handleError
+	handlers []handler
+}
+
+// 异常处理器，即 try 语句块执行时的状态
+type handler struct {
+	frameIndex int // 设置处理器时调用帧的数量
+	sp         int // 设置处理器时运算栈的栈顶位置
+	catchPos   int // catch 语句块的位置
 }
 
+// 断点的位置，即某个函数里的某条指令
+type breakpoint struct {
+	fn     *object.CompiledFunction
+	offset int
+}
+
+// 用于 SetBreakpoint，表示 main 函数（即最外层的程序）
+const MainFunction = -1
+
 func New(bytecode *compiler.Bytecode) *VM {
 	mainFn := &object.CompiledFunction{
 		Instructions: bytecode.Instructions,
+		Lines:        bytecode.Lines,
 	}
 	mainClosure := &object.Closure{Fn: mainFn} // ++
 	// mainFrame := NewFrame(mainFn, 0)
@@ -56,7 +106,28 @@ func New(bytecode *compiler.Bytecode) *VM {
 
 		frames:     frames,
 		frameIndex: 1, // 调用帧的数量，准确名称是 frameCount
+
+		maxFrameIndex: 1,
+	}
+}
+
+// 编译并执行源码，返回最后一个被弹出运算栈的值（即最后一条表达式语句的值）
+// 解析、编译以及执行时的错误都作为 error 返回
+func RunSource(src string) (object.Object, error) {
+	bytecode, parserErrors, err := compiler.CompileSource(src)
+	if len(parserErrors) != 0 {
+		return nil, fmt.Errorf("parser errors: %s", strings.Join(parserErrors, "; "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("compilation failed: %s", err)
+	}
+
+	vm := New(bytecode)
+	err = vm.Run()
+	if err != nil {
+		return nil, fmt.Errorf("executing bytecode failed: %s", err)
 	}
+	return vm.LastPoppedStackElem(), nil
 }
 
 func NewWithGlobalsStore(
@@ -82,266 +153,683 @@ func (vm *VM) currentFrame() *Frame {
 func (vm *VM) pushFrame(f *Frame) {
 	vm.frames[vm.frameIndex] = f
 	vm.frameIndex++
+	vm.frameChanged = true
+
+	if vm.frameIndex > vm.maxFrameIndex {
+		vm.maxFrameIndex = vm.frameIndex
+	}
+}
+
+// 创建一个用于压入调用帧列表的调用帧
+// 注：
+// popFrame 只是减少 frameIndex，被弹出的调用帧仍然留在 frames 里，
+// 所以 frames[frameIndex] 如果不为 nil，则是一个不再使用的调用帧，
+// 可以直接重置并重新使用，以减少函数调用时的内存分配。
+// popFrame 返回的调用帧只能在下一次函数调用之前使用。
+func (vm *VM) newFrame(cl *object.Closure, basePointer int) *Frame {
+	frame := vm.frames[vm.frameIndex]
+	if frame == nil {
+		return NewFrame(cl, basePointer)
+	}
+
+	frame.reset(cl, basePointer)
+	return frame
 }
 
 func (vm *VM) popFrame() *Frame {
 	vm.frameIndex--
+	vm.frameChanged = true
+	vm.discardHandlers()
 	return vm.frames[vm.frameIndex]
 }
 
-func (vm *VM) Run() error {
-	var ip int
-	var ins code.Instructions
-	var op code.Opcode
+// 丢弃属于已经返回的调用帧的异常处理器（比如在 try 语句块里 return）
+func (vm *VM) discardHandlers() {
+	for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frameIndex > vm.frameIndex {
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	}
+}
+
+// 把运行时错误交给最近的异常处理器处理：
+// 恢复设置处理器时的调用帧和运算栈，把错误对象压入栈，然后跳转到 catch 语句块。
+// 只处理调用帧数量大于 minFrameIndex 的处理器（用于回调函数，见 callFunction），
+// 致命错误（比如 panic 和 exit）不会被处理。
+// 返回错误是否已经被处理
+func (vm *VM) handleError(err error, minFrameIndex int) bool {
+	if len(vm.handlers) == 0 {
+		return false
+	}
+
+	h := vm.handlers[len(vm.handlers)-1]
+	if h.frameIndex <= minFrameIndex {
+		return false
+	}
+
+	if errObj, ok := err.(*object.Error); ok && errObj.Fatal {
+		return false
+	}
+
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	vm.frameIndex = h.frameIndex
+	vm.frameChanged = true
+	vm.sp = h.sp
+	vm.currentFrame().ip = h.catchPos - 1 // 因为 step() 会先 +1，所以 pos 需要 - 1
+
+	return vm.push(&object.Error{Message: err.Error()}) == nil
+}
+
+// 设置是否在运行时检测尾调用
+// 开启之后，如果 OpCall 的下一条指令是 OpReturnValue（即函数调用的结果直接被返回），
+// 则被调用的函数会复用当前的调用帧，而不是压入新的调用帧，
+// 这样尾递归函数的递归深度就不再受 MaxFrames 的限制。
+// 注：
+// 这是一种运行时的启发式检测，只能识别 OpCall 紧接着 OpReturnValue 的情况，
+// 比如 `return f(x);`、函数体最后的 `f(x)` 以及 if 表达式 else 分支最后的 `f(x)`；
+// 而 if 表达式 consequence 分支最后的 `f(x)` 后面紧接的是 OpJump，所以不会被识别。
+// 另外只对用户自定义函数（闭包）有效，且不会替换最外层（main）的调用帧。
+func (vm *VM) SetTailCall(enabled bool) {
+	vm.tailCall = enabled
+}
+
+// 设置整数除法（OpDiv）和取余（OpMod）的取整方式
+// 默认跟 Go 一样向零取整，比如 `-7 / 2` 为 -3，`-7 % 2` 为 -1（余数的符号跟被除数相同）；
+// 开启之后向负无穷取整（跟 Python 一样），比如 `-7 / 2` 为 -4，`-7 % 2` 为 1（余数的符号跟除数相同）。
+// 两种方式都满足 `a == (a / b) * b + a % b`。
+func (vm *VM) SetFloorDivision(enabled bool) {
+	vm.floorDivision = enabled
+}
+
+// 设置空的 Array, Hash 和 String 是否视为 false
+// 默认只有 false 和 null 视为 false，其他值（包括 `[]`, `{}` 和 `""`）都视为 true；
+// 开启之后空的 Array, Hash 和 String 也视为 false，比如 `if (arr) {...}` 只在数组非空时执行。
+// 条件判断（if, ?: 以及 &&）和 `!` 运算都使用这个规则。
+func (vm *VM) SetEmptyFalsy(enabled bool) {
+	vm.emptyFalsy = enabled
+}
+
+// 设置是否统计每种指令的执行次数
+// 为了避免影响正常运行时的性能，默认不统计
+func (vm *VM) SetProfiling(enabled bool) {
+	vm.profiling = enabled
+	if enabled && vm.opcodeCounts == nil {
+		vm.opcodeCounts = make(map[code.Opcode]int)
+	}
+}
+
+// 设置是否以严格模式处理内置函数返回的错误
+// 默认情况下，内置函数返回的 Error 对象（比如 `len(1)` 的结果）会被当作普通的值压入运算栈，
+// 程序会继续执行；开启之后，所有 Error 对象都会中止 Run 并作为错误返回。
+// 注：
+// 致命错误（比如 panic 和 assert 产生的错误）无论是否开启都会中止执行。
+func (vm *VM) SetStrictErrors(enabled bool) {
+	vm.strictErrors = enabled
+}
+
+// 返回每种指令的执行次数，需要先调用 SetProfiling(true) 开启统计
+func (vm *VM) OpcodeCounts() map[code.Opcode]int {
+	return vm.opcodeCounts
+}
+
+// 反汇编当前调用帧的函数的指令，并用 ">" 标记下一条将要执行的指令
+// 注：
+// 因为 ip 指向的是上一条已执行指令（的最后一个字节），所以下一条指令位于 ip + 1
+func (vm *VM) CurrentFrameDisassembly() string {
+	frame := vm.currentFrame()
+	return frame.Instructions().StringWithMarker(frame.ip + 1)
+}
+
+// 程序是否已经执行完毕，即 main 调用帧的所有指令都已执行
+func (vm *VM) halted() bool {
+	if vm.exited {
+		return true
+	}
+	if vm.frameIndex != 1 {
+		return false
+	}
+	frame := vm.frames[0]
+	return frame.ip >= len(frame.cl.Fn.Instructions)-1
+}
+
+// 结束整个程序，即执行 `exit(result)`
+// 结果留在栈底（即 LastPoppedStackElem），调用帧保持不变，
+// 之后 halted() 总是返回 true
+func (vm *VM) halt(result object.Object) {
+	vm.sp = 0
+	vm.stack[0] = result
+	vm.exited = true
+}
+
+// 执行一条指令，用于调试
+// 返回的 halted 为 true 表示程序已经执行完毕（此时不再执行任何指令）
+func (vm *VM) Step() (halted bool, err error) {
+	if vm.halted() {
+		return true, nil
+	}
+
+	err = vm.step()
+	if err != nil && !vm.handleError(err, 0) {
+		return false, vm.newRuntimeError(err)
+	}
+	return vm.halted(), nil
+}
+
+// 返回运算栈当前的内容（的副本），从栈底到栈顶排列
+func (vm *VM) StackSnapshot() []object.Object {
+	snapshot := make([]object.Object, vm.sp)
+	copy(snapshot, vm.stack[:vm.sp])
+	return snapshot
+}
+
+// 返回当前调用帧的局部变量（的副本）
+// 注：
+// 尚未赋值的局部变量的值是不确定的（nil 或者运算栈之前残留的值）
+func (vm *VM) CurrentLocals() []object.Object {
+	return vm.LocalsOf(vm.currentFrame())
+}
+
+// 返回指定调用帧的局部变量（的副本），即运算栈 [bp, bp + NumLocals) 之间的值
+func (vm *VM) LocalsOf(frame *Frame) []object.Object {
+	numLocals := frame.cl.Fn.NumLocals
+	locals := make([]object.Object, numLocals)
+	copy(locals, vm.stack[frame.basePointer:frame.basePointer+numLocals])
+	return locals
+}
+
+// 返回当前的调用帧列表，第一个是 main 调用帧，最后一个是当前调用帧
+func (vm *VM) Frames() []*Frame {
+	frames := make([]*Frame, vm.frameIndex)
+	copy(frames, vm.frames[:vm.frameIndex])
+	return frames
+}
+
+// 返回当前调用帧的深度（main 调用帧为 1）以及下一条将要执行的指令的位置
+func (vm *VM) CurrentPosition() (depth int, offset int) {
+	return vm.frameIndex, vm.currentFrame().ip + 1
+}
+
+// 返回下一条将要执行的指令的操作码和操作数
+// 程序已经执行完毕时，返回的操作数为 nil（此时操作码没有意义）
+func (vm *VM) CurrentInstruction() (code.Opcode, []int) {
+	if vm.halted() {
+		return 0, nil
+	}
+
+	frame := vm.currentFrame()
+	ins := frame.Instructions()
+	offset := frame.ip + 1
+
+	op := code.Opcode(ins[offset])
+	def, err := code.Lookup(byte(op))
+	if err != nil {
+		return op, nil
+	}
+
+	operands, _ := code.ReadOperands(def, ins[offset+1:])
+	return op, operands
+}
+
+// 在函数的指定位置设置断点
+// fnConstantIndex 是函数（CompiledFunction）在常量池里的索引，MainFunction 表示 main 函数；
+// offset 是指令在函数的指令序列里的位置（即反汇编文本里每一行开头的数字）。
+func (vm *VM) SetBreakpoint(fnConstantIndex int, offset int) error {
+	var fn *object.CompiledFunction
+	if fnConstantIndex == MainFunction {
+		fn = vm.frames[0].cl.Fn
+	} else {
+		if fnConstantIndex < 0 || fnConstantIndex >= len(vm.constants) {
+			return fmt.Errorf("constant index out of range: %d", fnConstantIndex)
+		}
+
+		compiledFn, ok := vm.constants[fnConstantIndex].(*object.CompiledFunction)
+		if !ok {
+			return fmt.Errorf("constant %d is not a function, actual %s",
+				fnConstantIndex, vm.constants[fnConstantIndex].Type())
+		}
+		fn = compiledFn
+	}
+
+	if vm.breakpoints == nil {
+		vm.breakpoints = make(map[breakpoint]bool)
+	}
+	vm.breakpoints[breakpoint{fn: fn, offset: offset}] = true
+	return nil
+}
+
+// 下一条将要执行的指令是否设置了断点
+func (vm *VM) atBreakpoint() bool {
+	frame := vm.currentFrame()
+	return vm.breakpoints[breakpoint{fn: frame.cl.Fn, offset: frame.ip + 1}]
+}
+
+// 执行指令，直到下一条将要执行的指令设置了断点，或者程序执行完毕
+// 返回的 hit 为 true 表示停在了断点处，此时断点所在的指令尚未执行。
+// 注：
+// 至少会执行一条指令，以便从当前所在的断点继续执行
+func (vm *VM) RunToBreakpoint() (hit bool, err error) {
+	for {
+		halted, err := vm.Step()
+		if err != nil {
+			return false, err
+		}
+		if halted {
+			return false, nil
+		}
+		if vm.atBreakpoint() {
+			return true, nil
+		}
+	}
+}
 
+func (vm *VM) Run() error {
 	// for ip := 0; ip < len(vm.instructions); ip++ {
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
-		vm.currentFrame().ip++
+	// for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+	// 跟反复调用 Step() 的效果相同，但每条指令只检查一次程序是否执行完毕，
+	// 而且当前调用帧及其指令只在调用帧改变之后（比如函数调用和返回）才重新获取
+	frame := vm.currentFrame()
+	ins := frame.Instructions()
+	vm.frameChanged = false
+
+	for !vm.halted() {
+		err := vm.execute(frame, ins)
+		if err != nil && !vm.handleError(err, 0) {
+			return vm.newRuntimeError(err)
+		}
 
-		// fetch
-		ip = vm.currentFrame().ip
-		ins = vm.currentFrame().Instructions()
-		op = code.Opcode(ins[ip])
-		// op := code.Opcode(vm.instructions[ip])
+		if vm.frameChanged {
+			frame = vm.currentFrame()
+			ins = frame.Instructions()
+			vm.frameChanged = false
+		}
+	}
+	return nil
+}
 
-		// decode
-		switch op {
+// 把指令执行时的错误包装为 RuntimeError，并且记录当前的调用栈
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	if runtimeErr, ok := err.(*RuntimeError); ok {
+		return runtimeErr
+	}
 
-		// 从 global 读取常量，并压入运算栈
-		case code.OpConstant:
-			constIndex := code.ReadUint16(ins[ip+1:]) // code.ReadUint16(vm.instructions[ip+1:])
-			vm.currentFrame().ip += 2                 // ip += 2
+	frames := make([]StackFrame, 0, vm.frameIndex)
+	for i := vm.frameIndex - 1; i >= 0; i-- {
+		frames = append(frames, vm.frames[i].stackFrame(i == 0))
+	}
+	return &RuntimeError{Message: err.Error(), Frames: frames}
+}
 
-			// execute
-			err := vm.push(vm.constants[constIndex])
-			if err != nil {
-				return err
-			}
+// 执行当前调用帧的下一条指令，用于单步执行（Step）以及在内置函数里回调闭包
+func (vm *VM) step() error {
+	frame := vm.currentFrame()
+	return vm.execute(frame, frame.Instructions())
+}
 
-		// 从 global 读取（带闭包的）函数字面量，并压入运算栈
-		case code.OpClosure:
-			constIndex := code.ReadUint16(ins[ip+1:]) // 函数字面量的位置
-			numFree := code.ReadUint8(ins[ip+3:])     // 函数捕获局部变量的数量
-			vm.currentFrame().ip += 3
+// 执行调用帧 frame 的下一条指令，frame 必须是当前调用帧，ins 是它的指令
+// 注：
+// 当前调用帧在执行一条指令的过程中保持不变（除了函数调用和返回指令），
+// 所以由调用者获取之后传入，而不是在每次读取操作数时调用 currentFrame()
+func (vm *VM) execute(frame *Frame, ins code.Instructions) error {
+	frame.ip++
 
-			err := vm.pushClosure(int(constIndex), int(numFree))
-			if err != nil {
-				return nil
-			}
+	// fetch
+	ip := frame.ip
+	op := code.Opcode(ins[ip])
+	// op := code.Opcode(vm.instructions[ip])
 
-		case code.OpCurrentClosure:
-			currentClosure := vm.currentFrame().cl
-			err := vm.push(currentClosure)
-			if err != nil {
-				return err
-			}
+	if vm.profiling {
+		vm.opcodeCounts[op]++
+	}
 
-		// 弹出栈顶的最后一个值，用于清理语句执行后的 stack
-		case code.OpPop:
-			vm.pop()
+	// decode
+	switch op {
 
-		// 条件跳转（false 时跳转）
-		case code.OpJumpNotTruthy:
-			pos := int(code.ReadUint16(ins[ip+1:])) // int(code.ReadUint16(vm.instructions[ip+1:]))
-			// ip += 2                                 // 因为 OpJumpNotTruthy 指令一共 3 个字节，另外 for 循环会 +1，所以下一条指令的位置是 ip + 3 - 1
-			vm.currentFrame().ip += 2
+	// 从 global 读取常量，并压入运算栈
+	case code.OpConstant:
+		constIndex := code.ReadUint16(ins[ip+1:]) // code.ReadUint16(vm.instructions[ip+1:])
+		frame.ip += 2                             // ip += 2
 
-			condition := vm.pop()
-			if !isTruthy(condition) {
-				// ip = pos - 1 // 因为 for 循环会 +1，所以 pos 需要 - 1
-				vm.currentFrame().ip = pos - 1
-			}
+		// execute
+		err := vm.push(vm.constants[constIndex])
+		if err != nil {
+			return err
+		}
 
-		// 无条件跳转
-		case code.OpJump:
-			pos := int(code.ReadUint16(ins[ip+1:])) // int(code.ReadUint16(vm.instructions[ip+1:]))
-			// ip = pos - 1                            // 因为 for 循环会 +1，所以 pos 需要 - 1
-			vm.currentFrame().ip = pos - 1
+	// 从 global 读取（带闭包的）函数字面量，并压入运算栈
+	case code.OpClosure:
+		constIndex := code.ReadUint16(ins[ip+1:]) // 函数字面量的位置
+		numFree := code.ReadUint8(ins[ip+3:])     // 函数捕获局部变量的数量
+		frame.ip += 3
 
-		// 函数调用
-		case code.OpCall:
-			numArgs := code.ReadUint8(ins[ip+1:]) // 参数的数量
-			vm.currentFrame().ip += 1
+		err := vm.pushClosure(int(constIndex), int(numFree))
+		if err != nil {
+			return nil
+		}
 
-			// err := vm.callFunction(int(numArgs)) // **
-			err := vm.executeCall(int(numArgs))
-			if err != nil {
-				return err
-			}
+	// 变量的值 +1 或者 -1
+	case code.OpIncrementGlobal, code.OpDecrementGlobal:
+		globalIndex := code.ReadUint16(ins[ip+1:])
+		frame.ip += 2
 
-		case code.OpReturnValue:
-			returnValue := vm.pop()
+		result, err := vm.executeIncrement(vm.globals[globalIndex], op == code.OpIncrementGlobal)
+		if err != nil {
+			return err
+		}
+		vm.globals[globalIndex] = result
 
-			// vm.popFrame()
-			// vm.pop()
-			frame := vm.popFrame()
+		err = vm.push(result)
+		if err != nil {
+			return err
+		}
 
-			// 重置 sp 为 frame.basePointer，用于清除保留局部变量空间
-			// `- 1` 相当于 pop() 了一次
-			vm.sp = frame.basePointer - 1
+	case code.OpIncrementLocal, code.OpDecrementLocal:
+		localIndex := code.ReadUint8(ins[ip+1:])
+		frame.ip += 1
 
-			err := vm.push(returnValue)
-			if err != nil {
-				return err
-			}
+		err := checkLocalIndex(frame, int(localIndex))
+		if err != nil {
+			return err
+		}
 
-		case code.OpReturn:
-			vm.popFrame()
-			vm.pop()
+		slot := frame.basePointer + int(localIndex)
+		result, err := vm.executeIncrement(vm.stack[slot], op == code.OpIncrementLocal)
+		if err != nil {
+			return err
+		}
+		vm.stack[slot] = result
 
-			err := vm.push(Null)
-			if err != nil {
-				return err
-			}
+		err = vm.push(result)
+		if err != nil {
+			return err
+		}
 
-		case code.OpSetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+	// 设置异常处理器
+	case code.OpSetHandler:
+		catchPos := int(code.ReadUint16(ins[ip+1:]))
+		frame.ip += 2
+
+		vm.handlers = append(vm.handlers, handler{
+			frameIndex: vm.frameIndex,
+			sp:         vm.sp,
+			catchPos:   catchPos,
+		})
+
+	// 移除异常处理器
+	case code.OpPopHandler:
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	case code.OpCurrentClosure:
+		currentClosure := frame.cl
+		err := vm.push(currentClosure)
+		if err != nil {
+			return err
+		}
 
-			frame := vm.currentFrame()
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop() // 通过 “帧指针+偏移值” 计算出局部变量的位置
+	// 弹出栈顶的最后一个值，用于清理语句执行后的 stack
+	case code.OpPop:
+		// 编译器只在产生值的表达式语句之后生成 OpPop，
+		// 运算栈为空说明指令有误，返回错误而不是越界访问运算栈
+		if vm.sp == 0 {
+			return fmt.Errorf("stack underflow: OpPop requires 1 element")
+		}
+		vm.pop()
 
-		case code.OpGetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+	case code.OpDup:
+		if vm.sp == 0 {
+			return fmt.Errorf("stack underflow: OpDup requires 1 element")
+		}
+		err := vm.push(vm.stack[vm.sp-1])
+		if err != nil {
+			return err
+		}
 
-			frame := vm.currentFrame()
+	case code.OpSwap:
+		if vm.sp < 2 {
+			return fmt.Errorf("stack underflow: OpSwap requires 2 elements")
+		}
+		vm.stack[vm.sp-1], vm.stack[vm.sp-2] = vm.stack[vm.sp-2], vm.stack[vm.sp-1]
+
+	// 条件跳转（false 时跳转）
+	case code.OpJumpNotTruthy:
+		pos := int(code.ReadUint16(ins[ip+1:])) // int(code.ReadUint16(vm.instructions[ip+1:]))
+		// ip += 2                                 // 因为 OpJumpNotTruthy 指令一共 3 个字节，另外 for 循环会 +1，所以下一条指令的位置是 ip + 3 - 1
+		frame.ip += 2
+
+		condition := vm.pop()
+		if !vm.isTruthy(condition) {
+			// ip = pos - 1 // 因为 for 循环会 +1，所以 pos 需要 - 1
+			frame.ip = pos - 1
+		}
 
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
-			if err != nil {
-				return err
-			}
+	// 无条件跳转
+	case code.OpJump:
+		pos := int(code.ReadUint16(ins[ip+1:])) // int(code.ReadUint16(vm.instructions[ip+1:]))
+		// ip = pos - 1                            // 因为 for 循环会 +1，所以 pos 需要 - 1
+		frame.ip = pos - 1
 
-		case code.OpGetFree:
-			freeIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+	// 函数调用
+	case code.OpCall:
+		numArgs := code.ReadUint8(ins[ip+1:]) // 参数的数量
+		frame.ip += 1
 
-			currentClosure := vm.currentFrame().cl
-			err := vm.push(currentClosure.Free[freeIndex])
+		if vm.tailCall && vm.isTailCall(int(numArgs)) {
+			err := vm.tailCallClosure(int(numArgs))
 			if err != nil {
 				return err
 			}
+			break
+		}
 
-		// 加减乘除运算
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
-			err := vm.executeBinaryOperation(op)
-			if err != nil {
-				return err
-			}
+		// err := vm.callFunction(int(numArgs)) // **
+		err := vm.executeCall(int(numArgs))
+		if err != nil {
+			return err
+		}
 
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
-			err := vm.executeComparison(op)
-			if err != nil {
-				return err
-			}
+	case code.OpReturnValue:
+		returnValue := vm.pop()
 
-		// 标识符操作
-		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:]) // code.ReadUint16(vm.instructions[ip+1:])
-			// ip += 2
-			vm.currentFrame().ip += 2
+		// 顶层（即 main 函数里）的 return 语句结束整个程序，
+		// 返回值留在栈顶之上（即 LastPoppedStackElem）作为程序的结果
+		if vm.frameIndex == 1 {
+			vm.stack[vm.sp] = returnValue
+			frame.ip = len(ins) - 1
+			return nil
+		}
 
-			vm.globals[globalIndex] = vm.pop()
+		// vm.popFrame()
+		// vm.pop()
+		vm.popFrame()
 
-		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:]) // code.ReadUint16(vm.instructions[ip+1:])
-			// ip += 2
-			vm.currentFrame().ip += 2
+		// 重置 sp 为 frame.basePointer，用于清除保留局部变量空间
+		// `- 1` 相当于 pop() 了一次
+		vm.sp = frame.basePointer - 1
 
-			err := vm.push(vm.globals[globalIndex])
-			if err != nil {
-				return err
-			}
+		err := vm.push(returnValue)
+		if err != nil {
+			return err
+		}
 
-		// 获取内置函数
-		case code.OpGetBuiltin:
-			builtinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+	case code.OpReturn:
+		vm.popFrame()
+		vm.pop()
 
-			definition := object.Builtins[builtinIndex]
+		err := vm.push(Null)
+		if err != nil {
+			return err
+		}
 
-			err := vm.push(definition.Builtin)
-			if err != nil {
-				return err
-			}
+	case code.OpHalt:
+		vm.halt(vm.pop())
 
-		// 一元操作
-		case code.OpMinus:
-			err := vm.executeMinusOperator()
-			if err != nil {
-				return err
-			}
+	case code.OpSetLocal:
+		localIndex := code.ReadUint8(ins[ip+1:])
+		frame.ip += 1
 
-		case code.OpBang:
-			err := vm.executeBangOperator()
-			if err != nil {
-				return err
-			}
+		err := checkLocalIndex(frame, int(localIndex))
+		if err != nil {
+			return err
+		}
 
-		// 创建 Array
-		case code.OpArray:
-			count := int(code.ReadUint16(ins[ip+1:])) // int(code.ReadUint16(vm.instructions[ip+1:]))
-			// ip += 2
-			vm.currentFrame().ip += 2
+		vm.stack[frame.basePointer+int(localIndex)] = vm.pop() // 通过 “帧指针+偏移值” 计算出局部变量的位置
 
-			array := vm.buildArray(vm.sp-count, vm.sp)
+	case code.OpGetLocal:
+		localIndex := code.ReadUint8(ins[ip+1:])
+		frame.ip += 1
 
-			// 改变 stack point 的值，相当于弹出 count 项数值
-			vm.sp = vm.sp - count
+		err := checkLocalIndex(frame, int(localIndex))
+		if err != nil {
+			return err
+		}
 
-			err := vm.push(array)
+		err = vm.push(vm.stack[frame.basePointer+int(localIndex)])
+		if err != nil {
+			return err
+		}
 
-			if err != nil {
-				return err
-			}
+	case code.OpGetFree:
+		freeIndex := code.ReadUint8(ins[ip+1:])
+		frame.ip += 1
 
-		// 创建 Hash(Map)
-		case code.OpHash:
-			count := int(code.ReadUint16(ins[ip+1:])) // int(code.ReadUint16(vm.instructions[ip+1:]))
-			// ip += 2
-			vm.currentFrame().ip += 2
+		currentClosure := frame.cl
+		err := vm.push(currentClosure.Free[freeIndex])
+		if err != nil {
+			return err
+		}
 
-			hash, err := vm.buildHash(vm.sp-count, vm.sp)
-			if err != nil {
-				return err
-			}
+	// 加减乘除运算
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod:
+		err := vm.executeBinaryOperation(op)
+		if err != nil {
+			return err
+		}
 
-			// 改变 stack point 的值，相当于弹出 count 项数值
-			vm.sp = vm.sp - count
+	case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+		err := vm.executeComparison(op)
+		if err != nil {
+			return err
+		}
 
-			err = vm.push(hash)
-			if err != nil {
-				return err
-			}
+	// 标识符操作
+	case code.OpSetGlobal:
+		globalIndex := code.ReadUint16(ins[ip+1:]) // code.ReadUint16(vm.instructions[ip+1:])
+		// ip += 2
+		frame.ip += 2
 
-		// 读取索引
-		case code.OpIndex:
-			index := vm.pop()
-			left := vm.pop()
+		vm.globals[globalIndex] = vm.pop()
 
-			err := vm.executeIndexExpression(left, index)
-			if err != nil {
-				return err
-			}
+	case code.OpGetGlobal:
+		globalIndex := code.ReadUint16(ins[ip+1:]) // code.ReadUint16(vm.instructions[ip+1:])
+		// ip += 2
+		frame.ip += 2
 
-		// 置布尔值操作
-		case code.OpTrue:
-			err := vm.push(True)
-			if err != nil {
-				return err
-			}
+		err := vm.push(vm.globals[globalIndex])
+		if err != nil {
+			return err
+		}
 
-		case code.OpFalse:
-			err := vm.push(False)
-			if err != nil {
-				return err
-			}
+	// 获取内置函数
+	case code.OpGetBuiltin:
+		builtinIndex := code.ReadUint8(ins[ip+1:])
+		frame.ip += 1
 
-		case code.OpNull:
-			err := vm.push(Null)
-			if err != nil {
-				return err
-			}
+		definition := object.Builtins[builtinIndex]
+
+		err := vm.push(definition.Builtin)
+		if err != nil {
+			return err
+		}
+
+	// 一元操作
+	case code.OpMinus:
+		err := vm.executeMinusOperator()
+		if err != nil {
+			return err
+		}
+
+	case code.OpBang:
+		err := vm.executeBangOperator()
+		if err != nil {
+			return err
+		}
+
+	case code.OpPlus:
+		err := vm.executePlusOperator()
+		if err != nil {
+			return err
+		}
+
+	// 创建 Array
+	case code.OpArray:
+		count := int(code.ReadUint16(ins[ip+1:])) // int(code.ReadUint16(vm.instructions[ip+1:]))
+		// ip += 2
+		frame.ip += 2
+
+		array := vm.buildArray(vm.sp-count, vm.sp)
+
+		// 改变 stack point 的值，相当于弹出 count 项数值
+		vm.sp = vm.sp - count
+
+		err := vm.push(array)
+
+		if err != nil {
+			return err
+		}
+
+	// 创建 Hash(Map)
+	case code.OpHash:
+		count := int(code.ReadUint16(ins[ip+1:])) // int(code.ReadUint16(vm.instructions[ip+1:]))
+		// ip += 2
+		frame.ip += 2
+
+		hash, err := vm.buildHash(vm.sp-count, vm.sp)
+		if err != nil {
+			return err
+		}
+
+		// 改变 stack point 的值，相当于弹出 count 项数值
+		vm.sp = vm.sp - count
+
+		err = vm.push(hash)
+		if err != nil {
+			return err
+		}
+
+	// 读取索引
+	case code.OpIndex:
+		index := vm.pop()
+		left := vm.pop()
+
+		err := vm.executeIndexExpression(left, index)
+		if err != nil {
+			return err
+		}
+
+	case code.OpSetIndex:
+		value := vm.pop()
+		index := vm.pop()
+		left := vm.pop()
+
+		err := vm.executeSetIndex(left, index, value)
+		if err != nil {
+			return err
+		}
+
+	// 置布尔值操作
+	case code.OpTrue:
+		err := vm.push(True)
+		if err != nil {
+			return err
+		}
+
+	case code.OpFalse:
+		err := vm.push(False)
+		if err != nil {
+			return err
+		}
+
+	case code.OpNull:
+		err := vm.push(Null)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -365,10 +853,25 @@ func (vm *VM) pop() object.Object {
 	return o
 }
 
+// 返回最后一个被弹出的值
+// 注：
+// 如果还没有任何值被弹出（比如空的程序，或者只有注释的程序），则返回 nil
 func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
+// 返回程序的结果
+// 如果编译时开启了 SetKeepLastValue，程序最后一个表达式语句的值保留在栈顶，返回这个值；
+// 否则（栈为空时）返回最后弹出的值，跟 LastPoppedStackElem 相同。
+// 注：
+// 通过 exit 结束的程序，其结果是 exit 的参数。
+func (vm *VM) Result() object.Object {
+	if vm.sp > 0 {
+		return vm.stack[vm.sp-1]
+	}
+	return vm.LastPoppedStackElem()
+}
+
 func (vm *VM) pushClosure(constIndex int, numFree int) error {
 	constant := vm.constants[constIndex]
 	function, ok := constant.(*object.CompiledFunction)
@@ -376,6 +879,12 @@ func (vm *VM) pushClosure(constIndex int, numFree int) error {
 		return fmt.Errorf("not a function: %+v", constant)
 	}
 
+	// 运算栈顶是参数的默认值，其下是来自上层的（即当前函数所捕获的）局部变量
+	numDefaults := function.NumDefaults
+	defaults := make([]object.Object, numDefaults)
+	copy(defaults, vm.stack[vm.sp-numDefaults:vm.sp])
+	vm.sp = vm.sp - numDefaults
+
 	// 填充来自上层的的（即当前函数所捕获的）局部变量
 	free := make([]object.Object, numFree)
 	for i := 0; i < numFree; i++ {
@@ -385,7 +894,7 @@ func (vm *VM) pushClosure(constIndex int, numFree int) error {
 	// 从运算栈弹出来自上层的局部变量
 	vm.sp = vm.sp - numFree
 
-	closure := &object.Closure{Fn: function, Free: free}
+	closure := &object.Closure{Fn: function, Free: free, Defaults: defaults}
 	return vm.push(closure)
 }
 
@@ -411,10 +920,33 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 		return vm.executeBinaryStringOperation(op, left, right)
 
 	default:
-		return fmt.Errorf("unsupported types for binary operation: %s %s",
-			leftType, rightType)
+		return binaryOperationError(leftType, rightType)
+	}
+
+}
+
+// 操作数类型不支持二元运算时的错误
+// 操作数为 Null 时（通常是没有 else 分支的 if 表达式的值，或者没有返回值的函数的结果）
+// 单独说明，以便跟其他类型的错误区分
+func binaryOperationError(leftType, rightType object.ObjectType) error {
+	if leftType == object.NULL_OBJ || rightType == object.NULL_OBJ {
+		return fmt.Errorf("cannot use NULL in arithmetic: %s %s", leftType, rightType)
+	}
+	return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
+}
+
+// 计算变量的值 +1（increment 为 true）或者 -1 的结果，
+// 语义跟 OpAdd/OpSub 相同，即只支持整数，其他类型返回相同的错误
+func (vm *VM) executeIncrement(value object.Object, increment bool) (object.Object, error) {
+	integer, ok := value.(*object.Integer)
+	if !ok {
+		return nil, binaryOperationError(value.Type(), object.INTEGER_OBJ)
 	}
 
+	if increment {
+		return object.NewInteger(integer.Value + 1), nil
+	}
+	return object.NewInteger(integer.Value - 1), nil
 }
 
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode,
@@ -428,16 +960,30 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode,
 	switch op {
 	case code.OpAdd:
 		// result = leftValue + rightValue
-		return vm.push(&object.Integer{Value: leftValue + rightValue})
+		return vm.push(object.NewInteger(leftValue + rightValue))
 	case code.OpSub:
 		// result = leftValue - rightValue
-		return vm.push(&object.Integer{Value: leftValue - rightValue})
+		return vm.push(object.NewInteger(leftValue - rightValue))
 	case code.OpMul:
 		// result = leftValue * rightValue
-		return vm.push(&object.Integer{Value: leftValue * rightValue})
+		return vm.push(object.NewInteger(leftValue * rightValue))
 	case code.OpDiv:
 		// result = leftValue / rightValue
-		return vm.push(&object.Integer{Value: leftValue / rightValue})
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		if vm.floorDivision {
+			return vm.push(object.NewInteger(floorDiv(leftValue, rightValue)))
+		}
+		return vm.push(object.NewInteger(leftValue / rightValue))
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		if vm.floorDivision {
+			return vm.push(object.NewInteger(floorMod(leftValue, rightValue)))
+		}
+		return vm.push(object.NewInteger(leftValue % rightValue))
 
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
@@ -446,6 +992,25 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode,
 	// return vm.push(&object.Integer{Value: result})
 }
 
+// 向负无穷取整的除法，比如 -7 / 2 = -4
+func floorDiv(left, right int64) int64 {
+	quotient := left / right
+	if left%right != 0 && (left < 0) != (right < 0) {
+		quotient--
+	}
+	return quotient
+}
+
+// 跟 floorDiv 对应的取余，余数的符号跟除数相同，比如 -7 % 2 = 1，
+// 满足 left == floorDiv(left, right) * right + floorMod(left, right)
+func floorMod(left, right int64) int64 {
+	remainder := left % right
+	if remainder != 0 && (remainder < 0) != (right < 0) {
+		remainder += right
+	}
+	return remainder
+}
+
 func (vm *VM) executeBinaryStringOperation(op code.Opcode,
 	left object.Object, right object.Object) error {
 
@@ -467,17 +1032,70 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 		return vm.executeIntegerComparison(op, left, right)
 	}
 
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left, right)
+	}
+
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
+		return vm.push(nativeBoolToBooleanObject(objectsEqual(left, right)))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
+		return vm.push(nativeBoolToBooleanObject(!objectsEqual(left, right)))
 	default:
 		return fmt.Errorf("unknown operator: %d (%s %s)",
 			op, left.Type(), right.Type())
 	}
 }
 
+// 比较两个对象是否相等（结构相等）
+// String 和 Float 比较值，Array 逐个元素比较，Hash 逐个键值对比较，
+// 其余的类型（比如 Boolean 和 Null 都是单例）则比较指针。
+func objectsEqual(left, right object.Object) bool {
+	switch left := left.(type) {
+	case *object.Integer:
+		right, ok := right.(*object.Integer)
+		return ok && left.Value == right.Value
+
+	case *object.String:
+		right, ok := right.(*object.String)
+		return ok && left.Value == right.Value
+
+	case *object.Float:
+		right, ok := right.(*object.Float)
+		return ok && left.Value == right.Value
+
+	case *object.Array:
+		right, ok := right.(*object.Array)
+		if !ok || len(left.Elements) != len(right.Elements) {
+			return false
+		}
+
+		for i, element := range left.Elements {
+			if !objectsEqual(element, right.Elements[i]) {
+				return false
+			}
+		}
+		return true
+
+	case *object.Hash:
+		right, ok := right.(*object.Hash)
+		if !ok || len(left.Pairs) != len(right.Pairs) {
+			return false
+		}
+
+		for hashKey, pair := range left.Pairs {
+			other, ok := right.Pairs[hashKey]
+			if !ok || !objectsEqual(pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+
+	default:
+		return left == right
+	}
+}
+
 func (vm *VM) executeIntegerComparison(
 	op code.Opcode, left, right object.Object) error {
 
@@ -491,11 +1109,47 @@ func (vm *VM) executeIntegerComparison(
 		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
 	default:
 		return fmt.Errorf("unknown operator: %d", op)
 	}
 }
 
+// 比较两个 String 的值（而不是比较两个对象的指针），
+// 大小的比较跟内置函数 sort、maxOf、minOf 一样使用 object.CompareStrings
+func (vm *VM) executeStringComparison(
+	op code.Opcode, left, right object.Object) error {
+
+	result := object.CompareStrings(left.(*object.String), right.(*object.String))
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(result == 0))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(result != 0))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(result > 0))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(result < 0))
+	default:
+		return fmt.Errorf("unknown operator: %d (%s %s)",
+			op, left.Type(), right.Type())
+	}
+}
+
+// 检查局部变量的索引是否位于当前函数的局部变量范围 [0, NumLocals) 之内，
+// 即局部变量的位置位于 [basePointer, basePointer + NumLocals) 之内，
+// 以免错误的字节码读写运算栈里的其他位置
+func checkLocalIndex(frame *Frame, localIndex int) error {
+	numLocals := frame.cl.Fn.NumLocals
+	if localIndex >= numLocals {
+		return fmt.Errorf("local index out of range: %d, number of locals %d",
+			localIndex, numLocals)
+	}
+	return nil
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return True
@@ -506,6 +1160,10 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 
 func (vm *VM) executeBangOperator() error {
 	operand := vm.pop()
+	if vm.emptyFalsy {
+		return vm.push(nativeBoolToBooleanObject(!vm.isTruthy(operand)))
+	}
+
 	switch operand {
 	case True:
 		return vm.push(False)
@@ -526,7 +1184,31 @@ func (vm *VM) executeMinusOperator() error {
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
 	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
+	return vm.push(object.NewInteger(-value))
+}
+
+// 一元 `+` 不改变数值，操作数必须是数字
+func (vm *VM) executePlusOperator() error {
+	operand := vm.pop()
+	if operand.Type() != object.INTEGER_OBJ && operand.Type() != object.FLOAT_OBJ {
+		return fmt.Errorf("unsupported type for unary plus: %s", operand.Type())
+	}
+	return vm.push(operand)
+}
+
+// 根据 VM 的设置判断值是否视为 true，见 SetEmptyFalsy
+func (vm *VM) isTruthy(obj object.Object) bool {
+	if vm.emptyFalsy {
+		switch obj := obj.(type) {
+		case *object.Array:
+			return len(obj.Elements) > 0
+		case *object.Hash:
+			return len(obj.Pairs) > 0
+		case *object.String:
+			return len(obj.Value) > 0
+		}
+	}
+	return isTruthy(obj)
 }
 
 func isTruthy(obj object.Object) bool {
@@ -553,7 +1235,7 @@ func (vm *VM) buildArray(start int, end int) object.Object {
 }
 
 func (vm *VM) buildHash(start int, end int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
 	for i := start; i < end; i += 2 {
 		key := vm.stack[i]
@@ -566,15 +1248,17 @@ func (vm *VM) buildHash(start int, end int) (object.Object, error) {
 			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
 		}
 
-		hashedPairs[hashKey.HashKey()] = pair
+		hash.Set(hashKey.HashKey(), pair)
 	}
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return hash, nil
 }
 
 func (vm *VM) executeIndexExpression(left object.Object, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeStringIndex(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
 	default:
@@ -592,6 +1276,24 @@ func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	return vm.push(arrayObject.Elements[i])
 }
 
+// 字符串的索引操作返回只包含一个字符的字符串
+// 索引以字符（rune）而不是字节计算，跟内置函数 len 一致
+func (vm *VM) executeStringIndex(str, index object.Object) error {
+	value := str.(*object.String).Value
+	i := index.(*object.Integer).Value
+	if i < 0 {
+		return vm.push(Null)
+	}
+
+	for _, ch := range value {
+		if i == 0 {
+			return vm.push(&object.String{Value: string(ch)})
+		}
+		i--
+	}
+	return vm.push(Null)
+}
+
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
 	key, ok := index.(object.Hashable)
@@ -605,6 +1307,31 @@ func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	return vm.push(pair.Value)
 }
 
+// 修改 Array 的元素，或者插入/更新 Hash 的键值对，然后把新的值压入栈
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		arrayObject := left.(*object.Array)
+		i := index.(*object.Integer).Value
+		length := int64(len(arrayObject.Elements))
+		if i < 0 || i >= length {
+			return fmt.Errorf("index out of range: %d, length %d", i, length)
+		}
+		arrayObject.Elements[i] = value
+		return vm.push(value)
+	case left.Type() == object.HASH_OBJ:
+		hashObject := left.(*object.Hash)
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		hashObject.Set(key.HashKey(), object.HashPair{Key: index, Value: value})
+		return vm.push(value)
+	default:
+		return fmt.Errorf("index assignment not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
 func (vm *VM) executeCall(numArgs int) error {
 	callee := vm.stack[vm.sp-1-numArgs]
 	switch callee := callee.(type) {
@@ -634,23 +1361,127 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	// 	return fmt.Errorf("calling non-function")
 	// }
 
-	// 检查实参的数量
+	// 检查实参的数量，并补充缺少的（有默认值的）实参
 	// 注：
 	// 也可以在编译阶段检查
-	if numArgs != cl.Fn.NumParameters {
-		return fmt.Errorf("wrong number of arguments, expected %d, actual %d",
-			cl.Fn.NumParameters, numArgs)
+	numArgs, err := vm.fillDefaultArguments(cl, numArgs)
+	if err != nil {
+		return err
+	}
+
+	// 调用帧列表已满（比如无限递归），返回错误而不是越界访问 frames
+	if vm.frameIndex >= MaxFrames {
+		return fmt.Errorf("max call depth exceeded")
 	}
 
-	frame := NewFrame(cl, vm.sp-numArgs)
+	frame := vm.newFrame(cl, vm.sp-numArgs)
 	vm.pushFrame(frame)                         // 压入新的调用帧
 	vm.sp = frame.basePointer + cl.Fn.NumLocals // 保留空间给（自定义函数的）局部变量
 	return nil
 }
 
+// 检查实参的数量，如果缺少的实参都有默认值，则把默认值压入运算栈（作为实参），
+// 返回补充之后的实参数量
+func (vm *VM) fillDefaultArguments(cl *object.Closure, numArgs int) (int, error) {
+	numParameters := cl.Fn.NumParameters
+	numRequired := numParameters - len(cl.Defaults)
+
+	if numArgs > numParameters || numArgs < numRequired {
+		if numRequired == numParameters {
+			return 0, fmt.Errorf("wrong number of arguments, expected %d, actual %d",
+				numParameters, numArgs)
+		}
+		return 0, fmt.Errorf("wrong number of arguments, expected %d to %d, actual %d",
+			numRequired, numParameters, numArgs)
+	}
+
+	for i := numArgs; i < numParameters; i++ {
+		err := vm.push(cl.Defaults[i-numRequired])
+		if err != nil {
+			return 0, err
+		}
+	}
+	return numParameters, nil
+}
+
+// 判断刚读取的 OpCall 是否处于尾调用的位置
+// 即下一条指令是 OpReturnValue、被调用的是闭包，且当前调用帧不是 main
+func (vm *VM) isTailCall(numArgs int) bool {
+	if vm.frameIndex <= 1 {
+		return false
+	}
+
+	// 当前调用帧设置了异常处理器（即位于 try 语句块里），调用帧不能被替换
+	if len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frameIndex == vm.frameIndex {
+		return false
+	}
+
+	if _, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure); !ok {
+		return false
+	}
+
+	frame := vm.currentFrame()
+	ins := frame.Instructions()
+	next := frame.ip + 1
+	return next < len(ins) && code.Opcode(ins[next]) == code.OpReturnValue
+}
+
+// 以复用当前调用帧的方式调用闭包
+// 把被调用的函数及其实参移动到当前调用帧的函数及实参的位置，
+// 然后用新的调用帧替换当前的调用帧。
+func (vm *VM) tailCallClosure(numArgs int) error {
+	cl := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+
+	numArgs, err := vm.fillDefaultArguments(cl, numArgs)
+	if err != nil {
+		return err
+	}
+
+	basePointer := vm.currentFrame().basePointer
+	copy(vm.stack[basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+
+	// 当前调用帧不再需要，直接重置（调用帧不变，但是执行的函数改变了）
+	frame := vm.currentFrame()
+	frame.reset(cl, basePointer)
+	vm.frameChanged = true
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	return nil
+}
+
+// 通过变量间接调用（包括作为回调函数）的 exit
+var exitBuiltin = object.GetBuiltinByName("exit")
+
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
-	result := builtin.Fn(args...)
+
+	// 跟 OpHalt 一样结束整个程序，参数个数不对时由内置函数返回错误
+	if builtin == exitBuiltin && numArgs <= 1 {
+		var result object.Object = Null
+		if numArgs == 1 {
+			result = args[0]
+		}
+		vm.halt(result)
+		return nil
+	}
+
+	var result object.Object
+	if builtin.CallbackFn != nil {
+		result = builtin.CallbackFn(vm.callFunction, args...)
+	} else {
+		result = builtin.Fn(args...)
+	}
+
+	// 回调的函数里调用了 exit()，忽略内置函数的结果
+	if vm.exited {
+		return nil
+	}
+
+	// 致命错误（比如 panic 和 assert 产生的错误）中止程序的执行，
+	// 严格模式下，或者位于 try 语句块里时，所有错误都作为运行时错误
+	if errObj, ok := result.(*object.Error); ok && (errObj.Fatal || vm.strictErrors || len(vm.handlers) > 0) {
+		return errObj
+	}
+
 	vm.sp = vm.sp - numArgs - 1
 	if result != nil {
 		vm.push(result)
@@ -659,3 +1490,61 @@ func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	}
 	return nil
 }
+
+// 程序通过 exit() 结束时，回调函数返回的错误
+var errExited = &object.Error{Message: "program exited", Fatal: true}
+
+// 供内置函数回调函数（闭包或者内置函数）
+// 把函数及实参压入运算栈并调用，然后执行指令直到函数返回（即函数的调用帧被弹出），
+// 最后弹出并返回函数的返回值。
+func (vm *VM) callFunction(fn object.Object, args ...object.Object) (object.Object, error) {
+	sp := vm.sp
+	frameIndex := vm.frameIndex
+
+	// 发生错误时恢复运算栈和调用帧
+	restore := func(err error) (object.Object, error) {
+		vm.sp = sp
+		vm.frameIndex = frameIndex
+		vm.frameChanged = true
+		return nil, err
+	}
+
+	err := vm.push(fn)
+	if err != nil {
+		return restore(err)
+	}
+
+	for _, arg := range args {
+		err := vm.push(arg)
+		if err != nil {
+			return restore(err)
+		}
+	}
+
+	err = vm.executeCall(len(args))
+	if err != nil {
+		return restore(err)
+	}
+
+	// 回调的是 exit 本身
+	if vm.exited {
+		return nil, errExited
+	}
+
+	// 对于内置函数，返回值已经被压入运算栈；
+	// 对于闭包，则需要一直执行指令直到闭包返回
+	for vm.frameIndex > frameIndex {
+		// 闭包里调用了 exit()，返回致命错误，让内置函数不再继续回调
+		if vm.exited {
+			return nil, errExited
+		}
+
+		// 只处理回调的闭包里设置的异常处理器，其他错误由内置函数的调用者处理
+		err := vm.step()
+		if err != nil && !vm.handleError(err, frameIndex) {
+			return restore(err)
+		}
+	}
+
+	return vm.pop(), nil
+}
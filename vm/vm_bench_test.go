@@ -0,0 +1,150 @@
+package vm
+
+import (
+	"testing"
+	"toyvm/compiler"
+)
+
+// 尾递归的求和函数，递归深度为 500（不开启尾调用检测时，更深的递归会导致运算栈溢出）
+const tailRecursionInput = `
+let sum = fn(n, acc) {
+	if (n == 0) { acc } else { sum(n - 1, acc + n) }
+};
+sum(500, 0);
+`
+
+func benchmarkTailRecursion(b *testing.B, tailCall bool) {
+	program := parse(tailRecursionInput)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		b.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vm := New(bytecode)
+		vm.SetTailCall(tailCall)
+		err := vm.Run()
+		if err != nil {
+			b.Fatalf("vm error: %s", err)
+		}
+	}
+}
+
+func BenchmarkTailRecursion(b *testing.B) {
+	benchmarkTailRecursion(b, false)
+}
+
+func BenchmarkTailRecursionWithTailCall(b *testing.B) {
+	benchmarkTailRecursion(b, true)
+}
+
+// 调用密集的程序，用于观察函数调用时的内存分配
+const fibonacciInput = `
+let fibonacci = fn(x) {
+	if (x < 2) { return x; }
+	fibonacci(x - 1) + fibonacci(x - 2)
+};
+fibonacci(20);
+`
+
+func BenchmarkFibonacci(b *testing.B) {
+	program := parse(fibonacciInput)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		b.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vm := New(bytecode)
+		err := vm.Run()
+		if err != nil {
+			b.Fatalf("vm error: %s", err)
+		}
+	}
+}
+
+// 算术运算密集的程序，循环 10000 次，每次执行若干整数运算
+const arithmeticLoopInput = `
+iterate(fn(x) { (x * 3 + 7) / 2 - x + 1 }, 0, 10000);
+`
+
+func BenchmarkArithmeticLoop(b *testing.B) {
+	program := parse(arithmeticLoopInput)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		b.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vm := New(bytecode)
+		err := vm.Run()
+		if err != nil {
+			b.Fatalf("vm error: %s", err)
+		}
+	}
+}
+
+// 结果都是小整数的算术运算，用于观察小整数缓存对内存分配的影响
+const smallIntegerInput = `
+iterate(fn(x) { 10 - x * 1 + 0 }, 0, 10000);
+`
+
+func BenchmarkSmallIntegerArithmetic(b *testing.B) {
+	program := parse(smallIntegerInput)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		b.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vm := New(bytecode)
+		err := vm.Run()
+		if err != nil {
+			b.Fatalf("vm error: %s", err)
+		}
+	}
+}
+
+// 变量 +1 的循环，比较专门的指令（`n = n + 1`）跟一般的加法（`n = n + step`）的速度
+func BenchmarkIncrement(b *testing.B) {
+	benchmarks := []struct {
+		name  string
+		input string
+	}{
+		{"global/increment", "let n = 0; iterate(fn(x) { n = n + 1 }, 0, 10000)"},
+		{"global/add", "let n = 0; let step = 1; iterate(fn(x) { n = n + step }, 0, 10000)"},
+		{"local/increment", "iterate(fn(x) { let n = x; n = n + 1; n -= 1 }, 0, 10000)"},
+		{"local/add", "let step = 1; iterate(fn(x) { let n = x; n = n + step; n -= step }, 0, 10000)"},
+	}
+
+	for _, bm := range benchmarks {
+		bytecode, _, err := compiler.CompileSource(bm.input)
+		if err != nil {
+			b.Fatalf("compiler error: %s", err)
+		}
+
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				vm := New(bytecode)
+				err := vm.Run()
+				if err != nil {
+					b.Fatalf("vm error: %s", err)
+				}
+			}
+		})
+	}
+}
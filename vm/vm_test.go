@@ -1,9 +1,13 @@
 package vm
 
 import (
+	"bytes"
 	"fmt"
+	"os"
+	"strings"
 	"testing"
 	"toyvm/ast"
+	"toyvm/code"
 	"toyvm/compiler"
 	"toyvm/lexer"
 	"toyvm/object"
@@ -188,6 +192,10 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-5", -5},
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
+		{"+5", 5},
+		{"+-5", -5},
+		{"-+5", -5},
+		{"1 + +2", 3},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
 	}
 	runVmTests(t, tests)
@@ -229,6 +237,33 @@ func TestBooleanExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestCompositeEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] != [1, 2]`, false},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, 2] == [1, 2, 3]`, false},
+		{`[1, [2, 3]] == [1, [2, 3]]`, true},
+		{`[] == []`, true},
+		{`{1: 2, 3: 4} == {3: 4, 1: 2}`, true},
+		{`{1: 2} != {1: 2}`, false},
+		{`{1: 2} == {1: 3}`, false},
+		{`{1: 2} == {2: 2}`, false},
+		{`{"a": [1]} == {"a": [1]}`, true},
+		{`"a" + "b" == "ab"`, true},
+		{`"a" + "b" != "ab"`, false},
+		{`"ab" == "ba"`, false},
+		{`[1] == {1: 1}`, false},
+		{`"1" == 1`, false},
+		{`sqrt(4) == sqrt(4)`, true},
+		{`sqrt(4) != sqrt(4)`, false},
+		{`sqrt(4) == sqrt(5)`, false},
+		{`[sqrt(2)] == [sqrt(2)]`, true},
+		{`parseJson("1.5") == parseJson("[1.5]")[0]`, true},
+	}
+	runVmTests(t, tests)
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []vmTestCase{
 		{"if (true) { 10 }", 10},
@@ -239,6 +274,11 @@ func TestConditionals(t *testing.T) {
 		{"if (1 < 2) { 10 } else { 20 }", 10},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 1) { 20 } else { 30 }", 20},
+		{"if (1 > 2) { 10 } else if (1 > 3) { 20 } else { 30 }", 30},
+		{"if (1 < 2) { 10 } else if (2 > 1) { 20 } else { 30 }", 10},
+		{"let f = fn(x) { if (x < 0) { return -1; } else if (x == 0) { return 0; } 1 }; [f(-5), f(0), f(5)]",
+			[]int{-1, 0, 1}},
 	}
 
 	runVmTests(t, tests)
@@ -249,6 +289,7 @@ func TestConditionals2(t *testing.T) {
 		// [...]
 		{"if (1 > 2) { 10 }", Null},
 		{"if (false) { 10 }", Null},
+		{"if (false) { 10 } else if (false) { 20 }", Null},
 	}
 	runVmTests(t, tests)
 }
@@ -271,6 +312,30 @@ func TestStringExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestStringComparison(t *testing.T) {
+	tests := []vmTestCase{
+		{`"monkey" == "monkey"`, true},
+		{`"monkey" != "monkey"`, false},
+		{`"monkey" == "banana"`, false},
+		{`"mon" + "key" == "monkey"`, true},
+		{`"monkey" == "mon" + "key"`, true},
+		{`"mon" + "key" != "monkey"`, false},
+		{`let a = "mon"; let b = "key"; a + b == "monkey"`, true},
+		{`let s = fn(x) { x + "!" }; s("hi") == "hi!"`, true},
+
+		// 按字节的字典顺序比较大小
+		{`"apple" < "banana"`, true},
+		{`"banana" < "apple"`, false},
+		{`"b" > "a"`, true},
+		{`"a" > "b"`, false},
+		{`"app" < "apple"`, true},
+		{`"apple" > "apple"`, false},
+		{`"Z" < "a"`, true},
+		{`"a" < "b" < "c"`, true},
+	}
+	runVmTests(t, tests)
+}
+
 func TestArrayLiterals(t *testing.T) {
 	tests := []vmTestCase{
 		{"[]", []int{}},
@@ -292,6 +357,14 @@ func TestHashLiterals(t *testing.T) {
 				(&object.Integer{Value: 2}).HashKey(): 3,
 			},
 		},
+		{
+			"{1: 10, true: 20, false: 30}",
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 1}).HashKey():     10,
+				(&object.Boolean{Value: true}).HashKey():  20,
+				(&object.Boolean{Value: false}).HashKey(): 30,
+			},
+		},
 		{
 			"{1 + 1: 2 * 2, 3 + 3: 4 * 4}",
 			map[object.HashKey]int64{
@@ -305,6 +378,15 @@ func TestHashLiterals(t *testing.T) {
 
 func TestIndexExpressions(t *testing.T) {
 	tests := []vmTestCase{
+		{"{1: 10, 2: 20}[2]", 20},
+		{"{true: 1}[true]", 1},
+		{"{true: 1}[false]", Null},
+		{`{1: "a", true: "b"}[true]`, "b"},
+		{`{1: "a", true: "b"}[1]`, "a"},
+		{`{1: "a", "1": "b"}["1"]`, "b"},
+		{"{2: 1, 10: 2}[10]", 2},
+		{"{-1: 5}[0 - 1]", 5},
+		{"{1 > 0: 5}[true]", 5},
 		{"[1, 2, 3][1]", 2},
 		{"[1, 2, 3][0 + 2]", 3},
 		{"[[1, 1, 1]][0][0]", 1},
@@ -580,6 +662,8 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len({})`, 0},
+		{`len({1: 2, 3: 4})`, 2},
 		{
 			`len(1)`,
 			&object.Error{
@@ -621,6 +705,218 @@ func TestBuiltinFunctions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestOrderingBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`sort([3, 1, 2])`, []int{1, 2, 3}},
+		{`sort([])`, []int{}},
+		{`sort(["b", "a"]) == ["a", "b"]`, true},
+		{`sort(["b", "ab", "a"]) == ["a", "ab", "b"]`, true},
+		{`let a = [2, 1]; sort(a); a`, []int{2, 1}},
+		{`sort([1, 3, 2], fn(a, b) { a > b })`, []int{3, 2, 1}},
+		{`sort([[2], [1, 1], []], fn(a, b) { len(a) < len(b) }) == [[], [2], [1, 1]]`, true},
+		{`sort([2, 1], fn(a, b) { 1 })`,
+			&object.Error{
+				Message: "comparison function of `sort` must return BOOLEAN, actual INTEGER",
+			},
+		},
+		{`sort([2, 1], fn(a) { true })`,
+			&object.Error{
+				Message: "wrong number of arguments, expected 1, actual 2",
+			},
+		},
+		{`maxOf([1, 3, 2])`, 3},
+		{`minOf([3, 1, 2])`, 1},
+		{`maxOf(["a", "c", "b"])`, "c"},
+		{`minOf(["b", "a", "c"])`, "a"},
+		{`maxOf([])`, Null},
+		{`sort([1, "a"])`,
+			&object.Error{
+				Message: "elements to `sort` must be the same type, actual INTEGER and STRING",
+			},
+		},
+		{`maxOf([true])`,
+			&object.Error{
+				Message: "element type to `maxOf` must be INTEGER or STRING, actual BOOLEAN",
+			},
+		},
+		{`minOf(1)`,
+			&object.Error{
+				Message: "argument type to `minOf` must be ARRAY, actual INTEGER",
+			},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+// sort、maxOf、minOf 以及运算符 `<`/`>` 对字符串的排序应该一致
+func TestStringOrderingConsistency(t *testing.T) {
+	tests := []vmTestCase{
+		{`"a" < "b"`, true},
+		{`"b" > "a"`, true},
+		{`"a" > "b"`, false},
+		{`"ab" > "a"`, true},
+		{`"a" < "a"`, false},
+		{`sort(["b", "a"])[0] < sort(["b", "a"])[1]`, true},
+		{`maxOf(["a", "c", "b"]) == sort(["a", "c", "b"])[2]`, true},
+		{`minOf(["a", "c", "b"]) == sort(["a", "c", "b"])[0]`, true},
+		{`"B" < "a"`, true},
+		{`maxOf(["B", "a", "b"])`, "b"},
+		{`sort(["b", "a", "B"]) == ["B", "a", "b"]`, true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestSliceAndReverseBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`slice([1, 2, 3, 4], 1, 3)`, []int{2, 3}},
+		{`slice([1, 2, 3], -1, 10)`, []int{1, 2, 3}},
+		{`slice([1, 2, 3], 2, 1)`, []int{}},
+		{`slice("hello", 1, 4)`, "ell"},
+		{`slice("hello", 3, 100)`, "lo"},
+		{`reverse([1, 2, 3])`, []int{3, 2, 1}},
+		{`reverse([])`, []int{}},
+		{`reverse("abc")`, "cba"},
+		{`slice(1, 0, 1)`,
+			&object.Error{
+				Message: "argument type to `slice` must be ARRAY or STRING, actual INTEGER",
+			},
+		},
+		{`slice("a", "0", 1)`,
+			&object.Error{
+				Message: "argument type to `slice` must be INTEGER, actual STRING and INTEGER",
+			},
+		},
+		{`reverse(1)`,
+			&object.Error{
+				Message: "argument type to `reverse` must be ARRAY or STRING, actual INTEGER",
+			},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRangeBuiltin(t *testing.T) {
+	tests := []vmTestCase{
+		{`range(3)`, []int{0, 1, 2}},
+		{`range(2, 5)`, []int{2, 3, 4}},
+		{`range(5, 2)`, []int{}},
+		{`range(-2, 1)`, []int{-2, -1, 0}},
+		{`iterate(fn(a) { push(a, len(a)) }, [], 3) == range(3)`, true},
+		{`range("3")`,
+			&object.Error{Message: "argument type to `range` must be INTEGER, actual STRING"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAbsMinMaxBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`abs(-3) + max(1, 5, 2) - min(4, -1)`, 9},
+		{`abs(3)`, 3},
+		{`max(-1, -5)`, -1},
+		{`min(2, 2, 3)`, 2},
+		{`abs(-9223372036854775807 - 1)`,
+			&object.Error{Message: "integer overflow: abs(-9223372036854775808)"}},
+		{`max(1)`,
+			&object.Error{Message: "wrong number of arguments, expected at least 2, actual 1"}},
+		{`min(1, "a")`,
+			&object.Error{Message: "argument type to `min` must be INTEGER, actual STRING"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestPowAndSqrtBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`pow(2, 10)`, 1024},
+		{`pow(-3, 3)`, -27},
+		{`sqrt(16) == pow(sqrt(4), 2)`, true},
+		{`sqrt(2) == sqrt(3)`, false},
+		{`pow(2, -1)`,
+			&object.Error{Message: "exponent to `pow` must be non-negative for INTEGER, actual -1"}},
+		{`sqrt(-4)`,
+			&object.Error{Message: "argument to `sqrt` must be non-negative, actual -4"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+// len、索引、slice 以及 reverse 都应该作用于转义之后的字符串，而不是源码里的字面量
+func TestEscapedStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{`"a\tb"`, "a\tb"},
+		{`len("a\tb")`, 3},
+		{`"a\tb"[1]`, "\t"},
+		{`"a\tb"[1] == "\t"`, true},
+		{`reverse("a\tb")`, "b\ta"},
+		{`slice("a\tb", 1, 3)`, "\tb"},
+		{`len("say \"hi\"")`, 8},
+		{`"back\\slash"[4]`, "\\"},
+		{`"abc"[3]`, Null},
+		{`"abc"[-1]`, Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+// 字符串的长度、索引、slice 以及 reverse 都以字符（rune）而不是字节计算
+func TestUnicodeStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("héllo")`, 5},
+		{`len("你好😀")`, 3},
+		{`"你好😀"[2]`, "😀"},
+		{`"你好😀"[3]`, Null},
+		{`slice("你好😀", 1, 3)`, "好😀"},
+		{`reverse("a你😀")`, "😀你a"},
+		{`let π = 3; let 变量 = π * 2; 变量`, 6},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIterateBuiltin(t *testing.T) {
+	tests := []vmTestCase{
+		{`iterate(fn(x) { x * 2 }, 1, 10)`, 1024},
+		{`iterate(fn(x) { x * 2 }, 1, 0)`, 1},
+		{`iterate(fn(x) { x + "!" }, "hi", 3)`, "hi!!!"},
+		{`iterate(rest, [1, 2, 3], 2)`, []int{3}},
+		{
+			`
+			let double = fn(x) { x * 2 };
+			let quadruple = fn(x) { iterate(double, x, 2) };
+			iterate(quadruple, 1, 3)
+			`,
+			64,
+		},
+		{`iterate(fn(x) { x * 2 }, 1, -1)`,
+			&object.Error{
+				Message: "argument to `iterate` must be non-negative, actual -1",
+			},
+		},
+		{`iterate(1, 1, 1)`,
+			&object.Error{
+				Message: "argument type to `iterate` must be FUNCTION, actual INTEGER",
+			},
+		},
+		{`iterate(fn(x, y) { x }, 1, 1)`,
+			&object.Error{
+				Message: "wrong number of arguments, expected 2, actual 1",
+			},
+		},
+		{`iterate(fn(x) { x + "a" }, 1, 1)`,
+			&object.Error{
+				Message: "unsupported types for binary operation: INTEGER STRING",
+			},
+		},
+		{`let f = fn(x) { x + "a" }; [iterate(f, 1, 1), 2][1]`, 2},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestClosures(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -749,3 +1045,1526 @@ func TestRecursiveFunctions(t *testing.T) {
 	}
 	runVmTests(t, tests)
 }
+
+func TestMutuallyRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			letrec {
+				isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+				isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+			}
+			isEven(10);
+			`,
+			expected: true,
+		},
+		{
+			input: `
+			letrec {
+				isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+				isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } }
+			}
+			isOdd(7);
+			`,
+			expected: true,
+		},
+		{
+			input: `
+			letrec {
+				isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+				isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } }
+			}
+			let wrapper = fn() { isEven(3) };
+			wrapper();
+			`,
+			expected: false,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestCurrentFrameDisassembly(t *testing.T) {
+	input := `
+	let f = fn() { 1 + 2 };
+	f();
+	`
+
+	program := parse(input)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+
+	// 单步执行直到进入函数 f 的调用帧
+	for vm.frameIndex == 1 {
+		err := vm.step()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+	}
+
+	expected := "> 0000 OpConstant 0\n" +
+		"  0003 OpConstant 1\n" +
+		"  0006 OpAdd\n" +
+		"  0007 OpReturnValue\n"
+
+	actual := vm.CurrentFrameDisassembly()
+	if actual != expected {
+		t.Fatalf("wrong disassembly, expected %q, actual %q", expected, actual)
+	}
+
+	// 再执行两条指令，标记应该移到 OpAdd
+	for i := 0; i < 2; i++ {
+		err := vm.step()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+	}
+
+	marker := fmt.Sprintf("> %04d OpAdd", vm.currentFrame().ip+1)
+	if !strings.Contains(vm.CurrentFrameDisassembly(), marker) {
+		t.Errorf("marker not at ip, expected %q in %q",
+			marker, vm.CurrentFrameDisassembly())
+	}
+}
+
+// 编译并以开启或关闭尾调用检测的方式运行
+// 编译并执行 input，返回 VM 以及执行时的错误
+// compile（可以为 nil）代替默认的 Compile，用于设置编译器的选项或者改变编译的方式；
+// setup（可以为 nil）在执行之前调用，用于设置 VM 的选项。
+func runVmWith(t *testing.T, input string,
+	compile func(*compiler.Compiler, *ast.Program) error, setup func(*VM)) (*VM, error) {
+	t.Helper()
+	program := parse(input)
+	comp := compiler.New()
+
+	var err error
+	if compile != nil {
+		err = compile(comp, program)
+	} else {
+		err = comp.Compile(program)
+	}
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	if setup != nil {
+		setup(vm)
+	}
+	return vm, vm.Run()
+}
+
+func runVm(t *testing.T, input string) (*VM, error) {
+	t.Helper()
+	return runVmWith(t, input, nil, nil)
+}
+
+func runWithTailCall(t *testing.T, input string, tailCall bool) (*VM, error) {
+	t.Helper()
+	return runVmWith(t, input, nil, func(vm *VM) { vm.SetTailCall(tailCall) })
+}
+
+func TestTailCall(t *testing.T) {
+	input := `
+	let sum = fn(n, acc) {
+		if (n == 0) { acc } else { sum(n - 1, acc + n) }
+	};
+	let countdown = fn(n) {
+		if (n == 0) { return 0; }
+		return countdown(n - 1);
+	};
+	[sum(500, 0), countdown(500)]
+	`
+
+	tests := []struct {
+		tailCall              bool
+		expectedMaxFrameIndex int
+	}{
+		{false, 502},
+		{true, 2},
+	}
+
+	for _, test := range tests {
+		vm, err := runWithTailCall(t, input, test.tailCall)
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		testExpectedObject(t, []int{125250, 0}, vm.LastPoppedStackElem())
+
+		if vm.maxFrameIndex != test.expectedMaxFrameIndex {
+			t.Errorf("wrong frame usage (tail call %t), expected %d, actual %d",
+				test.tailCall, test.expectedMaxFrameIndex, vm.maxFrameIndex)
+		}
+	}
+}
+
+func TestTailCallOnlyInTailPosition(t *testing.T) {
+	tests := []vmTestCase{
+		// 递归调用的结果还需要参与运算，不是尾调用
+		{`let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(10)`, 10},
+		// consequence 分支的调用不会被识别，但结果仍然正确
+		{`let f = fn(n) { if (n > 0) { f(n - 1) } else { n } }; f(10)`, 0},
+		// 调用内置函数不会复用调用帧
+		{`let f = fn(a) { len(a) }; f([1, 2])`, 2},
+		{`let f = fn(a) { iterate(fn(x) { x * 2 }, a, 3) }; f(1)`, 8},
+	}
+
+	for _, test := range tests {
+		vm, err := runWithTailCall(t, test.input, true)
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, test.expected, vm.LastPoppedStackElem())
+	}
+
+	_, err := runWithTailCall(t,
+		`let f = fn(a) { a }; let g = fn() { f(1, 2) }; g()`, true)
+	expected := "wrong number of arguments, expected 1, actual 2"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong VM error: expected %q, actual %v", expected, err)
+	}
+}
+
+// 调用帧会被重新使用，确保递归和闭包的结果不受影响
+func TestFrameReuse(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`
+			let fibonacci = fn(x) {
+				if (x < 2) { return x; }
+				fibonacci(x - 1) + fibonacci(x - 2)
+			};
+			fibonacci(15)
+			`,
+			610,
+		},
+		{
+			`
+			let newAdder = fn(a) { fn(b) { fn(c) { a + b + c } } };
+			let addOne = newAdder(1);
+			let addThree = addOne(2);
+			[addThree(3), newAdder(10)(20)(30), addOne(5)(5)]
+			`,
+			[]int{6, 60, 11},
+		},
+		{
+			`
+			let counter = fn(n) { if (n == 0) { 0 } else { 1 + counter(n - 1) } };
+			let twice = fn(f, x) { f(f(x)) };
+			[counter(10), twice(fn(x) { counter(x) + x }, 5), counter(3)]
+			`,
+			[]int{10, 20, 3},
+		},
+		{
+			`
+			let wrapper = fn() {
+				let inner = fn(x) { if (x == 0) { 0 } else { x + inner(x - 1) } };
+				inner(10) + inner(5)
+			};
+			[wrapper(), wrapper()]
+			`,
+			[]int{70, 70},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestOpcodeCounts(t *testing.T) {
+	tests := []struct {
+		input     string
+		profiling bool
+		expected  map[code.Opcode]int
+	}{
+		{
+			"1 + 2 * 3",
+			true,
+			map[code.Opcode]int{
+				code.OpConstant: 3,
+				code.OpMul:      1,
+				code.OpAdd:      1,
+				code.OpPop:      1,
+			},
+		},
+		{
+			"let f = fn(x) { x * 2 }; f(1); f(2);",
+			true,
+			map[code.Opcode]int{
+				code.OpClosure:     1,
+				code.OpSetGlobal:   1,
+				code.OpGetGlobal:   2,
+				code.OpConstant:    4,
+				code.OpCall:        2,
+				code.OpGetLocal:    2,
+				code.OpMul:         2,
+				code.OpReturnValue: 2,
+				code.OpPop:         2,
+			},
+		},
+		{
+			"1 + 2 * 3",
+			false,
+			map[code.Opcode]int{},
+		},
+	}
+
+	for _, test := range tests {
+		vm, err := runVmWith(t, test.input, nil, func(vm *VM) { vm.SetProfiling(test.profiling) })
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		counts := vm.OpcodeCounts()
+		if len(counts) != len(test.expected) {
+			t.Errorf("wrong number of opcodes for %q, expected %v, actual %v",
+				test.input, test.expected, counts)
+			continue
+		}
+		for op, count := range test.expected {
+			if counts[op] != count {
+				t.Errorf("wrong count of opcode %d for %q, expected %d, actual %d",
+					op, test.input, count, counts[op])
+			}
+		}
+	}
+}
+
+func TestBlockExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = { 1; 2; 3 }; x", 3},
+		{"{ let a = 5; a * 2 }", 10},
+		{"{ let a = 1; }", Null},
+		{"{ 1 } + { 2 }", 3},
+		{"let f = fn() { let y = { let t = 2; t * 3 }; y + 1 }; f()", 7},
+		{"let f = fn(n) { { if (n > 0) { n } else { 0 - n } } }; f(-4)", 4},
+		{"{ {1: 2}[1] }", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssertAndPanic(t *testing.T) {
+	tests := []vmTestCase{
+		{`assert(1 == 1)`, Null},
+		{`assert(1 == 1); 10`, 10},
+		{`assert(0, "zero is truthy")`, Null},
+		{`assert(1, 2, 3)`, &object.Error{
+			Message: "wrong number of arguments, expected 1 or 2, actual 3"}},
+	}
+	runVmTests(t, tests)
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`assert(1 == 2); 10`, "assertion failed"},
+		{`let a = []; assert(len(a) > 0, "empty array"); 10`, "assertion failed: empty array"},
+		{`panic("something wrong"); 10`, "something wrong"},
+		{`let f = fn(x) { panic(x) }; f([1, 2]); 10`, "[1, 2]"},
+		// 回调函数里产生的致命错误同样会中止执行
+		{`iterate(fn(x) { assert(x < 2); x + 1 }, 0, 5); 10`, "assertion failed"},
+		{`sort([2, 1], fn(a, b) { panic("in sort") }); 10`, "in sort"},
+	}
+
+	for _, test := range errorTests {
+		_, err := runVm(t, test.input)
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none. input: %s", test.input)
+		}
+		if err.Error() != test.expected {
+			t.Errorf("wrong VM error: expected %q, actual %q", test.expected, err)
+		}
+	}
+}
+
+func TestStrictErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		strict   bool
+		expected string // 严格模式下期望的错误信息，非严格模式下期望的结果
+	}{
+		{`len(5); 10`, false, "10"},
+		{`len(5); 10`, true, "argument type to `len` not supported, actual INTEGER"},
+		{`first(1)`, false, "ERROR: argument type to `first` must be ARRAY, actual INTEGER"},
+		{`first(1)`, true, "argument type to `first` must be ARRAY, actual INTEGER"},
+		{`iterate(fn(x) { len(x) }, 1, 1)`, true,
+			"argument type to `len` not supported, actual INTEGER"},
+		{`len([1, 2]) + 2`, true, "4"},
+	}
+
+	for _, test := range tests {
+		vm, err := runVmWith(t, test.input, nil, func(vm *VM) { vm.SetStrictErrors(test.strict) })
+
+		var actual string
+		if err != nil {
+			actual = err.Error()
+		} else {
+			actual = vm.LastPoppedStackElem().Inspect()
+		}
+
+		if actual != test.expected {
+			t.Errorf("wrong result for %q (strict %t), expected %q, actual %q",
+				test.input, test.strict, test.expected, actual)
+		}
+	}
+}
+
+func TestLocalsOf(t *testing.T) {
+	input := `
+	let f = fn(a) {
+		let b = a * 2;
+		let c = b + 1;
+		c
+	};
+	f(3)
+	`
+
+	program := parse(input)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+
+	// 执行到函数 f 的 OpReturnValue 指令之前
+	for {
+		halted, err := vm.Step()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if halted {
+			t.Fatalf("program halted before entering the function")
+		}
+
+		frame := vm.currentFrame()
+		if vm.frameIndex == 2 &&
+			code.Opcode(frame.Instructions()[frame.ip+1]) == code.OpReturnValue {
+			break
+		}
+	}
+
+	frames := vm.Frames()
+	if len(frames) != 2 {
+		t.Fatalf("wrong number of frames, expected 2, actual %d", len(frames))
+	}
+
+	testExpectedObject(t, []int{3, 6, 7}, &object.Array{Elements: vm.LocalsOf(frames[1])})
+
+	if len(vm.LocalsOf(frames[0])) != 0 {
+		t.Errorf("main frame has locals: %v", vm.LocalsOf(frames[0]))
+	}
+
+	expected := fmt.Sprintf("Frame{ip: %d, basePointer: 1, numLocals: 3}", frames[1].ip)
+	if frames[1].String() != expected {
+		t.Errorf("wrong frame string, expected %q, actual %q", expected, frames[1].String())
+	}
+}
+
+func TestStepThroughInstructions(t *testing.T) {
+	program := parse("1 + 2")
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+
+	tests := []struct {
+		expectedOp       code.Opcode
+		expectedOperands []int
+		expectedStack    []int // 执行这条指令之后运算栈的内容
+	}{
+		{code.OpConstant, []int{0}, []int{1}},
+		{code.OpConstant, []int{1}, []int{1, 2}},
+		{code.OpAdd, []int{}, []int{3}},
+		{code.OpPop, []int{}, []int{}},
+	}
+
+	for i, test := range tests {
+		op, operands := vm.CurrentInstruction()
+		if op != test.expectedOp {
+			t.Fatalf("tests [%d] - wrong opcode, expected %d, actual %d",
+				i, test.expectedOp, op)
+		}
+		if fmt.Sprint(operands) != fmt.Sprint(test.expectedOperands) {
+			t.Fatalf("tests [%d] - wrong operands, expected %v, actual %v",
+				i, test.expectedOperands, operands)
+		}
+
+		halted, err := vm.Step()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if halted != (i == len(tests)-1) {
+			t.Fatalf("tests [%d] - wrong halted state: %t", i, halted)
+		}
+
+		testExpectedObject(t, test.expectedStack,
+			&object.Array{Elements: vm.StackSnapshot()})
+	}
+
+	_, operands := vm.CurrentInstruction()
+	if operands != nil {
+		t.Errorf("halted VM has current instruction operands: %v", operands)
+	}
+
+	halted, err := vm.Step()
+	if !halted || err != nil {
+		t.Errorf("step after halted, expected (true, nil), actual (%t, %v)", halted, err)
+	}
+	testExpectedObject(t, 3, vm.LastPoppedStackElem())
+}
+
+func TestBreakpoints(t *testing.T) {
+	input := `
+	let f = fn(x) { x + 1 };
+	let a = 1;
+	let b = f(2);
+	a + b
+	`
+	program := parse(input)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+
+	// 常量池：0 -> 1（函数体里的），1 -> 函数 f，2 -> 1，3 -> 2
+	// 函数 f 的 0005 是 OpAdd，main 函数的 0030 是 OpAdd
+	err = vm.SetBreakpoint(1, 5)
+	if err != nil {
+		t.Fatalf("set breakpoint error: %s", err)
+	}
+	err = vm.SetBreakpoint(MainFunction, 30)
+	if err != nil {
+		t.Fatalf("set breakpoint error: %s", err)
+	}
+
+	tests := []struct {
+		expectedDepth  int
+		expectedOffset int
+		expectedTop    []int // 运算栈栈顶的两个元素
+	}{
+		{2, 5, []int{2, 1}},
+		{1, 30, []int{1, 3}},
+	}
+
+	for i, test := range tests {
+		hit, err := vm.RunToBreakpoint()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if !hit {
+			t.Fatalf("tests [%d] - breakpoint not hit", i)
+		}
+
+		depth, offset := vm.CurrentPosition()
+		if depth != test.expectedDepth || offset != test.expectedOffset {
+			t.Fatalf("tests [%d] - wrong position, expected (%d, %d), actual (%d, %d)",
+				i, test.expectedDepth, test.expectedOffset, depth, offset)
+		}
+
+		op, _ := vm.CurrentInstruction()
+		if op != code.OpAdd {
+			t.Fatalf("tests [%d] - wrong instruction, expected %d, actual %d",
+				i, code.OpAdd, op)
+		}
+
+		stack := vm.StackSnapshot()
+		testExpectedObject(t, test.expectedTop,
+			&object.Array{Elements: stack[len(stack)-2:]})
+	}
+
+	hit, err := vm.RunToBreakpoint()
+	if hit || err != nil {
+		t.Fatalf("expected program to halt, actual (%t, %v)", hit, err)
+	}
+	testExpectedObject(t, 4, vm.LastPoppedStackElem())
+
+	errorTests := []struct {
+		index    int
+		expected string
+	}{
+		{0, "constant 0 is not a function, actual INTEGER"},
+		{10, "constant index out of range: 10"},
+	}
+	for _, test := range errorTests {
+		err := vm.SetBreakpoint(test.index, 0)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("wrong error, expected %q, actual %v", test.expected, err)
+		}
+	}
+}
+
+func TestUnaryPlusErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"+true", "unsupported type for unary plus: BOOLEAN"},
+		{`+"5"`, "unsupported type for unary plus: STRING"},
+		{"+[1]", "unsupported type for unary plus: ARRAY"},
+	}
+
+	for _, test := range tests {
+		_, err := runVm(t, test.input)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("wrong VM error: expected %q, actual %v", test.expected, err)
+		}
+	}
+}
+
+func TestNullArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + if (false) { 2 }", "cannot use NULL in arithmetic: INTEGER NULL"},
+		{"if (false) { 2 } * 3", "cannot use NULL in arithmetic: NULL INTEGER"},
+		{`let f = fn() { }; f() + "a"`, "cannot use NULL in arithmetic: NULL STRING"},
+		{"let x = if (false) { 1 }; x += 1", "cannot use NULL in arithmetic: NULL INTEGER"},
+		{"let x = if (false) { 1 }; x = x - 1", "cannot use NULL in arithmetic: NULL INTEGER"},
+		// 其他类型的错误信息不变
+		{`1 - "a"`, "unsupported types for binary operation: INTEGER STRING"},
+	}
+
+	for _, test := range tests {
+		_, err := runVm(t, test.input)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("wrong VM error for %q: expected %q, actual %v", test.input, test.expected, err)
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = 2", 2},
+		{"let x = 1; x += 2; x", 3},
+		{"let x = 10; x -= 3; x *= 2; x /= 7; x", 2},
+		{"let a = 0; let b = 0; a = b = 5; a + b", 10},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{"let f = fn() { let x = 1; x += 41; x }; f()", 42},
+		{"let n = 0; let inc = fn() { n += 1 }; inc(); inc(); [inc(), n]", []int{3, 3}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestIncrementExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = x + 1; x", 2},
+		{"let x = 1; [x += 1, x -= 1, x = x - 1]", []int{2, 1, 0}},
+		{"let f = fn(n) { n = n + 1; n += 1; n }; f(5)", 7},
+		{"let f = fn() { let i = -1; i -= 1 }; f()", -2},
+		{"let n = 0; let inc = fn() { n = n + 1 }; inc(); inc(); n", 2},
+	}
+	runVmTests(t, tests)
+
+	// 跟 OpAdd 一样，非整数的值返回错误
+	_, err := RunSource(`let s = "a"; s += 1`)
+	if err == nil || !strings.Contains(err.Error(), "unsupported types for binary operation: STRING INTEGER") {
+		t.Errorf("wrong error, actual %v", err)
+	}
+}
+
+// 直接执行 OpDup 指令，检查栈顶的值被复制
+func TestDupInstruction(t *testing.T) {
+	instructions := code.Instructions{}
+	for _, ins := range []code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpDup),
+	} {
+		instructions = append(instructions, ins...)
+	}
+
+	vm := New(&compiler.Bytecode{
+		Instructions: instructions,
+		Constants:    []object.Object{&object.Integer{Value: 7}},
+	})
+	err := vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	stack := vm.StackSnapshot()
+	testExpectedObject(t, []int{7, 7}, &object.Array{Elements: stack})
+	if stack[0] != stack[1] {
+		t.Errorf("OpDup should push the same object")
+	}
+
+	vm = New(&compiler.Bytecode{Instructions: code.Make(code.OpDup)})
+	err = vm.Run()
+	expected := "stack underflow: OpDup requires 1 element"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong VM error: expected %q, actual %v", expected, err)
+	}
+}
+
+// 运算栈为空时执行 OpPop 返回错误，而不是越界访问运算栈
+func TestPopInstructionUnderflow(t *testing.T) {
+	vm := New(&compiler.Bytecode{Instructions: code.Make(code.OpPop)})
+	err := vm.Run()
+	expected := "stack underflow: OpPop requires 1 element"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong VM error: expected %q, actual %v", expected, err)
+	}
+}
+
+// 没有值的语句块（比如最后一条语句是 let 语句）作为表达式时，其值为 Null
+func TestStatementsWithoutValue(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 1; let b = 2; a + b", 3},
+		{"if (1 > 0) { let a = 1 }", Null},
+		{"let z = 5; let y = if (z > 0) { let a = 1 }; y", Null},
+		{"let z = 5; let y = if (z > 0) { } else { 2 }; y", Null},
+		{"let z = 5; let y = if (z > 0) { } else { 2 }; z", 5},
+		{"let z = 5; if (z < 0) { 1 } else { let b = 2 }", Null},
+		{"if (true) { let a = 1 }", Null},
+		{"let f = fn(x) { if (x > 0) { return 1 } else { let b = 2 } }; f(1)", 1},
+		{"let f = fn(x) { if (x > 0) { return 1 } else { let b = 2 } }; f(-1)", Null},
+		{"let f = fn(x) { if (x > 0) { return 1 }; 2 }; f(1) + f(-1)", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+// 直接执行 OpSwap 指令，检查栈顶两个值的顺序被交换
+func TestSwapInstruction(t *testing.T) {
+	instructions := code.Instructions{}
+	for _, ins := range []code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpSwap),
+	} {
+		instructions = append(instructions, ins...)
+	}
+
+	vm := New(&compiler.Bytecode{
+		Instructions: instructions,
+		Constants: []object.Object{
+			&object.Integer{Value: 1},
+			&object.Integer{Value: 2},
+		},
+	})
+	err := vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, []int{2, 1}, &object.Array{Elements: vm.StackSnapshot()})
+
+	tests := []code.Instructions{
+		code.Make(code.OpSwap),
+		append(code.Make(code.OpConstant, 0), code.Make(code.OpSwap)...),
+	}
+	for _, instructions := range tests {
+		vm := New(&compiler.Bytecode{
+			Instructions: instructions,
+			Constants:    []object.Object{&object.Integer{Value: 1}},
+		})
+		err := vm.Run()
+		expected := "stack underflow: OpSwap requires 2 elements"
+		if err == nil || err.Error() != expected {
+			t.Errorf("wrong VM error: expected %q, actual %v", expected, err)
+		}
+	}
+}
+
+func TestArrayIndexAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"let arr = [1, 2, 3]; arr[0] = 99; arr", []int{99, 2, 3}},
+		{"let arr = [1, 2, 3]; arr[2] = 99", 99},
+		{"let arr = [1, 2, 3]; arr[1] = arr[0] + arr[2]; arr", []int{1, 4, 3}},
+		// 数组是引用，修改会影响所有引用同一数组的变量
+		{"let a = [1, 2]; let b = a; b[0] = 5; a", []int{5, 2}},
+		{"let set = fn(arr) { arr[1] = 0; }; let a = [1, 2]; set(a); a", []int{1, 0}},
+		{"let m = [[1, 2], [3, 4]]; m[1][0] = 9; m[1]", []int{9, 4}},
+	}
+	runVmTests(t, tests)
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"let arr = [1, 2]; arr[2] = 0", "index out of range: 2, length 2"},
+		{"let arr = [1, 2]; arr[-1] = 0", "index out of range: -1, length 2"},
+		{`let arr = [1, 2]; arr["a"] = 0`, "index assignment not supported: ARRAY[STRING]"},
+		{`let s = "ab"; s[0] = "c"`, "index assignment not supported: STRING[INTEGER]"},
+	}
+
+	for _, test := range errorTests {
+		_, err := runVm(t, test.input)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("wrong VM error: expected %q, actual %v", test.expected, err)
+		}
+	}
+}
+
+func TestHashIndexAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`let h = {"a": 1}; h["b"] = 2; h`,
+			map[object.HashKey]int64{
+				(&object.String{Value: "a"}).HashKey(): 1,
+				(&object.String{Value: "b"}).HashKey(): 2,
+			},
+		},
+		{
+			`let h = {"a": 1, "b": 2}; h["a"] = 10; h`,
+			map[object.HashKey]int64{
+				(&object.String{Value: "a"}).HashKey(): 10,
+				(&object.String{Value: "b"}).HashKey(): 2,
+			},
+		},
+		{`let h = {}; h[1] = "one"; h[true] = "yes"; h[1] + h[true]`, "oneyes"},
+		{`let h = {}; h["k"] = 5`, 5},
+	}
+	runVmTests(t, tests)
+
+	_, err := runVm(t, `let h = {}; h[[1]] = 0`)
+	expected := "unusable as hash key: ARRAY"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong VM error: expected %q, actual %v", expected, err)
+	}
+}
+
+func TestRunSource(t *testing.T) {
+	result, err := RunSource("1 + 2")
+	if err != nil {
+		t.Fatalf("RunSource error: %s", err)
+	}
+	integer, ok := result.(*object.Integer)
+	if !ok || integer.Value != 3 {
+		t.Errorf("wrong result, expected Integer 3, actual %T %+v", result, result)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 1; let y 2", "parser errors: expected next token type \"IDENT\", actual \"=\"; expected next token type \"=\", actual \"INT\""},
+		{"foo", "compilation failed: undefined variable foo"},
+		{"-true", "executing bytecode failed: unsupported type for negation: BOOLEAN"},
+	}
+
+	for _, test := range tests {
+		_, err := RunSource(test.input)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("wrong error, expected %q, actual %v", test.expected, err)
+		}
+	}
+}
+
+func TestDefaultParameters(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn(a, b = 10) { a + b }; f(5)", 15},
+		{"let f = fn(a, b = 10) { a + b }; f(5, 1)", 6},
+		{"let f = fn(a = 1, b = 2, c = 3) { [a, b, c] }; f()", []int{1, 2, 3}},
+		{"let f = fn(a = 1, b = 2, c = 3) { [a, b, c] }; f(7, 8)", []int{7, 8, 3}},
+		// 默认值在定义函数时求值
+		{"let n = 1; let f = fn(a = n * 2) { a }; let n = 5; f()", 2},
+		{"let make = fn(x) { fn(y = x) { y } }; let g = make(4); [g(), g(9)]", []int{4, 9}},
+		// 有默认值的参数也是局部变量
+		{"let f = fn(a, b = 1) { let c = a + b; c * 2 }; f(2)", 6},
+		// 递归调用以及被内置函数回调
+		{"let f = fn(n, acc = 0) { if (n == 0) { acc } else { f(n - 1, acc + n) } }; f(4)", 10},
+		{"iterate(fn(x, step = 3) { x + step }, 0, 2)", 6},
+	}
+	runVmTests(t, tests)
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(a, b = 10) { a + b }; f()", "wrong number of arguments, expected 1 to 2, actual 0"},
+		{"let f = fn(a, b = 10) { a + b }; f(1, 2, 3)", "wrong number of arguments, expected 1 to 2, actual 3"},
+	}
+
+	for _, test := range errorTests {
+		_, err := runVm(t, test.input)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("wrong VM error: expected %q, actual %v", test.expected, err)
+		}
+	}
+
+	// 尾调用同样补充默认值
+	vm, err := runWithTailCall(t,
+		"let f = fn(n, acc = 0) { if (n == 0) { acc } else { f(n - 1) } }; f(300)", true)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 0, vm.LastPoppedStackElem())
+}
+
+func TestFunctionDeclarations(t *testing.T) {
+	tests := []vmTestCase{
+		{"fn add(a, b) { a + b } add(1, 2)", 3},
+		{"fn add(a, b) { a + b }; add(1, 2)", 3},
+		{"fn fact(n) { if (n == 0) { 1 } else { n * fact(n - 1) } } fact(5)", 120},
+		{"fn outer() { fn inner(x) { x * 2 } inner(21) } outer()", 42},
+		{"fn greet(name = \"world\") { \"hello \" + name } greet()", "hello world"},
+	}
+	runVmTests(t, tests)
+}
+
+func TestCopyBuiltin(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1, [2, 3]]; let b = copy(a); b[0] = 9; b[1][0] = 9; a[0] + a[1][0]", 3},
+		{`let h = {"k": [1]}; let c = copy(h); c["k"][0] = 5; c["n"] = 1; [len(h), h["k"][0]]`, []int{1, 1}},
+		{"copy(5)", 5},
+	}
+	runVmTests(t, tests)
+}
+
+func TestTopLevelReturn(t *testing.T) {
+	tests := []vmTestCase{
+		{"return 42;", 42},
+		{"return 42; 100", 42},
+		{"let a = 1; if (a == 1) { return 10; } 20", 10},
+		{"let a = 2; if (a == 1) { return 10; } 20", 20},
+		{"let f = fn() { return 1; }; return f() + 1; f()", 2},
+		{"{ let x = 3; return x * 2; }; 0", 6},
+	}
+	runVmTests(t, tests)
+
+	// 程序结束之后，运算栈里不应该残留其他的值
+	vm, err := runVm(t, "let a = [1, 2]; return a[0] + 1; puts(a)")
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 2, vm.LastPoppedStackElem())
+	if vm.sp != 0 {
+		t.Errorf("wrong stack pointer after return, expected 0, actual %d", vm.sp)
+	}
+}
+
+func TestChainedComparisons(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 < 5 < 10", true},
+		{"1 < 20 < 10", false},
+		{"10 > 5 > 1", true},
+		{"1 < 2 < 3 < 4", true},
+		{"4 < 3 < 10", false},
+		{"let f = fn(x) { 0 < x * 2 < 10 }; f(3)", true},
+		{"let f = fn(x) { 0 < x * 2 < 10 }; f(6)", false},
+		{"true && 2", 2},
+		{"false && 2", false},
+		// 中间的操作数只求值一次
+		{"let n = 0; let f = fn() { n = n + 1; 5 }; 1 < f() < 10; n", 1},
+		{"let n = 0; let f = fn() { n = n + 1; 5 }; 30 < f() < 40; n", 1},
+		{"let g = fn() { let a = 1; let f = fn() { 5 }; let b = 1 < f() < 10; let c = 5 > f() > 1; if (b) { if (c) { 3 } else { 2 } } else { 1 } }; g()", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAppendBuiltin(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1]; append(a, 2); append(a, 3); a", []int{1, 2, 3}},
+		{"let a = [1]; let b = append(a, 2); b[0] = 9; a", []int{9, 2}},
+		{"let a = [1]; let b = push(a, 2); a", []int{1}},
+		{"append(1, 2)", &object.Error{Message: "argument type to `append` must be ARRAY, actual INTEGER"}},
+	}
+	runVmTests(t, tests)
+
+	// 在循环里构建一个含有 1000 个元素的数组
+	input := "let arr = iterate(fn(a) { append(a, len(a) * 2) }, [], 1000); arr"
+	vm, err := runVm(t, input)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	array, ok := vm.LastPoppedStackElem().(*object.Array)
+	if !ok {
+		t.Fatalf("object not Array, actual %T", vm.LastPoppedStackElem())
+	}
+	if len(array.Elements) != 1000 {
+		t.Fatalf("wrong number of elements, expected 1000, actual %d", len(array.Elements))
+	}
+	for i, element := range array.Elements {
+		err := testIntegerObject(int64(i*2), element)
+		if err != nil {
+			t.Fatalf("element %d: %s", i, err)
+		}
+	}
+}
+
+func BenchmarkBuildArray(b *testing.B) {
+	benchmarks := []struct {
+		name  string
+		input string
+	}{
+		{"push", "iterate(fn(a) { push(a, 1) }, [], 1000)"},
+		{"append", "iterate(fn(a) { append(a, 1) }, [], 1000)"},
+	}
+
+	for _, bm := range benchmarks {
+		bytecode, _, err := compiler.CompileSource(bm.input)
+		if err != nil {
+			b.Fatalf("compiler error: %s", err)
+		}
+
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				vm := New(bytecode)
+				err := vm.Run()
+				if err != nil {
+					b.Fatalf("vm error: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestConditionalExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true ? 10 : 20", 10},
+		{"false ? 10 : 20", 20},
+		{"1 > 2 ? 10 : 2 > 1 ? 20 : 30", 20},
+		{"let max = fn(a, b) { a > b ? a : b }; max(3, 7) + max(9, 2)", 16},
+		{`let a = 3; let h = {"x": a > 2 ? "big" : "small"}; h["x"]`, "big"},
+		{"let n = 0; let f = fn() { n = n + 1 }; true ? 1 : f(); n", 0},
+	}
+	runVmTests(t, tests)
+}
+
+func TestSmallIntegerCache(t *testing.T) {
+	// 两个结果相同的小整数是同一个实例
+	vm, err := runVm(t, "[1 + 2, 5 - 2, len([1, 2, 3]), 300 + 1, 600 / 2 + 1]")
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	elements := vm.LastPoppedStackElem().(*object.Array).Elements
+	if elements[0] != elements[1] || elements[0] != elements[2] {
+		t.Errorf("small integers with the same value should be identical: %p %p %p",
+			elements[0], elements[1], elements[2])
+	}
+	if elements[0] != object.NewInteger(3) {
+		t.Errorf("small integer result should come from the cache")
+	}
+
+	// 超出缓存范围的整数每次都是新的实例，但值仍然相同
+	if elements[3] == elements[4] {
+		t.Errorf("large integers should not be cached")
+	}
+	testExpectedObject(t, 301, elements[3])
+	testExpectedObject(t, 301, elements[4])
+}
+
+func TestModuloAndFloorDivision(t *testing.T) {
+	tests := []vmTestCase{
+		{"7 % 3", 1},
+		{"-7 / 2", -3},
+		{"-7 % 2", -1},
+		{"7 % -2", 1},
+		{"2 * 5 % 3", 1},
+		{"let x = 17; x - x / 5 * 5 == x % 5", true},
+	}
+	runVmTests(t, tests)
+
+	floorTests := []struct {
+		input    string
+		expected int
+	}{
+		{"-7 / 2", -4},
+		{"-7 % 2", 1},
+		{"7 / -2", -4},
+		{"7 % -2", -1},
+		{"-7 / -2", 3},
+		{"-7 % -2", -1},
+		{"-8 / 2", -4},
+		{"-8 % 2", 0},
+		{"7 / 2", 3},
+		{"7 % 2", 1},
+	}
+
+	for _, test := range floorTests {
+		vm, err := runVmWith(t, test.input, nil, func(vm *VM) { vm.SetFloorDivision(true) })
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, test.expected, vm.LastPoppedStackElem())
+	}
+
+	for _, input := range []string{"1 / 0", "1 % 0"} {
+		_, err := RunSource(input)
+		expected := "executing bytecode failed: division by zero"
+		if err == nil || err.Error() != expected {
+			t.Errorf("wrong error for %q, expected %q, actual %v", input, expected, err)
+		}
+	}
+}
+
+func TestAtBuiltin(t *testing.T) {
+	tests := []vmTestCase{
+		{"at([1, 2, 3], 1)", 2},
+		{"at([1, 2, 3], 3)", &object.Error{Message: "index out of range: 3, length 3"}},
+	}
+	runVmTests(t, tests)
+
+	// 严格模式下越界访问中止执行
+	_, err := runVmWith(t, "at([1, 2, 3], 5); 10", nil, func(vm *VM) { vm.SetStrictErrors(true) })
+	if err == nil || err.Error() != "index out of range: 5, length 3" {
+		t.Errorf("expected VM error, actual %v", err)
+	}
+}
+
+func TestHashInsertionOrder(t *testing.T) {
+	tests := []vmTestCase{
+		{"keys({3: 1, 1: 2, 2: 3})", []int{3, 1, 2}},
+		{"keys({})", []int{}},
+		// 新的 key 添加到末尾，更新已存在的 key 不改变顺序
+		{"let h = {3: 1, 1: 2}; h[0] = 3; h[3] = 4; keys(h)", []int{3, 1, 0}},
+		{"keys(1)", &object.Error{Message: "argument type to `keys` must be HASH, actual INTEGER"}},
+		{"values({3: 1, 1: 2, 2: 3})", []int{1, 2, 3}},
+		{"let h = {3: 1, 1: 2}; h[0] = 3; h[3] = 4; values(h)", []int{4, 2, 3}},
+		{"values(1)", &object.Error{Message: "argument type to `values` must be HASH, actual INTEGER"}},
+	}
+	runVmTests(t, tests)
+
+	inspects := []struct {
+		input    string
+		expected string
+	}{
+		{`{3: 1, 1: 2, 2: 3}`, "{3: 1, 1: 2, 2: 3}"},
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h`, "{b: 1, a: 2, c: 3}"},
+	}
+
+	for _, test := range inspects {
+		vm, err := runVm(t, test.input)
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		actual := vm.LastPoppedStackElem().Inspect()
+		if actual != test.expected {
+			t.Errorf("wrong Inspect for %q, expected %q, actual %q",
+				test.input, test.expected, actual)
+		}
+	}
+}
+
+func TestLessThan(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 < 2", true},
+		{"2 < 1", false},
+		{"1 < 1", false},
+		{"-3 < -2", true},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		// 操作数按照源码的顺序（从左到右）求值
+		{"let s = []; let f = fn(x) { append(s, x); x }; f(1) < f(2); s", []int{1, 2}},
+		{"let s = []; let f = fn(x) { append(s, x); x }; f(2) > f(1); s", []int{2, 1}},
+	}
+	runVmTests(t, tests)
+}
+
+// 手工构造局部变量索引超出范围的字节码
+func TestLocalIndexOutOfRange(t *testing.T) {
+	tests := []struct {
+		fnInstructions []code.Instructions
+		expected       string
+	}{
+		{
+			[]code.Instructions{
+				code.Make(code.OpGetLocal, 1),
+				code.Make(code.OpReturnValue),
+			},
+			"local index out of range: 1, number of locals 1",
+		},
+		{
+			[]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpSetLocal, 5),
+				code.Make(code.OpReturn),
+			},
+			"local index out of range: 5, number of locals 1",
+		},
+	}
+
+	for _, test := range tests {
+		fn := &object.CompiledFunction{
+			Instructions: concatInstructions(test.fnInstructions),
+			NumLocals:    1,
+		}
+		mainInstructions := concatInstructions([]code.Instructions{
+			code.Make(code.OpClosure, 0, 0),
+			code.Make(code.OpCall, 0),
+			code.Make(code.OpPop),
+		})
+
+		vm := New(&compiler.Bytecode{
+			Instructions: mainInstructions,
+			Constants:    []object.Object{fn},
+		})
+		err := vm.Run()
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("wrong VM error, expected %q, actual %v", test.expected, err)
+		}
+	}
+}
+
+func concatInstructions(instructions []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	inputs := []string{
+		"let f = fn() { f() }; f()",
+		"let f = fn() { 1 + f() }; f()",
+	}
+
+	for _, input := range inputs {
+		_, err := runVm(t, input)
+		if err == nil || err.Error() != "max call depth exceeded" {
+			t.Errorf("wrong VM error for %q, expected %q, actual %v",
+				input, "max call depth exceeded", err)
+		}
+	}
+
+	// 开启尾调用检测之后，尾递归不受调用深度的限制
+	vm, err := runWithTailCall(t,
+		"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(5000)", true)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 0, vm.LastPoppedStackElem())
+}
+
+func TestExit(t *testing.T) {
+	tests := []vmTestCase{
+		{"exit(5); 10", 5},
+		{"exit(); 10", Null},
+		{"let a = [1]; exit(a[0] + 1); a[0] = 100; a[0]", 2},
+		// 在函数里调用也结束整个程序
+		{"let f = fn(x) { exit(x * 2); x }; let r = f(21); r + 1", 42},
+		{"let f = fn() { let g = fn() { exit(7) }; g(); 1 }; f() + 100", 7},
+		// 在内置函数的回调里调用
+		{"let f = fn(x) { if (x > 3) { exit(x * 10) }; x + 1 }; iterate(f, 0, 100); 0", 40},
+		// 通过变量间接调用，或者作为回调函数
+		{"let e = exit; e(1); 10", 1},
+		{"let e = exit; let f = fn() { e(); 1 }; f(); 10", Null},
+		{"iterate(exit, 3, 5); 10", 3},
+		{"let e = exit; e(1, 2); 10", 10},
+		// 同名的变量覆盖了内置函数
+		{"let exit = fn(x) { x + 1 }; exit(1); 10", 10},
+	}
+	runVmTests(t, tests)
+
+	// exit() 之后的代码不会被执行
+	output := &bytes.Buffer{}
+	object.SetOutput(output)
+	defer object.SetOutput(os.Stdout)
+
+	vm, err := runVm(t, `puts("a"); exit(1); puts("b")`)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if output.String() != "a\n" {
+		t.Errorf("wrong output, expected %q, actual %q", "a\n", output.String())
+	}
+
+	halted, err := vm.Step()
+	if err != nil || !halted {
+		t.Errorf("vm should stay halted after exit, actual halted=%t, err=%v", halted, err)
+	}
+	testExpectedObject(t, 1, vm.LastPoppedStackElem())
+}
+
+func TestResult(t *testing.T) {
+	tests := []struct {
+		input         string
+		keepLastValue bool
+		expected      interface{}
+	}{
+		{"1; 2 + 3", true, 5},
+		{"1; 2 + 3", false, 5},
+		{`let a = "x"; a`, true, "x"},
+		{"let f = fn(x) { x * 2 }; f(21)", true, 42},
+		{"if (1 > 2) { 10 }", true, Null},
+		{"exit(7); 1", true, 7},
+	}
+
+	for _, test := range tests {
+		compile := func(comp *compiler.Compiler, program *ast.Program) error {
+			comp.SetKeepLastValue(test.keepLastValue)
+			return comp.Compile(program)
+		}
+		vm, err := runVmWith(t, test.input, compile, nil)
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		testExpectedObject(t, test.expected, vm.Result())
+	}
+}
+
+func TestCompileExpressionResult(t *testing.T) {
+	tests := []vmTestCase{
+		{"2 * 3", 6},
+		{`"a" + "b"`, "ab"},
+		{"len([1, 2, 3]) > 2", true},
+		{"fn(x) { x + 1 }(41)", 42},
+		{"if (1 > 2) { 10 }", Null},
+	}
+
+	// 只编译第一个表达式语句的表达式
+	compile := func(comp *compiler.Compiler, program *ast.Program) error {
+		return comp.CompileExpression(program.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+
+	for _, test := range tests {
+		vm, err := runVmWith(t, test.input, compile, nil)
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		// 值保留在栈顶，而不是被弹出
+		if vm.sp != 1 {
+			t.Errorf("value of %q is not left on the stack, sp %d", test.input, vm.sp)
+		}
+		testExpectedObject(t, test.expected, vm.Result())
+	}
+}
+
+func TestJsonBuiltin(t *testing.T) {
+	tests := []vmTestCase{
+		{`json({"a": [1, "x", true], "b": if (false) { 1 }})`, `{"a":[1,"x",true],"b":null}`},
+		{`let h = {"z": 1}; h["y"] = {}; json(h)`, `{"z":1,"y":{}}`},
+		{`json({1: 2})`, &object.Error{Message: "hash key type to `json` must be STRING, actual INTEGER"}},
+		{`json([fn() { 1 }])`, &object.Error{Message: "argument type to `json` not supported, actual CLOSURE"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestParseJsonBuiltin(t *testing.T) {
+	tests := []vmTestCase{
+		{`parseJson("[1, 2, 3]")`, []int{1, 2, 3}},
+		{`let h = parseJson("{\"a\": {\"b\": 2}}"); h["a"]["b"]`, 2},
+		{`parseJson("true") == true`, true},
+		{`parseJson("[null]")[0] == if (false) { 1 }`, true},
+		{`keys(parseJson("{\"z\": 1, \"a\": 2}"))[0]`, "z"},
+		{`parseJson("[")`, &object.Error{Message: "invalid JSON: unexpected end of JSON input"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestEmptyFalsy(t *testing.T) {
+	inputs := []string{
+		`if ([]) { 1 } else { 0 }`,
+		`if ([0]) { 1 } else { 0 }`,
+		`if ({}) { 1 } else { 0 }`,
+		`if ({"a": 1}) { 1 } else { 0 }`,
+		`if ("") { 1 } else { 0 }`,
+		`if ("a") { 1 } else { 0 }`,
+		`let h = {}; h["k"] = 1; if (h) { 1 } else { 0 }`,
+		`if (!"") { 1 } else { 0 }`,
+		`if (![1]) { 1 } else { 0 }`,
+		`[] ? 1 : 0`,
+		`if ("" && [1]) { 1 } else { 0 }`,
+		`if (0) { 1 } else { 0 }`,
+		`if (false) { 1 } else { 0 }`,
+	}
+	// 默认的结果，以及开启 SetEmptyFalsy 之后的结果
+	defaults := []int{1, 1, 1, 1, 1, 1, 1, 0, 0, 1, 1, 1, 0}
+	emptyFalsy := []int{0, 1, 0, 1, 0, 1, 1, 1, 0, 0, 0, 1, 0}
+
+	for i, input := range inputs {
+		for _, enabled := range []bool{false, true} {
+			vm, err := runVmWith(t, input, nil, func(vm *VM) { vm.SetEmptyFalsy(enabled) })
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+
+			expected := defaults[i]
+			if enabled {
+				expected = emptyFalsy[i]
+			}
+			err = testIntegerObject(int64(expected), vm.LastPoppedStackElem())
+			if err != nil {
+				t.Errorf("wrong result for %q (emptyFalsy %t): %s", input, enabled, err)
+			}
+		}
+	}
+}
+
+// 作为语句的 if 不在运算栈留下值
+func TestIfStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (1 > 2) { 10 }; 3333", 3333},
+		{"if (1 < 2) { 10 }; 3333", 3333},
+		{"let x = 0; if (true) { x = 1 }; if (1 > 2) { x = 2 } else { x = x + 10 }; x", 11},
+		{"let f = fn(n) { let r = 0; if (n > 0) { let y = n; r = y }; r }; f(5) + f(-1)", 5},
+		{"let f = fn(n) { if (n > 0) { return 1 }; 0 }; [f(1), f(0)]", []int{1, 0}},
+		{"let s = []; let f = fn(n) { if (n > 0) { append(s, n); f(n - 1) }; s }; f(3)", []int{3, 2, 1}},
+		{"if (1 < 2) { }; 1", 1},
+	}
+	runVmTests(t, tests)
+}
+
+func TestConstStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"const x = 10; x * 2", 20},
+		{"const f = fn(n) { const k = 3; n * k }; f(4)", 12},
+		{"const x = 1; let g = fn() { x + 1 }; g()", 2},
+	}
+	runVmTests(t, tests)
+}
+
+func TestBlockScopes(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 1; if (true) { let a = 2; a } + a", 3},
+		{"let f = fn() { let a = 1; if (a) { let a = 2; a = a + 10 }; a }; f()", 1},
+		{"let f = fn(n) { if (n > 0) { let t = n * 2; t } else { let t = 0; t - 1 } }; [f(3), f(0)]", []int{6, -1}},
+		// 闭包捕获语句块里的变量
+		{"let f = fn() { if (true) { let t = 5; fn() { t } } }; f()()", 5},
+		{"let f = fn(x) { let y = { let t = x + 1; t * 2 }; y }; f(1)", 4},
+		// 语句块里对外层变量的赋值仍然作用于外层的变量
+		{"let f = fn() { let a = 1; if (true) { a = 2 }; a }; f()", 2},
+	}
+	runVmTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		// 没有发生错误时，值是 try 语句块的值
+		{"try { 1 + 2 } catch (e) { 0 }", 3},
+		{"let a = 10; try { a / 2 } catch (e) { 0 } + 1", 6},
+		// 捕获除以零的错误
+		{"try { 1 / 0 } catch (e) { 99 }", 99},
+		{"try { 1 / 0 } catch (e) { e }", &object.Error{Message: "division by zero"}},
+		// 错误发生时运算栈上残留的值被丢弃
+		{"1 + try { 2 + [1, 2, 1 / 0][0] } catch (e) { 3 }", 4},
+		// 捕获内置函数的错误
+		{"try { len(1) } catch (e) { e }",
+			&object.Error{Message: "argument type to `len` not supported, actual INTEGER"}},
+		// 捕获被调用的函数里发生的错误
+		{`let inner = fn(a) { a / 0 };
+		let outer = fn(a) { let b = 5; try { inner(a) } catch (e) { b } };
+		outer(1) + 1`, 6},
+		// 在 try 语句块里返回，处理器不再有效
+		{`let f = fn() { try { return 1 } catch (e) { 2 } };
+		f() + try { f() } catch (e) { 10 }`, 2},
+		// 嵌套的 try 表达式
+		{"try { try { 1 / 0 } catch (e) { 2 / 0 } } catch (e) { 3 }", 3},
+		{"try { try { 1 / 0 } catch (e) { 2 } + 1 / 0 } catch (e) { 3 }", 3},
+		{"try { } catch (e) { 1 }", Null},
+		// 捕获的变量只在 catch 语句块里有效
+		{"let e = 1; try { 1 / 0 } catch (e) { 2 }; e", 1},
+	}
+	runVmTests(t, tests)
+
+	// 致命错误不会被捕获
+	_, err := RunSource(`try { panic("boom") } catch (e) { 1 }`)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("fatal error should not be caught, actual error %v", err)
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; match x { 1 => 10, 2 => 20, _ => 30 }", 10},
+		{"let x = 2; match x { 1 => 10, 2 => 20, _ => 30 }", 20},
+		{"let x = 3; match x { 1 => 10, 2 => 20, _ => 30 }", 30},
+		// 没有匹配的分支，也没有默认分支
+		{"match 3 { 1 => 10, 2 => 20 }", Null},
+		// 只匹配第一个相等的分支
+		{"match 1 { 1 => 10, 1 => 20 }", 10},
+		// 不同类型的值不相等
+		{`match "1" { 1 => 10, "1" => 20, _ => 30 }`, 20},
+		{`let f = fn(n) { match n % 3 { 0 => "fizz", _ => { let m = n * 2; m } } }; f(3)`, "fizz"},
+		{`let f = fn(n) { match n % 3 { 0 => "fizz", _ => { let m = n * 2; m } } }; f(4)`, 8},
+		{"[match [1, 2] { [1, 2] => 1, _ => 2 }, match true { false => 1, true => 2 }]", []int{1, 2}},
+		// 被匹配的值只计算一次，并且不会留在运算栈
+		{"let f = fn() { let a = 1; a = a + (match a { 1 => 5, _ => 6 }); a }; f()", 6},
+		{"1 + match 2 { 2 => 3 } * 2", 7},
+	}
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	input := `let inner = fn(a) {
+	a / 0
+};
+let outer = fn(a) {
+	let b = a + 1;
+	inner(b)
+};
+outer(1);`
+
+	bytecode, parserErrors, err := compiler.CompileSource(input)
+	if len(parserErrors) != 0 || err != nil {
+		t.Fatalf("compile failed: %v %v", parserErrors, err)
+	}
+
+	vm := New(bytecode)
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected a runtime error")
+	}
+
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError, actual %T (%+v)", err, err)
+	}
+
+	if runtimeErr.Error() != "division by zero" {
+		t.Errorf("wrong error message, actual %q", runtimeErr.Error())
+	}
+
+	expected := []StackFrame{
+		{Function: "inner", Line: 2},
+		{Function: "outer", Line: 6},
+		{Function: "<main>", Line: 8},
+	}
+	if len(runtimeErr.Frames) != len(expected) {
+		t.Fatalf("wrong number of frames, expected %d, actual %d: %+v",
+			len(expected), len(runtimeErr.Frames), runtimeErr.Frames)
+	}
+	for i, frame := range expected {
+		if runtimeErr.Frames[i] != frame {
+			t.Errorf("wrong frame %d, expected %+v, actual %+v", i, frame, runtimeErr.Frames[i])
+		}
+	}
+
+	trace := runtimeErr.StackTrace()
+	if !strings.Contains(trace, "at inner (line 2)") || !strings.Contains(trace, "at outer (line 6)") {
+		t.Errorf("stack trace does not contain both frames: %q", trace)
+	}
+}
+
+func TestCharsBuiltin(t *testing.T) {
+	tests := []vmTestCase{
+		{`len(chars("héllo"))`, 5},
+		{`chars("a😀")[1]`, "😀"},
+		{`chars("")`, []int{}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestOrdChrBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`ord("A")`, 65},
+		{`chr(65)`, "A"},
+		{`chr(ord("a") + 1)`, "b"},
+		// 凯撒密码
+		{`let shift = fn(cs, i) {
+			if (i < len(cs)) { chr(ord(cs[i]) + 3) + shift(cs, i + 1) } else { "" }
+		};
+		shift(chars("abc"), 0)`, "def"},
+	}
+
+	runVmTests(t, tests)
+}